@@ -0,0 +1,607 @@
+// btree/blink/blink.go
+package blink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Common errors returned by BLinkTree operations.
+var (
+	ErrCorruptPage     = errors.New("corrupt blink page")
+	ErrInvalidMaxKeys  = errors.New("invalid max keys per node")
+	ErrRootPageMissing = errors.New("btree has pages but no blink root page")
+)
+
+// rootPageID is the page holding the root. It is the first page allocated
+// for a tree and never moves: a root that splits hands its halves to two new
+// pages and becomes their parent.
+const rootPageID buffermanager.PageID = 1
+
+// scanBatchSize bounds how many pairs a scan collects before handing them to
+// the consumer.
+const scanBatchSize = 256
+
+// Option represents a configuration option for a BLinkTree.
+type Option func(*config)
+
+// WithMaxKeys limits the number of keys stored in a node before it splits.
+// Small values are mostly useful to exercise splits in tests.
+func WithMaxKeys(n int) Option {
+	return func(c *config) {
+		c.maxKeys = n
+	}
+}
+
+// config holds the internal configuration for a BLinkTree.
+type config struct {
+	maxKeys int
+}
+
+// BLinkTree implements the BTree interface as a B-link tree, after Lehman
+// and Yao, "Efficient Locking for Concurrent Operations on B-Trees" (1981),
+// on top of pages managed by a BufferManager. Every node records a high key
+// and a link to its right sibling, so a search that reaches a node after a
+// concurrent split moved part of its keys away finds them by following the
+// link.
+//
+// Readers take no latches: lookups, scans and cursors work on copies of
+// whole pages and recover from splits they missed through right links.
+// Writers latch one node at a time, moving right and then up, plus the
+// parent while they insert the separator of a split. Nodes are never merged
+// or freed, so a page ID a reader picked up earlier always leads to a valid
+// node: Delete and DeleteRange only remove keys from leaves, and emptied
+// leaves stay in place to take later inserts. DeleteRange empties one leaf at
+// a time, so it is not atomic with respect to concurrent inserts.
+//
+// Writers run in parallel when the buffer manager is a buffermanager.Latcher
+// and not a Committer, and one at a time otherwise. With a Committer,
+// whose units would mix the pages of concurrent operations, readers run one
+// at a time too. Pages are copied out of the buffer pool under a shared pin
+// and into it under an exclusive one, which provides the atomic page reads
+// and writes the algorithm assumes.
+type BLinkTree struct {
+	mu              sync.Mutex // Serializes writers without latches, and everything with a Committer
+	bm              buffermanager.BufferManager
+	latches         buffermanager.Latcher // nil if writers run one at a time
+	serial          bool                  // Readers hold mu as well
+	btreeID         string
+	maxLeafKeys     int
+	maxInternalKeys int
+	version         uint64 // Incremented atomically after every modification
+}
+
+// New opens the B-link tree stored in the pages of btreeID, initializing an
+// empty tree if the BTree has no pages yet.
+func New(bm buffermanager.BufferManager, btreeID string, options ...Option) (*BLinkTree, error) {
+	cfg := config{}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	t := &BLinkTree{
+		bm:              bm,
+		btreeID:         btreeID,
+		maxLeafKeys:     leafCapacity - 1,
+		maxInternalKeys: internalCapacity - 1,
+	}
+	if cfg.maxKeys != 0 {
+		// One spare slot per page lets a node overflow before it is split.
+		if cfg.maxKeys < 3 || cfg.maxKeys > internalCapacity-1 {
+			return nil, fmt.Errorf("%w: %d (must be between 3 and %d)", ErrInvalidMaxKeys, cfg.maxKeys, internalCapacity-1)
+		}
+		t.maxLeafKeys = cfg.maxKeys
+		t.maxInternalKeys = cfg.maxKeys
+	}
+	_, t.serial = bm.(buffermanager.Committer)
+	if latches, ok := bm.(buffermanager.Latcher); ok && !t.serial {
+		t.latches = latches
+	}
+
+	if err := t.commit(t.load()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Factory returns a TreeFactory that opens B-link trees with the given
+// options, for use with buffermanager.WithTreeFactory.
+func Factory(options ...Option) buffermanager.TreeFactory {
+	return func(bm buffermanager.BufferManager, btreeID string) (btree.BTree, error) {
+		return New(bm, btreeID, options...)
+	}
+}
+
+// load checks the root page, creating an empty root leaf when the tree is
+// brand new.
+func (t *BLinkTree) load() error {
+	if _, err := t.read(rootPageID); !errors.Is(err, buffermanager.ErrPageNotFound) {
+		return err
+	}
+	rootID, err := t.allocate()
+	if err != nil {
+		return err
+	}
+	if rootID != rootPageID {
+		return ErrRootPageMissing
+	}
+	root := make(node, buffermanager.PageSize)
+	root.init(pageTypeLeaf, 0)
+	return t.write(rootID, root)
+}
+
+// commit ends a modification that returned err. If the buffer manager is a
+// Committer, the pages a successful modification changed are committed as one
+// unit, so a crash never leaves a split half done, and the changes of a
+// failed one are rolled back so that no later Commit makes them durable.
+func (t *BLinkTree) commit(err error) error {
+	c, ok := t.bm.(buffermanager.Committer)
+	if !ok {
+		return err
+	}
+	if err != nil {
+		if rollbackErr := c.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return c.Commit()
+}
+
+// lockReader takes the tree lock for a read if reads need it and returns the
+// function that releases it.
+func (t *BLinkTree) lockReader() func() {
+	if !t.serial {
+		return func() {}
+	}
+	t.mu.Lock()
+	return t.mu.Unlock
+}
+
+// lockWriter takes the tree lock for a modification if writers do not latch
+// pages and returns the function that releases it.
+func (t *BLinkTree) lockWriter() func() {
+	if t.latches != nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	return t.mu.Unlock
+}
+
+// latch acquires the exclusive latch of a page, if the tree uses latches.
+func (t *BLinkTree) latch(pageID buffermanager.PageID) {
+	if t.latches != nil {
+		t.latches.LatchPage(t.btreeID, pageID, true)
+	}
+}
+
+// unlatch releases a latch acquired through latch.
+func (t *BLinkTree) unlatch(pageID buffermanager.PageID) {
+	if t.latches != nil {
+		t.latches.UnlatchPage(t.btreeID, pageID, true)
+	}
+}
+
+// read returns a copy of a page of this tree as a node.
+func (t *BLinkTree) read(pageID buffermanager.PageID) (node, error) {
+	return t.readInto(pageID, nil)
+}
+
+// readInto is read that reuses the memory of buf, a node that is no longer
+// needed, if it is large enough.
+func (t *BLinkTree) readInto(pageID buffermanager.PageID, buf node) (node, error) {
+	n := buf[:cap(buf)]
+	if len(n) < buffermanager.PageSize {
+		n = make(node, buffermanager.PageSize)
+	}
+	err := t.withPage(pageID, false, func(data []byte) {
+		copy(n, data[:node(data).size()])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n.pageType() != pageTypeLeaf && n.pageType() != pageTypeInternal {
+		return nil, fmt.Errorf("%w: page %d has type %d", ErrCorruptPage, pageID, n.pageType())
+	}
+	return n, nil
+}
+
+// write stores n as the contents of a page of this tree.
+func (t *BLinkTree) write(pageID buffermanager.PageID, n node) error {
+	return t.withPage(pageID, true, func(data []byte) {
+		copy(data, n[:n.size()])
+	})
+}
+
+// withPage pins a page of this tree and calls f with its contents, which f
+// modifies if dirty is set. The pin is exclusive if dirty is set and shared
+// otherwise.
+func (t *BLinkTree) withPage(pageID buffermanager.PageID, dirty bool, f func(data []byte)) error {
+	mode := buffermanager.PinShared
+	if dirty {
+		mode = buffermanager.PinExclusive
+	}
+	handle, err := t.bm.PinPage(t.btreeID, pageID, mode)
+	if err != nil {
+		return err
+	}
+	f(handle.Data())
+	if dirty {
+		handle.MarkDirty()
+	}
+	return handle.Release()
+}
+
+// allocate allocates a new page for this tree.
+func (t *BLinkTree) allocate() (buffermanager.PageID, error) {
+	return t.bm.AllocatePage(t.btreeID)
+}
+
+// maxKeys returns how many keys n holds before it splits.
+func (t *BLinkTree) maxKeys(n node) int {
+	if n.isLeaf() {
+		return t.maxLeafKeys
+	}
+	return t.maxInternalKeys
+}
+
+// search descends from the root to the node at level that covers key and
+// returns a copy of it together with its page ID. If stack is not nil, the
+// page IDs of the nodes the search moved down from are appended to it, root
+// first.
+func (t *BLinkTree) search(key uint64, level int, stack *[]buffermanager.PageID) (node, buffermanager.PageID, error) {
+	n, err := t.read(rootPageID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t.searchFrom(rootPageID, n, key, level, stack)
+}
+
+// searchFrom is search starting at n, a copy of page pageID, instead of the
+// root. It moves right wherever key lies beyond the node it reached.
+func (t *BLinkTree) searchFrom(pageID buffermanager.PageID, n node, key uint64, level int, stack *[]buffermanager.PageID) (node, buffermanager.PageID, error) {
+	var err error
+	for {
+		for n.tooHigh(key) {
+			pageID = n.link()
+			if n, err = t.readInto(pageID, n); err != nil {
+				return nil, 0, err
+			}
+		}
+		if n.level() == level {
+			return n, pageID, nil
+		}
+		if n.level() < level || n.isLeaf() {
+			return nil, 0, fmt.Errorf("%w: page %d is at level %d, searching level %d", ErrCorruptPage, pageID, n.level(), level)
+		}
+		if stack != nil {
+			*stack = append(*stack, pageID)
+		}
+		pageID = n.child(n.childIndex(key))
+		if n, err = t.readInto(pageID, n); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// latchAt latches the node at level that covers key and returns a copy of
+// it together with its page ID. The search starts at pageID, a node found
+// at that level earlier or one of its ancestors, and moves right, latching
+// the sibling before letting go of a node. If the start turns out to be the
+// root grown taller meanwhile, latchAt releases it and descends again like
+// search, appending to stack. The caller releases the latch with unlatch.
+func (t *BLinkTree) latchAt(pageID buffermanager.PageID, key uint64, level int, stack *[]buffermanager.PageID) (node, buffermanager.PageID, error) {
+	for {
+		t.latch(pageID)
+		n, err := t.read(pageID)
+		for err == nil && n.tooHigh(key) {
+			next := n.link()
+			t.latch(next)
+			t.unlatch(pageID)
+			pageID = next
+			n, err = t.readInto(pageID, n)
+		}
+		if err != nil {
+			t.unlatch(pageID)
+			return nil, 0, err
+		}
+		if n.level() == level {
+			return n, pageID, nil
+		}
+
+		// Writers only ever wait for latches to the right and above the ones
+		// they hold, so the root is not kept latched on the way down.
+		t.unlatch(pageID)
+		if _, pageID, err = t.searchFrom(pageID, n, key, level, stack); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// Lookup finds the value associated with the given key.
+func (t *BLinkTree) Lookup(key uint64) (uint64, bool) {
+	defer t.lockReader()()
+
+	leaf, _, err := t.search(key, 0, nil)
+	if err != nil {
+		return 0, false
+	}
+	i := leaf.leafSearch(key)
+	if i < leaf.numKeys() && leaf.leafKey(i) == key {
+		return leaf.leafValue(i), true
+	}
+	return 0, false
+}
+
+// Insert adds or updates a key-value pair in the tree.
+func (t *BLinkTree) Insert(key uint64, value uint64) error {
+	defer t.lockWriter()()
+	err := t.insert(key, value)
+	atomic.AddUint64(&t.version, 1)
+	return t.commit(err)
+}
+
+// insert implements Insert.
+func (t *BLinkTree) insert(key uint64, value uint64) error {
+	var stack []buffermanager.PageID
+	_, leafID, err := t.search(key, 0, &stack)
+	if err != nil {
+		return err
+	}
+	leaf, leafID, err := t.latchAt(leafID, key, 0, &stack)
+	if err != nil {
+		return err
+	}
+
+	i := leaf.leafSearch(key)
+	if i < leaf.numKeys() && leaf.leafKey(i) == key {
+		leaf.setLeafEntry(i, key, value)
+	} else {
+		leaf.leafInsertAt(i, key, value)
+	}
+	return t.store(leafID, leaf, stack)
+}
+
+// store writes n, a modified copy of the latched page pageID, back and
+// releases the latch. An overflowing node is split first, and the separator
+// is inserted into its parent, found through stack, which may split in
+// turn. The parent is latched before the child is released.
+func (t *BLinkTree) store(pageID buffermanager.PageID, n node, stack []buffermanager.PageID) error {
+	for {
+		if n.numKeys() <= t.maxKeys(n) {
+			err := t.write(pageID, n)
+			t.unlatch(pageID)
+			return err
+		}
+		if pageID == rootPageID {
+			err := t.splitRoot(n)
+			t.unlatch(pageID)
+			return err
+		}
+
+		separator, rightID, err := t.split(pageID, n)
+		if err == nil && len(stack) == 0 {
+			err = fmt.Errorf("%w: page %d at level %d has no parent", ErrCorruptPage, pageID, n.level())
+		}
+		if err != nil {
+			t.unlatch(pageID)
+			return err
+		}
+		parentID := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		parent, parentID, err := t.latchAt(parentID, separator, n.level()+1, &stack)
+		t.unlatch(pageID)
+		if err != nil {
+			return err
+		}
+		parent.internalInsertAt(parent.childIndex(separator), separator, rightID)
+		pageID, n = parentID, parent
+	}
+}
+
+// split moves the upper half of n, an overflowing copy of page pageID, to a
+// new right sibling and writes both pages, the sibling first so that it is
+// complete before the link to it appears. It returns the separator and the
+// sibling's page ID.
+func (t *BLinkTree) split(pageID buffermanager.PageID, n node) (uint64, buffermanager.PageID, error) {
+	rightID, err := t.allocate()
+	if err != nil {
+		return 0, 0, err
+	}
+	right := make(node, buffermanager.PageSize)
+	separator := n.split(right, rightID)
+	if err := t.write(rightID, right); err != nil {
+		return 0, 0, err
+	}
+	return separator, rightID, t.write(pageID, n)
+}
+
+// splitRoot moves the halves of n, an overflowing copy of the root, to two
+// new pages and turns the root into their parent, one level higher.
+func (t *BLinkTree) splitRoot(n node) error {
+	leftID, err := t.allocate()
+	if err != nil {
+		return err
+	}
+	rightID, err := t.allocate()
+	if err != nil {
+		return err
+	}
+	right := make(node, buffermanager.PageSize)
+	separator := n.split(right, rightID)
+	if err := t.write(rightID, right); err != nil {
+		return err
+	}
+	if err := t.write(leftID, n); err != nil {
+		return err
+	}
+
+	root := make(node, buffermanager.PageSize)
+	root.init(pageTypeInternal, n.level()+1)
+	root.setChild(0, leftID)
+	root.internalInsertAt(0, separator, rightID)
+	return t.write(rootPageID, root)
+}
+
+// Scan retrieves all key-value pairs within the given range.
+func (t *BLinkTree) Scan(minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.ScanContext(context.Background(), minKey, maxKey)
+}
+
+// ScanContext retrieves all key-value pairs within the given range until ctx
+// is cancelled. Pairs are gathered in batches by following the right links
+// of the leaves and sent without holding any pins.
+func (t *BLinkTree) ScanContext(ctx context.Context, minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	from := minKey
+	results, scanErr := stream(ctx, func() ([]btree.KeyValuePair, bool, error) {
+		if minKey > maxKey {
+			return nil, false, nil
+		}
+		batch, more, err := t.collect(from, maxKey, scanBatchSize)
+		if more {
+			from = batch[len(batch)-1].Key + 1
+		}
+		return batch, more, err
+	})
+	return results, scanErr, nil
+}
+
+// ReverseScan retrieves all key-value pairs within the given range in
+// descending key order.
+func (t *BLinkTree) ReverseScan(maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.ReverseScanContext(context.Background(), maxKey, minKey)
+}
+
+// ReverseScanContext retrieves all key-value pairs within the given range in
+// descending key order until ctx is cancelled. Leaves have no left links, so
+// it descends again for the key below each leaf, and batches pairs like
+// ScanContext.
+func (t *BLinkTree) ReverseScanContext(ctx context.Context, maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	from := maxKey
+	results, scanErr := stream(ctx, func() ([]btree.KeyValuePair, bool, error) {
+		if minKey > maxKey {
+			return nil, false, nil
+		}
+		batch, more, err := t.collectReverse(from, minKey, scanBatchSize)
+		if more {
+			from = batch[len(batch)-1].Key - 1
+		}
+		return batch, more, err
+	})
+	return results, scanErr, nil
+}
+
+// stream sends the batches returned by successive calls to next on a channel
+// until next reports that no pairs remain, next fails or ctx is cancelled.
+// The returned ScanErrFunc reports which of these ended the stream.
+func stream(ctx context.Context, next func() ([]btree.KeyValuePair, bool, error)) (<-chan btree.KeyValuePair, btree.ScanErrFunc) {
+	results := make(chan btree.KeyValuePair)
+	done := make(chan struct{})
+	var scanErr error
+
+	go func() {
+		defer close(done)
+		defer close(results)
+		for {
+			batch, more, err := next()
+			if err != nil {
+				scanErr = err
+				return
+			}
+			for _, kv := range batch {
+				select {
+				case results <- kv:
+				case <-ctx.Done():
+					scanErr = ctx.Err()
+					return
+				}
+			}
+			if !more {
+				return
+			}
+		}
+	}()
+
+	return results, func() error {
+		<-done
+		return scanErr
+	}
+}
+
+// collect gathers up to limit pairs with from <= key <= maxKey by following
+// the right links of the leaves. more reports whether the range may hold
+// further pairs.
+func (t *BLinkTree) collect(from, maxKey uint64, limit int) (batch []btree.KeyValuePair, more bool, err error) {
+	defer t.lockReader()()
+
+	leaf, _, err := t.search(from, 0, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		for i := leaf.leafSearch(from); i < leaf.numKeys(); i++ {
+			key := leaf.leafKey(i)
+			if key > maxKey {
+				return batch, false, nil
+			}
+			if len(batch) == limit {
+				return batch, true, nil
+			}
+			batch = append(batch, btree.KeyValuePair{Key: key, Value: leaf.leafValue(i)})
+		}
+		if leaf.link() == 0 || leaf.highKey() > maxKey {
+			return batch, false, nil
+		}
+		// The right sibling starts at the high key, even if it was split
+		// since this leaf was read.
+		from = leaf.highKey()
+		if leaf, err = t.readInto(leaf.link(), leaf); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// collectReverse gathers up to limit pairs with minKey <= key <= from in
+// descending order, descending again for the key below the low key of each
+// leaf it has finished. more reports whether the range may hold further
+// pairs.
+func (t *BLinkTree) collectReverse(from, minKey uint64, limit int) (batch []btree.KeyValuePair, more bool, err error) {
+	defer t.lockReader()()
+
+	for {
+		leaf, _, err := t.search(from, 0, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		i := leaf.leafSearch(from)
+		if i < leaf.numKeys() && leaf.leafKey(i) == from {
+			i++
+		}
+		for i--; i >= 0; i-- {
+			key := leaf.leafKey(i)
+			if key < minKey {
+				return batch, false, nil
+			}
+			if len(batch) == limit {
+				return batch, true, nil
+			}
+			batch = append(batch, btree.KeyValuePair{Key: key, Value: leaf.leafValue(i)})
+		}
+		if low := leaf.lowKey(); low == 0 || low <= minKey {
+			return batch, false, nil
+		}
+		from = leaf.lowKey() - 1
+	}
+}
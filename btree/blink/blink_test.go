@@ -0,0 +1,565 @@
+// btree/blink/blink_test.go
+package blink
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/btree/bplustree"
+	"github.com/pillairaunak/btree-store-go/btree/btreetest"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// newTestTree returns a tree over a mock buffer manager, which provides page
+// latches, so writers run in parallel.
+func newTestTree(t *testing.T, options ...Option) (*BLinkTree, buffermanager.Latcher) {
+	t.Helper()
+	bm := buffermanager.NewMockBufferManager(buffermanager.WithBufferSize(64))
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := New(bm, btreeID, options...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if tree.latches == nil {
+		t.Fatal("Expected the tree to use the mock's page latches")
+	}
+	return tree, bm
+}
+
+// checkTree walks every level of the tree from its leftmost node along the
+// right links and fails the test if a node is overfull, holds keys out of
+// order or outside its bounds, does not start where its left neighbour ends,
+// or disagrees with its parent about its bounds. It returns the number of
+// keys stored.
+func checkTree(t *testing.T, tree *BLinkTree) int {
+	t.Helper()
+	read := func(pageID buffermanager.PageID) node {
+		n, err := tree.read(pageID)
+		if err != nil {
+			t.Fatalf("read(%d) failed: %v", pageID, err)
+		}
+		return n
+	}
+
+	total := 0
+	leftmost := rootPageID
+	for level := read(rootPageID).level(); level >= 0; level-- {
+		pageID, first := leftmost, true
+		var high uint64
+		for pageID != 0 {
+			n := read(pageID)
+			if n.level() != level {
+				t.Errorf("Page %d is at level %d, expected %d", pageID, n.level(), level)
+			}
+			if (first && n.lowKey() != 0) || (!first && n.lowKey() != high) {
+				t.Errorf("Page %d starts at %d, expected %d", pageID, n.lowKey(), high)
+			}
+			count := n.numKeys()
+			if count > tree.maxKeys(n) {
+				t.Errorf("Page %d holds %d keys, above the maximum %d", pageID, count, tree.maxKeys(n))
+			}
+			lo, hi := n.lowKey(), n.maxCovered()
+			if n.isLeaf() {
+				for i := 0; i < count; i++ {
+					if k := n.leafKey(i); k < lo || k > hi || (i > 0 && k <= n.leafKey(i-1)) {
+						t.Errorf("Leaf %d has key %d out of order or outside [%d, %d]", pageID, k, lo, hi)
+					}
+				}
+				total += count
+			} else {
+				for i := 0; i <= count; i++ {
+					childLo, childHi := lo, hi
+					if i > 0 {
+						childLo = n.internalKey(i - 1)
+					}
+					if i < count {
+						childHi = n.internalKey(i) - 1
+					}
+					if childLo > childHi {
+						t.Errorf("Internal node %d has key %d out of order or outside [%d, %d]", pageID, childHi+1, lo, hi)
+						continue
+					}
+					child := read(n.child(i))
+					if child.level() != level-1 || child.lowKey() != childLo || child.maxCovered() != childHi {
+						t.Errorf("Child %d of page %d covers [%d, %d] at level %d, expected [%d, %d] at level %d",
+							n.child(i), pageID, child.lowKey(), child.maxCovered(), child.level(), childLo, childHi, level-1)
+					}
+				}
+				if first {
+					leftmost = n.child(0)
+				}
+			}
+			high, pageID, first = n.highKey(), n.link(), false
+		}
+	}
+	return total
+}
+
+// checkContents fails the test unless scans in both directions and a cursor
+// over tree return exactly the pairs of model.
+func checkContents(t *testing.T, tree *BLinkTree, model map[uint64]uint64) {
+	t.Helper()
+	got := btreetest.Contents(t, tree)
+	if len(got) != len(model) {
+		t.Fatalf("Expected %d pairs, got %d", len(model), len(got))
+	}
+	for key, value := range model {
+		if v, found := got[key]; !found || v != value {
+			t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", value, key, v, found)
+		}
+	}
+}
+
+func TestBLinkTree_Splits(t *testing.T) {
+	tree, _ := newTestTree(t, WithMaxKeys(4))
+
+	rng := rand.New(rand.NewSource(1))
+	keys := rng.Perm(2000)
+	model := make(map[uint64]uint64)
+	for _, k := range keys {
+		if err := tree.Insert(uint64(k), uint64(k)*10); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", k, err)
+		}
+		model[uint64(k)] = uint64(k) * 10
+	}
+
+	// Test case 1: Every key is found and the tree is well formed.
+	for _, k := range keys {
+		if value, found := tree.Lookup(uint64(k)); !found || value != uint64(k)*10 {
+			t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", k*10, k, value, found)
+		}
+	}
+	if _, found := tree.Lookup(5000); found {
+		t.Error("Expected key 5000 to not be found")
+	}
+	if n := checkTree(t, tree); n != len(keys) {
+		t.Errorf("Expected %d keys in the tree, got %d", len(keys), n)
+	}
+
+	// Test case 2: The root stays on its page and grows taller.
+	root, err := tree.read(rootPageID)
+	if err != nil {
+		t.Fatalf("read root failed: %v", err)
+	}
+	if root.level() < 3 {
+		t.Errorf("Expected a root at level 3 or more after %d inserts, got %d", len(keys), root.level())
+	}
+
+	// Test case 3: Scans in both directions and cursors see every pair,
+	// also across scan batches.
+	checkContents(t, tree, model)
+	results := btreetest.Drain(t)(tree.ReverseScan(100+2*scanBatchSize+7, 100))
+	if len(results) != 2*scanBatchSize+8 || results[0].Key != 100+2*scanBatchSize+7 {
+		t.Errorf("Expected %d results from %d down, got %d", 2*scanBatchSize+8, 100+2*scanBatchSize+7, len(results))
+	}
+}
+
+func TestBLinkTree_Delete(t *testing.T) {
+	tree, _ := newTestTree(t, WithMaxKeys(4))
+	model := make(map[uint64]uint64)
+	rng := rand.New(rand.NewSource(2))
+
+	for i := 0; i < 4000; i++ {
+		key := uint64(rng.Intn(1000))
+		switch op := rng.Intn(10); {
+		case op < 6:
+			if err := tree.Insert(key, uint64(i)); err != nil {
+				t.Fatalf("Insert(%d) failed: %v", key, err)
+			}
+			model[key] = uint64(i)
+		case op < 9:
+			_, expected := model[key]
+			if found, err := tree.Delete(key); err != nil || found != expected {
+				t.Fatalf("Expected (%v, nil) deleting %d, got (%v, %v)", expected, key, found, err)
+			}
+			delete(model, key)
+		default:
+			maxKey := key + uint64(rng.Intn(50))
+			expected := 0
+			for k := range model {
+				if k >= key && k <= maxKey {
+					delete(model, k)
+					expected++
+				}
+			}
+			if deleted, err := tree.DeleteRange(key, maxKey); err != nil || deleted != expected {
+				t.Fatalf("Expected (%d, nil) deleting [%d, %d], got (%d, %v)", expected, key, maxKey, deleted, err)
+			}
+		}
+	}
+	if n := checkTree(t, tree); n != len(model) {
+		t.Errorf("Expected %d keys in the tree, got %d", len(model), n)
+	}
+	checkContents(t, tree, model)
+
+	// Emptied leaves stay in the tree and take new keys.
+	if deleted, err := tree.DeleteRange(0, ^uint64(0)); err != nil || deleted != len(model) {
+		t.Fatalf("Expected (%d, nil) deleting everything, got (%d, %v)", len(model), deleted, err)
+	}
+	checkContents(t, tree, nil)
+	if err := tree.Insert(500, 1); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	checkContents(t, tree, map[uint64]uint64{500: 1})
+	if deleted, err := tree.DeleteRange(10, 5); err != nil || deleted != 0 {
+		t.Errorf("Expected (0, nil) for an empty range, got (%d, %v)", deleted, err)
+	}
+}
+
+func TestBLinkTree_New(t *testing.T) {
+	t.Run("InvalidMaxKeys", func(t *testing.T) {
+		bm := buffermanager.NewMockBufferManager()
+		btreeID, _ := bm.CreateBTree()
+		for _, n := range []int{2, internalCapacity} {
+			if _, err := New(bm, btreeID, WithMaxKeys(n)); !errors.Is(err, ErrInvalidMaxKeys) {
+				t.Errorf("Expected ErrInvalidMaxKeys for %d, got: %v", n, err)
+			}
+		}
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		bm := buffermanager.NewMockBufferManager()
+		btreeID, _ := bm.CreateBTree()
+		tree, err := New(bm, btreeID, WithMaxKeys(4))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		for k := uint64(0); k < 100; k++ {
+			tree.Insert(k, k+1)
+		}
+		reopened, err := New(bm, btreeID, WithMaxKeys(4))
+		if err != nil {
+			t.Fatalf("New on existing pages failed: %v", err)
+		}
+		for k := uint64(0); k < 100; k++ {
+			if value, found := reopened.Lookup(k); !found || value != k+1 {
+				t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", k+1, k, value, found)
+			}
+		}
+	})
+
+	t.Run("BPlusTreePages", func(t *testing.T) {
+		bm := buffermanager.NewMockBufferManager()
+		btreeID, _ := bm.CreateBTree()
+		if _, err := bplustree.New(bm, btreeID); err != nil {
+			t.Fatalf("bplustree.New failed: %v", err)
+		}
+		if _, err := New(bm, btreeID); !errors.Is(err, ErrCorruptPage) {
+			t.Errorf("Expected ErrCorruptPage, got: %v", err)
+		}
+	})
+}
+
+func TestBLinkTree_FileBufferManager(t *testing.T) {
+	dir := t.TempDir()
+	open := func() buffermanager.BufferManager {
+		bm, err := buffermanager.NewFileBufferManager(
+			buffermanager.WithDirectory(dir),
+			buffermanager.WithBufferSize(8),
+			buffermanager.WithTreeFactory(Factory(WithMaxKeys(8))),
+		)
+		if err != nil {
+			t.Fatalf("NewFileBufferManager failed: %v", err)
+		}
+		return bm
+	}
+
+	bm := open()
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := bm.OpenBTree(btreeID)
+	if err != nil {
+		t.Fatalf("OpenBTree failed: %v", err)
+	}
+	for k := uint64(0); k < 1000; k++ {
+		if err := tree.Insert(k*7%1000, k); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if err := bm.CloseBTree(btreeID); err != nil {
+		t.Fatalf("CloseBTree failed: %v", err)
+	}
+
+	// A fresh manager stands in for a process restart.
+	tree, err = open().OpenBTree(btreeID)
+	if err != nil {
+		t.Fatalf("OpenBTree after restart failed: %v", err)
+	}
+	for k := uint64(0); k < 1000; k++ {
+		if value, found := tree.Lookup(k * 7 % 1000); !found || value != k {
+			t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", k, k*7%1000, value, found)
+		}
+	}
+	if results := btreetest.Drain(t)(tree.Scan(0, 999)); len(results) != 1000 {
+		t.Errorf("Expected 1000 results, got %d", len(results))
+	}
+}
+
+// TestBLinkTree_ReadersTakeNoLatches checks that readers pass a leaf latched
+// by a writer, while other writers wait for it.
+func TestBLinkTree_ReadersTakeNoLatches(t *testing.T) {
+	tree, latches := newTestTree(t, WithMaxKeys(4))
+	for k := uint64(0); k < 100; k++ {
+		if err := tree.Insert(k, k); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", k, err)
+		}
+	}
+	_, leafID, err := tree.search(0, 0, nil)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	latches.LatchPage(tree.btreeID, leafID, true)
+
+	// Test case 1: Lookups, scans and cursors read the latched leaf.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if value, found := tree.Lookup(0); !found || value != 0 {
+			t.Errorf("Expected (0, true), got (%d, %v)", value, found)
+		}
+		results, scanErr, _ := tree.Scan(0, 99)
+		n := 0
+		for range results {
+			n++
+		}
+		if err := scanErr(); err != nil || n != 100 {
+			t.Errorf("Expected 100 pairs, got %d (%v)", n, err)
+		}
+		c, _ := tree.Cursor()
+		if !c.First() || c.Key() != 0 {
+			t.Errorf("Expected the cursor at key 0, got %d (%v)", c.Key(), c.Err())
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected readers to finish while a leaf is latched")
+	}
+
+	// Test case 2: A writer to the latched leaf waits for the latch.
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		if err := tree.Insert(0, 1); err != nil {
+			t.Errorf("Insert failed: %v", err)
+		}
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("Expected Insert(0) to wait for the latched leaf")
+	case <-time.After(50 * time.Millisecond):
+	}
+	latches.UnlatchPage(tree.btreeID, leafID, true)
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Insert(0) to finish once the leaf was unlatched")
+	}
+	if value, _ := tree.Lookup(0); value != 1 {
+		t.Errorf("Expected 1 for key 0, got %d", value)
+	}
+}
+
+// TestBLinkTree_ConcurrentStress runs writers and readers against one tree
+// at once. Each writer owns the keys congruent to its index, so it can check
+// every lookup against its own model. Readers scan in both directions and
+// look up stable keys that no writer touches. Run with -race.
+func TestBLinkTree_ConcurrentStress(t *testing.T) {
+	for _, maxKeys := range []int{4, 16} {
+		t.Run(fmt.Sprintf("MaxKeys%d", maxKeys), func(t *testing.T) {
+			tree, _ := newTestTree(t, WithMaxKeys(maxKeys))
+
+			const (
+				writers   = 8
+				readers   = 4
+				keySpace  = 4000
+				opsEach   = 2000
+				stableLo  = keySpace
+				stableCnt = 200
+			)
+			// Values encode their key, so a reader can tell a value that
+			// belongs to its key from a torn or misplaced one.
+			value := func(key uint64, seq int) uint64 { return key<<20 | uint64(seq) }
+			for k := uint64(stableLo); k < stableLo+stableCnt; k++ {
+				if err := tree.Insert(k, value(k, 0)); err != nil {
+					t.Fatalf("Insert(%d) failed: %v", k, err)
+				}
+			}
+
+			models := make([]map[uint64]uint64, writers)
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				models[w] = make(map[uint64]uint64)
+				wg.Add(1)
+				go func(w int, model map[uint64]uint64) {
+					defer wg.Done()
+					rng := rand.New(rand.NewSource(int64(w)))
+					for seq := 0; seq < opsEach; seq++ {
+						key := uint64(rng.Intn(keySpace/writers)*writers + w)
+						switch op := rng.Intn(10); {
+						case op < 6:
+							if err := tree.Insert(key, value(key, seq)); err != nil {
+								t.Errorf("Insert(%d) failed: %v", key, err)
+								return
+							}
+							model[key] = value(key, seq)
+						case op < 8:
+							_, expected := model[key]
+							found, err := tree.Delete(key)
+							if err != nil || found != expected {
+								t.Errorf("Expected (%v, nil) deleting %d, got (%v, %v)", expected, key, found, err)
+								return
+							}
+							delete(model, key)
+						default:
+							expected, exists := model[key]
+							if v, found := tree.Lookup(key); found != exists || v != expected {
+								t.Errorf("Expected (%d, %v) for key %d, got (%d, %v)", expected, exists, key, v, found)
+								return
+							}
+						}
+					}
+				}(w, models[w])
+			}
+
+			var readerWG sync.WaitGroup
+			for r := 0; r < readers; r++ {
+				readerWG.Add(1)
+				go func(r int) {
+					defer readerWG.Done()
+					rng := rand.New(rand.NewSource(int64(100 + r)))
+					for i := 0; i < 50; i++ {
+						lo := uint64(rng.Intn(keySpace))
+						reverse := rng.Intn(2) == 0
+						var results <-chan btree.KeyValuePair
+						var scanErr btree.ScanErrFunc
+						if reverse {
+							results, scanErr, _ = tree.ReverseScan(stableLo+stableCnt-1, lo)
+						} else {
+							results, scanErr, _ = tree.Scan(lo, stableLo+stableCnt-1)
+						}
+						var pairs []btree.KeyValuePair
+						for p := range results {
+							pairs = append(pairs, p)
+						}
+						if err := scanErr(); err != nil {
+							t.Errorf("Scan failed: %v", err)
+							return
+						}
+						stable := 0
+						for j, p := range pairs {
+							if p.Value>>20 != p.Key || (j > 0 && (p.Key > pairs[j-1].Key) == reverse) {
+								t.Errorf("Expected ordered pairs of their keys (reverse %v), got %v", reverse, p)
+								return
+							}
+							if p.Key >= stableLo {
+								stable++
+							}
+						}
+						if stable != stableCnt {
+							t.Errorf("Expected %d stable keys in the scan, got %d", stableCnt, stable)
+							return
+						}
+
+						key := uint64(stableLo + rng.Intn(stableCnt))
+						if v, found := tree.Lookup(key); !found || v != value(key, 0) {
+							t.Errorf("Expected (%d, true) for stable key %d, got (%d, %v)", value(key, 0), key, v, found)
+							return
+						}
+					}
+				}(r)
+			}
+
+			wg.Wait()
+			readerWG.Wait()
+			if t.Failed() {
+				return
+			}
+
+			// Every model, plus the stable keys, must match the final tree.
+			expected := make(map[uint64]uint64)
+			for _, model := range models {
+				for k, v := range model {
+					expected[k] = v
+				}
+			}
+			for k := uint64(stableLo); k < stableLo+stableCnt; k++ {
+				expected[k] = value(k, 0)
+			}
+			if n := checkTree(t, tree); n != len(expected) {
+				t.Errorf("Expected %d keys in the tree, got %d", len(expected), n)
+			}
+			checkContents(t, tree, expected)
+		})
+	}
+}
+
+// BenchmarkWriteHeavy compares the B-link tree with the latch-crabbing
+// B+Tree of package bplustree on a mix of 60% inserts, 20% deletes and 20%
+// lookups issued by parallel goroutines, e.g.
+//
+//	go test -run=^$ -bench=WriteHeavy -cpu=1,4,8 ./btree/blink
+func BenchmarkWriteHeavy(b *testing.B) {
+	const keySpace = 1 << 16
+	trees := []struct {
+		name    string
+		factory buffermanager.TreeFactory
+	}{
+		{"BLink", Factory()},
+		{"BPlusTree", bplustree.Factory()},
+	}
+
+	for _, tr := range trees {
+		tr := tr
+		b.Run(tr.name, func(b *testing.B) {
+			bm := buffermanager.NewMockBufferManager(buffermanager.WithBufferSize(64))
+			btreeID, err := bm.CreateBTree()
+			if err != nil {
+				b.Fatalf("CreateBTree failed: %v", err)
+			}
+			tree, err := tr.factory(bm, btreeID)
+			if err != nil {
+				b.Fatalf("Opening the tree failed: %v", err)
+			}
+			for k := uint64(0); k < keySpace; k += 2 {
+				if err := tree.Insert(k, k); err != nil {
+					b.Fatalf("Insert failed: %v", err)
+				}
+			}
+
+			var seed int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(atomic.AddInt64(&seed, 1)))
+				for pb.Next() {
+					key := uint64(rng.Intn(keySpace))
+					switch op := rng.Intn(10); {
+					case op < 6:
+						if err := tree.Insert(key, key); err != nil {
+							b.Errorf("Insert failed: %v", err)
+							return
+						}
+					case op < 8:
+						if _, err := tree.Delete(key); err != nil {
+							b.Errorf("Delete failed: %v", err)
+							return
+						}
+					default:
+						tree.Lookup(key)
+					}
+				}
+			})
+		})
+	}
+}
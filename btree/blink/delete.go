@@ -0,0 +1,88 @@
+// btree/blink/delete.go
+package blink
+
+import "sync/atomic"
+
+// Delete removes key from its leaf. Leaves are never merged, however few
+// keys they hold, so readers can keep following the links they picked up.
+func (t *BLinkTree) Delete(key uint64) (bool, error) {
+	defer t.lockWriter()()
+	found, err := t.delete(key)
+	atomic.AddUint64(&t.version, 1)
+	return found, t.commit(err)
+}
+
+// delete implements Delete.
+func (t *BLinkTree) delete(key uint64) (bool, error) {
+	_, leafID, err := t.search(key, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	leaf, leafID, err := t.latchAt(leafID, key, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	defer t.unlatch(leafID)
+
+	i := leaf.leafSearch(key)
+	if i == leaf.numKeys() || leaf.leafKey(i) != key {
+		return false, nil
+	}
+	leaf.leafRemoveRange(i, i+1)
+	return true, t.write(leafID, leaf)
+}
+
+// DeleteRange removes all keys between minKey and maxKey (inclusive). It
+// walks the leaves covering the range from left to right and empties them
+// one at a time, latching the next leaf before it lets go of the current one.
+func (t *BLinkTree) DeleteRange(minKey uint64, maxKey uint64) (int, error) {
+	if minKey > maxKey {
+		return 0, nil
+	}
+	defer t.lockWriter()()
+	deleted, err := t.deleteRange(minKey, maxKey)
+	atomic.AddUint64(&t.version, 1)
+	return deleted, t.commit(err)
+}
+
+// deleteRange implements DeleteRange for minKey <= maxKey.
+func (t *BLinkTree) deleteRange(minKey uint64, maxKey uint64) (int, error) {
+	_, leafID, err := t.search(minKey, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	leaf, leafID, err := t.latchAt(leafID, minKey, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for {
+		i := leaf.leafSearch(minKey)
+		j := i
+		for j < leaf.numKeys() && leaf.leafKey(j) <= maxKey {
+			j++
+		}
+		if j > i {
+			leaf.leafRemoveRange(i, j)
+			if err := t.write(leafID, leaf); err != nil {
+				t.unlatch(leafID)
+				return deleted, err
+			}
+			deleted += j - i
+		}
+		if leaf.link() == 0 || leaf.highKey() > maxKey {
+			t.unlatch(leafID)
+			return deleted, nil
+		}
+
+		nextID := leaf.link()
+		t.latch(nextID)
+		t.unlatch(leafID)
+		leafID = nextID
+		if leaf, err = t.readInto(leafID, leaf); err != nil {
+			t.unlatch(leafID)
+			return deleted, err
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// btree/blink/node.go
+package blink
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Page types stored in the first byte of every page owned by a BLinkTree.
+// They differ from the page types of package bplustree, so opening a tree
+// of the other kind fails instead of misreading its pages.
+const (
+	pageTypeLeaf     byte = 'L'
+	pageTypeInternal byte = 'I'
+)
+
+// Page layout shared by leaf and internal nodes:
+//
+//	[0]      page type
+//	[2:4]    number of keys
+//	[4:6]    level, 0 for leaves
+//	[8:16]   right link PageID
+//	[16:24]  low key
+//	[24:32]  high key
+//	[32:]    entries
+//
+// A node covers the keys k with low <= k < high. The right link points at
+// the next node of the same level, whose low key is this node's high key;
+// PageID 0 marks the rightmost node of a level, which has no high key.
+// Leaf entries are (key, value) pairs of 16 bytes. Internal nodes store
+// child 0 at offset 32 followed by (key, child) pairs, so the child to the
+// right of key i lives directly after it.
+const (
+	offsetType    = 0
+	offsetNumKeys = 2
+	offsetLevel   = 4
+	offsetLink    = 8
+	offsetLow     = 16
+	offsetHigh    = 24
+	headerSize    = 32
+	entrySize     = 16
+
+	leafCapacity     = (buffermanager.PageSize - headerSize) / entrySize
+	internalCapacity = (buffermanager.PageSize - headerSize - 8) / entrySize
+)
+
+// node is a view over a copy of a page that interprets it as a B-link node.
+type node []byte
+
+func (n node) pageType() byte {
+	return n[offsetType]
+}
+
+func (n node) isLeaf() bool {
+	return n[offsetType] == pageTypeLeaf
+}
+
+func (n node) numKeys() int {
+	return int(binary.LittleEndian.Uint16(n[offsetNumKeys:]))
+}
+
+func (n node) setNumKeys(count int) {
+	binary.LittleEndian.PutUint16(n[offsetNumKeys:], uint16(count))
+}
+
+func (n node) level() int {
+	return int(binary.LittleEndian.Uint16(n[offsetLevel:]))
+}
+
+func (n node) link() buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(n[offsetLink:]))
+}
+
+func (n node) lowKey() uint64 {
+	return binary.LittleEndian.Uint64(n[offsetLow:])
+}
+
+func (n node) highKey() uint64 {
+	return binary.LittleEndian.Uint64(n[offsetHigh:])
+}
+
+// setBounds records the keys the node covers and its right neighbour.
+func (n node) setBounds(low, high uint64, link buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(n[offsetLow:], low)
+	binary.LittleEndian.PutUint64(n[offsetHigh:], high)
+	binary.LittleEndian.PutUint64(n[offsetLink:], uint64(link))
+}
+
+// tooHigh reports whether key lies beyond the node, so a search for it must
+// follow the right link.
+func (n node) tooHigh(key uint64) bool {
+	return n.link() != 0 && key >= n.highKey()
+}
+
+// maxCovered returns the largest key the node covers.
+func (n node) maxCovered() uint64 {
+	if n.link() == 0 {
+		return ^uint64(0)
+	}
+	return n.highKey() - 1
+}
+
+// size returns the number of bytes of the page in use.
+func (n node) size() int {
+	if n.isLeaf() {
+		return leafOffset(n.numKeys())
+	}
+	if n.pageType() == pageTypeInternal {
+		return keyOffset(n.numKeys())
+	}
+	return len(n)
+}
+
+// init formats the page as an empty node of the given type and level that
+// covers every key.
+func (n node) init(pageType byte, level int) {
+	for i := 0; i < headerSize; i++ {
+		n[i] = 0
+	}
+	n[offsetType] = pageType
+	binary.LittleEndian.PutUint16(n[offsetLevel:], uint16(level))
+}
+
+// --- Leaf accessors ---
+
+func leafOffset(i int) int {
+	return headerSize + i*entrySize
+}
+
+func (n node) leafKey(i int) uint64 {
+	return binary.LittleEndian.Uint64(n[leafOffset(i):])
+}
+
+func (n node) leafValue(i int) uint64 {
+	return binary.LittleEndian.Uint64(n[leafOffset(i)+8:])
+}
+
+func (n node) setLeafEntry(i int, key, value uint64) {
+	off := leafOffset(i)
+	binary.LittleEndian.PutUint64(n[off:], key)
+	binary.LittleEndian.PutUint64(n[off+8:], value)
+}
+
+// leafSearch returns the index of the first key >= key.
+func (n node) leafSearch(key uint64) int {
+	return sort.Search(n.numKeys(), func(i int) bool { return n.leafKey(i) >= key })
+}
+
+// leafInsertAt shifts entries right and stores (key, value) at index i.
+// The caller must ensure the page has room for one more entry.
+func (n node) leafInsertAt(i int, key, value uint64) {
+	count := n.numKeys()
+	copy(n[leafOffset(i+1):leafOffset(count+1)], n[leafOffset(i):leafOffset(count)])
+	n.setLeafEntry(i, key, value)
+	n.setNumKeys(count + 1)
+}
+
+// leafRemoveRange deletes the entries with indexes in [i, j), shifting later
+// entries left.
+func (n node) leafRemoveRange(i, j int) {
+	count := n.numKeys()
+	copy(n[leafOffset(i):], n[leafOffset(j):leafOffset(count)])
+	n.setNumKeys(count - (j - i))
+}
+
+// --- Internal accessors ---
+
+func childOffset(i int) int {
+	return headerSize + i*entrySize
+}
+
+func keyOffset(i int) int {
+	return headerSize + 8 + i*entrySize
+}
+
+func (n node) internalKey(i int) uint64 {
+	return binary.LittleEndian.Uint64(n[keyOffset(i):])
+}
+
+func (n node) child(i int) buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(n[childOffset(i):]))
+}
+
+func (n node) setChild(i int, id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(n[childOffset(i):], uint64(id))
+}
+
+// childIndex returns the index of the child whose subtree may contain key.
+func (n node) childIndex(key uint64) int {
+	return sort.Search(n.numKeys(), func(i int) bool { return key < n.internalKey(i) })
+}
+
+// internalInsertAt inserts key at index i with child as its right neighbour.
+// The caller must ensure the page has room for one more entry.
+func (n node) internalInsertAt(i int, key uint64, child buffermanager.PageID) {
+	count := n.numKeys()
+	copy(n[keyOffset(i+1):keyOffset(count+1)], n[keyOffset(i):keyOffset(count)])
+	binary.LittleEndian.PutUint64(n[keyOffset(i):], key)
+	n.setChild(i+1, child)
+	n.setNumKeys(count + 1)
+}
+
+// split moves the upper half of an overflowing node into right, an empty page
+// that will live at rightID, and links the two. It returns the separator,
+// the low key of right. The high key and right link of the node pass to
+// right.
+func (n node) split(right node, rightID buffermanager.PageID) uint64 {
+	count := n.numKeys()
+	mid := count / 2
+	var separator uint64
+	right.init(n.pageType(), n.level())
+	if n.isLeaf() {
+		separator = n.leafKey(mid)
+		copy(right[leafOffset(0):], n[leafOffset(mid):leafOffset(count)])
+		right.setNumKeys(count - mid)
+	} else {
+		// The middle key moves up; its right child leads the new node.
+		separator = n.internalKey(mid)
+		right.setChild(0, n.child(mid+1))
+		copy(right[keyOffset(0):], n[keyOffset(mid+1):keyOffset(count)])
+		right.setNumKeys(count - mid - 1)
+	}
+	n.setNumKeys(mid)
+	right.setBounds(separator, n.highKey(), n.link())
+	n.setBounds(n.lowKey(), separator, rightID)
+	return separator
+}
@@ -0,0 +1,686 @@
+// btree/bplustree/bplustree.go
+package bplustree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Common errors returned by BPlusTree operations.
+var (
+	ErrCorruptPage     = errors.New("corrupt bplustree page")
+	ErrInvalidMaxKeys  = errors.New("invalid max keys per node")
+	ErrMetaPageMissing = errors.New("btree has pages but no bplustree meta page")
+	ErrOldFormat       = errors.New("bplustree was written in an older page format")
+)
+
+// metaPageID is the page holding the root pointer. It is the first page
+// allocated for a tree.
+const metaPageID buffermanager.PageID = 1
+
+// scanBatchSize bounds how many pairs a scan collects while holding the tree
+// lock before handing them to the consumer.
+const scanBatchSize = 256
+
+// Option represents a configuration option for a BPlusTree.
+type Option func(*config)
+
+// WithMaxKeys limits the number of keys stored in a node before it splits.
+// Small values are mostly useful to exercise splits in tests.
+func WithMaxKeys(n int) Option {
+	return func(c *config) {
+		c.maxKeys = n
+	}
+}
+
+// config holds the internal configuration for a BPlusTree.
+type config struct {
+	maxKeys int
+}
+
+// BPlusTree implements the BTree interface on top of pages managed by a
+// BufferManager. Every node occupies one page and leaves are chained left to
+// right so range scans never revisit internal nodes.
+//
+// A BPlusTree may be used by several goroutines at once. With a buffer
+// manager that is a buffermanager.Latcher and not a Committer, lookups,
+// scans, inserts and deletes working on different pages run in parallel; see
+// latch.go.
+type BPlusTree struct {
+	mu              sync.RWMutex // Held shared by operations that crab with latches
+	bm              buffermanager.BufferManager
+	latches         buffermanager.Latcher // nil if operations run one at a time
+	btreeID         string
+	maxLeafKeys     int
+	maxInternalKeys int
+	version         uint64 // Incremented atomically by every modification
+}
+
+// New opens the B+Tree stored in the pages of btreeID, initializing an empty
+// tree if the BTree has no pages yet.
+func New(bm buffermanager.BufferManager, btreeID string, options ...Option) (*BPlusTree, error) {
+	cfg := config{}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	t := &BPlusTree{
+		bm:              bm,
+		btreeID:         btreeID,
+		maxLeafKeys:     leafCapacity - 1,
+		maxInternalKeys: internalCapacity - 1,
+	}
+	if cfg.maxKeys != 0 {
+		// One spare slot per page lets a node overflow before it is split.
+		if cfg.maxKeys < 3 || cfg.maxKeys > internalCapacity-1 {
+			return nil, fmt.Errorf("%w: %d (must be between 3 and %d)", ErrInvalidMaxKeys, cfg.maxKeys, internalCapacity-1)
+		}
+		t.maxLeafKeys = cfg.maxKeys
+		t.maxInternalKeys = cfg.maxKeys
+	}
+	if latches, ok := bm.(buffermanager.Latcher); ok {
+		if _, commits := bm.(buffermanager.Committer); !commits {
+			t.latches = latches
+		}
+	}
+
+	if err := t.commit(t.load()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Factory returns a TreeFactory that opens B+Trees with the given options,
+// for use with buffermanager.WithTreeFactory.
+func Factory(options ...Option) buffermanager.TreeFactory {
+	return func(bm buffermanager.BufferManager, btreeID string) (btree.BTree, error) {
+		return New(bm, btreeID, options...)
+	}
+}
+
+// load checks the meta page, creating it and an empty root leaf when the tree
+// is brand new.
+func (t *BPlusTree) load() error {
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinShared)
+	if err == nil {
+		if meta.isOldMeta() {
+			t.unpin(handle, false)
+			return fmt.Errorf("%w: leaves without backward links", ErrOldFormat)
+		}
+		if !meta.isMeta() {
+			t.unpin(handle, false)
+			return fmt.Errorf("%w: page %d is not a meta page", ErrCorruptPage, metaPageID)
+		}
+		return t.unpin(handle, false)
+	}
+	if !errors.Is(err, buffermanager.ErrPageNotFound) {
+		return err
+	}
+
+	metaID, err := t.allocate()
+	if err != nil {
+		return err
+	}
+	if metaID != metaPageID {
+		return ErrMetaPageMissing
+	}
+	rootID, err := t.allocate()
+	if err != nil {
+		return err
+	}
+
+	root, handle, err := t.pin(rootID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	root.initLeaf()
+	if err := t.unpin(handle, true); err != nil {
+		return err
+	}
+
+	meta, handle, err = t.pin(metaPageID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	meta.initMeta(rootID)
+	return t.unpin(handle, true)
+}
+
+// commit ends a modification that returned err. If the buffer manager is a
+// Committer, the pages a successful modification changed are committed as one
+// unit, so a crash never leaves a split or merge half done, and the changes
+// of a failed one are rolled back so that no later Commit makes them durable.
+func (t *BPlusTree) commit(err error) error {
+	c, ok := t.bm.(buffermanager.Committer)
+	if !ok {
+		return err
+	}
+	if err != nil {
+		if rollbackErr := c.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return c.Commit()
+}
+
+// pin pins a page of this tree in the given mode and returns it as a node.
+// Pages that will be unpinned dirty must be pinned exclusively.
+func (t *BPlusTree) pin(pageID buffermanager.PageID, mode buffermanager.PinMode) (node, buffermanager.PageHandle, error) {
+	handle, err := t.bm.PinPage(t.btreeID, pageID, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node(handle.Data()), handle, nil
+}
+
+// unpin releases a page obtained through pin.
+func (t *BPlusTree) unpin(handle buffermanager.PageHandle, dirty bool) error {
+	if dirty {
+		handle.MarkDirty()
+	}
+	return handle.Release()
+}
+
+// allocate allocates a new page for this tree.
+func (t *BPlusTree) allocate() (buffermanager.PageID, error) {
+	return t.bm.AllocatePage(t.btreeID)
+}
+
+// free frees a page of this tree.
+func (t *BPlusTree) free(pageID buffermanager.PageID) error {
+	return t.bm.FreePage(t.btreeID, pageID)
+}
+
+// setRoot records a new root in the meta page.
+func (t *BPlusTree) setRoot(rootID buffermanager.PageID) error {
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	meta.setRoot(rootID)
+	return t.unpin(handle, true)
+}
+
+// rootID reads the root pointer from the meta page. It is not cached, so a
+// rollback by the buffer manager that restores an earlier root is seen by
+// the next operation.
+func (t *BPlusTree) rootID() (buffermanager.PageID, error) {
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinShared)
+	if err != nil {
+		return 0, err
+	}
+	rootID := meta.root()
+	return rootID, t.unpin(handle, false)
+}
+
+// descend walks from the root to the leaf responsible for key and returns the
+// page IDs along the way, ending with the leaf, together with the index of
+// each page within its parent. No pages remain pinned.
+func (t *BPlusTree) descend(key uint64) ([]buffermanager.PageID, []int, error) {
+	rootID, err := t.rootID()
+	if err != nil {
+		return nil, nil, err
+	}
+	path := []buffermanager.PageID{rootID}
+	var indexes []int
+	for {
+		n, handle, err := t.pin(path[len(path)-1], buffermanager.PinShared)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch n.pageType() {
+		case pageTypeLeaf:
+			return path, indexes, t.unpin(handle, false)
+		case pageTypeInternal:
+			i := n.childIndex(key)
+			child := n.child(i)
+			if err := t.unpin(handle, false); err != nil {
+				return nil, nil, err
+			}
+			path = append(path, child)
+			indexes = append(indexes, i)
+		default:
+			t.unpin(handle, false)
+			return nil, nil, fmt.Errorf("%w: page %d has type %d", ErrCorruptPage, path[len(path)-1], n.pageType())
+		}
+	}
+}
+
+// findLeaf returns the leaf responsible for key, pinned and latched shared,
+// together with its page ID and handle. The caller hands the handle to
+// release.
+func (t *BPlusTree) findLeaf(key uint64) (node, buffermanager.PageID, buffermanager.PageHandle, error) {
+	n, pageID, handle, _, _, err := t.findLeafBounds(key)
+	return n, pageID, handle, err
+}
+
+// findLeafBounds is findLeaf that also returns the inclusive range of keys
+// [lo, hi] that the leaf covers.
+func (t *BPlusTree) findLeafBounds(key uint64) (n node, pageID buffermanager.PageID, handle buffermanager.PageHandle, lo, hi uint64, err error) {
+	t.latch(metaPageID, false)
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinShared)
+	if err != nil {
+		t.unlatch(metaPageID, false)
+		return nil, 0, nil, 0, 0, err
+	}
+	pageID = meta.root()
+	t.latch(pageID, false)
+	if err := t.release(handle); err != nil {
+		t.unlatch(pageID, false)
+		return nil, 0, nil, 0, 0, err
+	}
+
+	lo, hi = 0, ^uint64(0)
+	for {
+		n, handle, err = t.pin(pageID, buffermanager.PinShared)
+		if err != nil {
+			t.unlatch(pageID, false)
+			return nil, 0, nil, 0, 0, err
+		}
+		switch n.pageType() {
+		case pageTypeLeaf:
+			return n, pageID, handle, lo, hi, nil
+		case pageTypeInternal:
+			i := n.childIndex(key)
+			if i > 0 {
+				lo = n.internalKey(i - 1)
+			}
+			if i < n.numKeys() {
+				hi = n.internalKey(i) - 1
+			}
+			childID := n.child(i)
+			t.latch(childID, false)
+			if err := t.release(handle); err != nil {
+				t.unlatch(childID, false)
+				return nil, 0, nil, 0, 0, err
+			}
+			pageID = childID
+		default:
+			t.release(handle)
+			return nil, 0, nil, 0, 0, fmt.Errorf("%w: page %d has type %d", ErrCorruptPage, pageID, n.pageType())
+		}
+	}
+}
+
+// Lookup finds the value associated with the given key.
+func (t *BPlusTree) Lookup(key uint64) (uint64, bool) {
+	defer t.lock(false)()
+
+	leaf, _, handle, err := t.findLeaf(key)
+	if err != nil {
+		return 0, false
+	}
+	defer t.release(handle)
+
+	i := leaf.leafSearch(key)
+	if i < leaf.numKeys() && leaf.leafKey(i) == key {
+		return leaf.leafValue(i), true
+	}
+	return 0, false
+}
+
+// Insert adds or updates a key-value pair in the tree.
+func (t *BPlusTree) Insert(key uint64, value uint64) error {
+	defer t.lock(false)()
+	atomic.AddUint64(&t.version, 1)
+	return t.commit(t.insert(key, value))
+}
+
+// insert implements Insert. Splits stop at the highest latched page, which
+// has room for one more entry unless it is the root.
+func (t *BPlusTree) insert(key uint64, value uint64) error {
+	lp, err := t.descendExclusive(key, t.safeForInsert)
+	if err != nil {
+		return err
+	}
+	defer t.releasePath(lp)
+	path := lp.path
+	leafID := path[len(path)-1]
+	leaf, handle, err := t.pin(leafID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+
+	i := leaf.leafSearch(key)
+	if i < leaf.numKeys() && leaf.leafKey(i) == key {
+		leaf.setLeafEntry(i, key, value)
+		return t.unpin(handle, true)
+	}
+	leaf.leafInsertAt(i, key, value)
+	if leaf.numKeys() <= t.maxLeafKeys {
+		return t.unpin(handle, true)
+	}
+
+	separator, rightID, err := t.splitLeaf(leafID, leaf)
+	if unpinErr := t.unpin(handle, true); err == nil {
+		err = unpinErr
+	}
+	if err != nil {
+		return err
+	}
+	return t.insertIntoParent(path[:len(path)-1], leafID, separator, rightID)
+}
+
+// splitLeaf moves the upper half of an overflowing leaf into a new right
+// sibling and returns the sibling's first key and page ID.
+func (t *BPlusTree) splitLeaf(leftID buffermanager.PageID, left node) (uint64, buffermanager.PageID, error) {
+	rightID, err := t.allocate()
+	if err != nil {
+		return 0, 0, err
+	}
+	right, handle, err := t.pin(rightID, buffermanager.PinExclusive)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := left.numKeys()
+	mid := count / 2
+	right.initLeaf()
+	copy(right[leafOffset(0):], left[leafOffset(mid):leafOffset(count)])
+	right.setNumKeys(count - mid)
+	left.setNumKeys(mid)
+	nextID := left.next()
+	right.setNext(nextID)
+	right.setPrev(leftID)
+	left.setNext(rightID)
+
+	separator := right.leafKey(0)
+	if err := t.unpin(handle, true); err != nil {
+		return 0, 0, err
+	}
+	if nextID != 0 {
+		if err := t.setPrev(nextID, rightID); err != nil {
+			return 0, 0, err
+		}
+	}
+	return separator, rightID, nil
+}
+
+// setPrev points the backward link of leaf pageID at prevID. The leaf is the
+// right neighbour of a leaf the caller has latched, so it is latched here.
+func (t *BPlusTree) setPrev(pageID, prevID buffermanager.PageID) error {
+	t.latch(pageID, true)
+	defer t.unlatch(pageID, true)
+	leaf, handle, err := t.pin(pageID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	leaf.setPrev(prevID)
+	return t.unpin(handle, true)
+}
+
+// splitInternal moves the upper half of an overflowing internal node into a
+// new right sibling and returns the separator pushed up and the sibling's ID.
+func (t *BPlusTree) splitInternal(left node) (uint64, buffermanager.PageID, error) {
+	rightID, err := t.allocate()
+	if err != nil {
+		return 0, 0, err
+	}
+	right, handle, err := t.pin(rightID, buffermanager.PinExclusive)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := left.numKeys()
+	mid := count / 2
+	separator := left.internalKey(mid)
+	right.initInternal(left.child(mid + 1))
+	copy(right[keyOffset(0):], left[keyOffset(mid+1):keyOffset(count)])
+	right.setNumKeys(count - mid - 1)
+	left.setNumKeys(mid)
+
+	return separator, rightID, t.unpin(handle, true)
+}
+
+// insertIntoParent links rightID into the parent of leftID, splitting
+// ancestors as needed. path holds the ancestors of leftID, root first.
+func (t *BPlusTree) insertIntoParent(path []buffermanager.PageID, leftID buffermanager.PageID, key uint64, rightID buffermanager.PageID) error {
+	for len(path) > 0 {
+		parentID := path[len(path)-1]
+		path = path[:len(path)-1]
+
+		parent, handle, err := t.pin(parentID, buffermanager.PinExclusive)
+		if err != nil {
+			return err
+		}
+		parent.internalInsertAt(parent.childIndex(key), key, rightID)
+		if parent.numKeys() <= t.maxInternalKeys {
+			return t.unpin(handle, true)
+		}
+
+		separator, newID, err := t.splitInternal(parent)
+		if unpinErr := t.unpin(handle, true); err == nil {
+			err = unpinErr
+		}
+		if err != nil {
+			return err
+		}
+		leftID, key, rightID = parentID, separator, newID
+	}
+	return t.growRoot(leftID, key, rightID)
+}
+
+// growRoot replaces the root with a new internal node over leftID and rightID.
+func (t *BPlusTree) growRoot(leftID buffermanager.PageID, key uint64, rightID buffermanager.PageID) error {
+	rootID, err := t.allocate()
+	if err != nil {
+		return err
+	}
+	root, handle, err := t.pin(rootID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	root.initInternal(leftID)
+	root.internalInsertAt(0, key, rightID)
+	if err := t.unpin(handle, true); err != nil {
+		return err
+	}
+	return t.setRoot(rootID)
+}
+
+// Scan retrieves all key-value pairs within the given range.
+func (t *BPlusTree) Scan(minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.ScanContext(context.Background(), minKey, maxKey)
+}
+
+// ScanContext retrieves all key-value pairs within the given range until ctx
+// is cancelled. Pairs are gathered in batches under the tree lock and sent
+// without holding any pins, so a slow consumer never keeps pages in the
+// buffer pool.
+func (t *BPlusTree) ScanContext(ctx context.Context, minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	from := minKey
+	results, scanErr := stream(ctx, func() ([]btree.KeyValuePair, bool, error) {
+		if minKey > maxKey {
+			return nil, false, nil
+		}
+		batch, more, err := t.collect(from, maxKey, scanBatchSize)
+		if more {
+			from = batch[len(batch)-1].Key + 1
+		}
+		return batch, more, err
+	})
+	return results, scanErr, nil
+}
+
+// ReverseScan retrieves all key-value pairs within the given range in
+// descending key order.
+func (t *BPlusTree) ReverseScan(maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.ReverseScanContext(context.Background(), maxKey, minKey)
+}
+
+// ReverseScanContext retrieves all key-value pairs within the given range in
+// descending key order until ctx is cancelled. It walks the leaf chain
+// backwards and batches pairs like ScanContext.
+func (t *BPlusTree) ReverseScanContext(ctx context.Context, maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	from := maxKey
+	results, scanErr := stream(ctx, func() ([]btree.KeyValuePair, bool, error) {
+		if minKey > maxKey {
+			return nil, false, nil
+		}
+		batch, more, err := t.collectReverse(from, minKey, scanBatchSize)
+		if more {
+			from = batch[len(batch)-1].Key - 1
+		}
+		return batch, more, err
+	})
+	return results, scanErr, nil
+}
+
+// stream sends the batches returned by successive calls to next on a channel
+// until next reports that no pairs remain, next fails or ctx is cancelled.
+// The returned ScanErrFunc reports which of these ended the stream.
+func stream(ctx context.Context, next func() ([]btree.KeyValuePair, bool, error)) (<-chan btree.KeyValuePair, btree.ScanErrFunc) {
+	results := make(chan btree.KeyValuePair)
+	done := make(chan struct{})
+	var scanErr error
+
+	go func() {
+		defer close(done)
+		defer close(results)
+		for {
+			batch, more, err := next()
+			if err != nil {
+				scanErr = err
+				return
+			}
+			for _, kv := range batch {
+				select {
+				case results <- kv:
+				case <-ctx.Done():
+					scanErr = ctx.Err()
+					return
+				}
+			}
+			if !more {
+				return
+			}
+		}
+	}()
+
+	return results, func() error {
+		<-done
+		return scanErr
+	}
+}
+
+// collect gathers up to limit pairs with from <= key <= maxKey by walking the
+// leaf chain. more reports whether the range may hold further pairs. When
+// the next leaf is latched by a writer, collect lets go of the current one
+// and descends again to the first key it has not covered yet.
+func (t *BPlusTree) collect(from, maxKey uint64, limit int) (batch []btree.KeyValuePair, more bool, err error) {
+	defer t.lock(false)()
+
+	for {
+		leaf, leafID, handle, _, covered, err := t.findLeafBounds(from)
+		if err != nil {
+			return nil, false, err
+		}
+		i := leaf.leafSearch(from)
+		for {
+			for ; i < leaf.numKeys(); i++ {
+				key := leaf.leafKey(i)
+				if key > maxKey {
+					return batch, false, t.release(handle)
+				}
+				if len(batch) == limit {
+					return batch, true, t.release(handle)
+				}
+				batch = append(batch, btree.KeyValuePair{Key: key, Value: leaf.leafValue(i)})
+			}
+			if count := leaf.numKeys(); count > 0 && leaf.leafKey(count-1) > covered {
+				covered = leaf.leafKey(count - 1)
+			}
+
+			nextID := leaf.next()
+			if nextID == 0 || covered == ^uint64(0) {
+				return batch, false, t.release(handle)
+			}
+			latched := t.tryLatch(nextID, false)
+			if err := t.release(handle); err != nil {
+				if latched {
+					t.unlatch(nextID, false)
+				}
+				return nil, false, err
+			}
+			if !latched {
+				from = covered + 1
+				break
+			}
+			leafID = nextID
+			if leaf, handle, err = t.pin(leafID, buffermanager.PinShared); err != nil {
+				t.unlatch(leafID, false)
+				return nil, false, err
+			}
+			i = 0
+		}
+	}
+}
+
+// collectReverse gathers up to limit pairs with minKey <= key <= from in
+// descending order by walking the leaf chain backwards. more reports whether
+// the range may hold further pairs. Like collect, it descends again when the
+// previous leaf is latched by a writer.
+func (t *BPlusTree) collectReverse(from, minKey uint64, limit int) (batch []btree.KeyValuePair, more bool, err error) {
+	defer t.lock(false)()
+
+	for {
+		leaf, leafID, handle, covered, _, err := t.findLeafBounds(from)
+		if err != nil {
+			return nil, false, err
+		}
+		i := leaf.leafSearch(from)
+		if i < leaf.numKeys() && leaf.leafKey(i) == from {
+			i++
+		}
+		for {
+			for i--; i >= 0; i-- {
+				key := leaf.leafKey(i)
+				if key < minKey {
+					return batch, false, t.release(handle)
+				}
+				if len(batch) == limit {
+					return batch, true, t.release(handle)
+				}
+				batch = append(batch, btree.KeyValuePair{Key: key, Value: leaf.leafValue(i)})
+			}
+			if leaf.numKeys() > 0 && leaf.leafKey(0) < covered {
+				covered = leaf.leafKey(0)
+			}
+
+			prevID := leaf.prev()
+			if prevID == 0 || covered == 0 {
+				return batch, false, t.release(handle)
+			}
+			latched := t.tryLatch(prevID, false)
+			if err := t.release(handle); err != nil {
+				if latched {
+					t.unlatch(prevID, false)
+				}
+				return nil, false, err
+			}
+			if !latched {
+				from = covered - 1
+				break
+			}
+			leafID = prevID
+			if leaf, handle, err = t.pin(leafID, buffermanager.PinShared); err != nil {
+				t.unlatch(leafID, false)
+				return nil, false, err
+			}
+			i = leaf.numKeys()
+		}
+	}
+}
@@ -0,0 +1,966 @@
+// btree/bplustree/bplustree_test.go
+package bplustree
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// errInjected is returned by testPager when pinning a page marked as failing.
+var errInjected = errors.New("injected page failure")
+
+// testPager is a minimal BufferManager that never runs out of frames and
+// counts outstanding pins, so the tree can be tested in isolation. Pinning a
+// page listed in failing returns errInjected.
+type testPager struct {
+	pages    map[buffermanager.PageID][]byte
+	nextPage buffermanager.PageID
+	pinned   map[int]bool
+	nextPos  int
+	pinCalls int
+	failing  map[buffermanager.PageID]bool
+}
+
+func newTestPager() *testPager {
+	return &testPager{
+		pages:    make(map[buffermanager.PageID][]byte),
+		nextPage: 1,
+		pinned:   make(map[int]bool),
+		failing:  make(map[buffermanager.PageID]bool),
+	}
+}
+
+func (p *testPager) CreateBTree() (string, error)          { return "test", nil }
+func (p *testPager) OpenBTree(string) (btree.BTree, error) { return nil, errors.New("not supported") }
+func (p *testPager) DeleteBTree(string) error              { return nil }
+func (p *testPager) CloseBTree(string) error               { return nil }
+func (p *testPager) outstandingPins() int                  { return len(p.pinned) }
+func (p *testPager) AllocatePage(string) (buffermanager.PageID, error) {
+	id := p.nextPage
+	p.nextPage++
+	p.pages[id] = make([]byte, buffermanager.PageSize)
+	return id, nil
+}
+
+func (p *testPager) FreePage(_ string, pageID buffermanager.PageID) error {
+	if _, ok := p.pages[pageID]; !ok {
+		return buffermanager.ErrPageNotFound
+	}
+	delete(p.pages, pageID)
+	return nil
+}
+
+func (p *testPager) PinPage(btreeID string, pageID buffermanager.PageID, _ buffermanager.PinMode) (buffermanager.PageHandle, error) {
+	data, ok := p.pages[pageID]
+	if !ok {
+		return nil, buffermanager.ErrPageNotFound
+	}
+	if p.failing[pageID] {
+		return nil, errInjected
+	}
+	p.pinCalls++
+	pos := p.nextPos
+	p.nextPos++
+	p.pinned[pos] = true
+	return &testHandle{pager: p, pos: pos, btreeID: btreeID, pageID: pageID, data: data}, nil
+}
+
+// PinPageContext is PinPage; testPager never runs out of frames to wait for.
+func (p *testPager) PinPageContext(_ context.Context, btreeID string, pageID buffermanager.PageID, mode buffermanager.PinMode) (buffermanager.PageHandle, error) {
+	return p.PinPage(btreeID, pageID, mode)
+}
+
+// testHandle is the PageHandle handed out by testPager.
+type testHandle struct {
+	pager   *testPager
+	pos     int
+	btreeID string
+	pageID  buffermanager.PageID
+	data    []byte
+}
+
+func (h *testHandle) BTreeID() string              { return h.btreeID }
+func (h *testHandle) PageID() buffermanager.PageID { return h.pageID }
+func (h *testHandle) Data() []byte                 { return h.data }
+func (h *testHandle) MarkDirty()                   {}
+
+func (h *testHandle) Release() error {
+	if !h.pager.pinned[h.pos] {
+		return buffermanager.ErrStaleHandle
+	}
+	delete(h.pager.pinned, h.pos)
+	return nil
+}
+
+func newTestTree(t *testing.T, options ...Option) (*BPlusTree, *testPager) {
+	t.Helper()
+	pager := newTestPager()
+	tree, err := New(pager, "test", options...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return tree, pager
+}
+
+// rootOf returns the root page of tree, failing the test on error.
+func rootOf(t *testing.T, tree *BPlusTree) buffermanager.PageID {
+	t.Helper()
+	rootID, err := tree.rootID()
+	if err != nil {
+		t.Fatalf("rootID failed: %v", err)
+	}
+	return rootID
+}
+
+// checkTree walks the whole tree and fails the test if any node is out of
+// order, under- or overfull, or if the leaf chain skips a leaf in either
+// direction. It returns
+// the number of keys stored.
+func checkTree(t *testing.T, tree *BPlusTree) int {
+	t.Helper()
+	var leaves []buffermanager.PageID
+	var walk func(pageID buffermanager.PageID, lo, hi uint64, isRoot bool) int
+	walk = func(pageID buffermanager.PageID, lo, hi uint64, isRoot bool) int {
+		data, handle, err := tree.pin(pageID, buffermanager.PinShared)
+		if err != nil {
+			t.Fatalf("pin(%d) failed: %v", pageID, err)
+		}
+		// Work on a copy so the walk never holds more than one pin.
+		n := node(append([]byte(nil), data...))
+		tree.unpin(handle, false)
+
+		count := n.numKeys()
+		if !isRoot && count < tree.minKeys(n) {
+			t.Errorf("Page %d holds %d keys, below the minimum %d", pageID, count, tree.minKeys(n))
+		}
+		if n.isLeaf() {
+			if count > tree.maxLeafKeys {
+				t.Errorf("Leaf %d holds %d keys, above the maximum %d", pageID, count, tree.maxLeafKeys)
+			}
+			for i := 0; i < count; i++ {
+				if k := n.leafKey(i); k < lo || k > hi || (i > 0 && k <= n.leafKey(i-1)) {
+					t.Errorf("Leaf %d has key %d out of order or outside [%d, %d]", pageID, k, lo, hi)
+				}
+			}
+			leaves = append(leaves, pageID)
+			return count
+		}
+
+		if count > tree.maxInternalKeys {
+			t.Errorf("Internal node %d holds %d keys, above the maximum %d", pageID, count, tree.maxInternalKeys)
+		}
+		if isRoot && count == 0 {
+			t.Errorf("Internal root %d has no keys", pageID)
+		}
+		total := 0
+		for i := 0; i <= count; i++ {
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = n.internalKey(i - 1)
+			}
+			if i < count {
+				childHi = n.internalKey(i) - 1
+			}
+			total += walk(n.child(i), childLo, childHi, false)
+		}
+		return total
+	}
+	total := walk(rootOf(t, tree), 0, ^uint64(0), true)
+
+	for i, pageID := range leaves {
+		n, handle, err := tree.pin(pageID, buffermanager.PinShared)
+		if err != nil {
+			t.Fatalf("pin(%d) failed: %v", pageID, err)
+		}
+		expected := buffermanager.PageID(0)
+		if i+1 < len(leaves) {
+			expected = leaves[i+1]
+		}
+		if n.next() != expected {
+			t.Errorf("Leaf %d links to %d, expected %d", pageID, n.next(), expected)
+		}
+		expected = 0
+		if i > 0 {
+			expected = leaves[i-1]
+		}
+		if n.prev() != expected {
+			t.Errorf("Leaf %d links back to %d, expected %d", pageID, n.prev(), expected)
+		}
+		tree.unpin(handle, false)
+	}
+	return total
+}
+
+func collectScan(t *testing.T, tree btree.BTree, minKey, maxKey uint64) []btree.KeyValuePair {
+	t.Helper()
+	results, scanErr, err := tree.Scan(minKey, maxKey)
+	if err != nil {
+		t.Fatalf("Scan returned unexpected error: %v", err)
+	}
+	var collected []btree.KeyValuePair
+	for r := range results {
+		collected = append(collected, r)
+	}
+	if err := scanErr(); err != nil {
+		t.Fatalf("Scan reported unexpected error: %v", err)
+	}
+	return collected
+}
+
+func TestBPlusTree_LookupAndInsert(t *testing.T) {
+	tree, pager := newTestTree(t)
+
+	t.Run("LookupNonExistentKey", func(t *testing.T) {
+		if _, found := tree.Lookup(42); found {
+			t.Errorf("Expected key 42 to not be found, but it was")
+		}
+	})
+
+	t.Run("InsertAndLookup", func(t *testing.T) {
+		if err := tree.Insert(42, 100); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		value, found := tree.Lookup(42)
+		if !found || value != 100 {
+			t.Errorf("Expected (100, true) for key 42, got (%d, %v)", value, found)
+		}
+	})
+
+	t.Run("UpdateAndLookup", func(t *testing.T) {
+		if err := tree.Insert(42, 200); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		value, found := tree.Lookup(42)
+		if !found || value != 200 {
+			t.Errorf("Expected (200, true) for key 42, got (%d, %v)", value, found)
+		}
+	})
+
+	if n := pager.outstandingPins(); n != 0 {
+		t.Errorf("Expected no pinned pages, got %d", n)
+	}
+}
+
+func TestBPlusTree_Splits(t *testing.T) {
+	tree, pager := newTestTree(t, WithMaxKeys(4))
+
+	rng := rand.New(rand.NewSource(1))
+	keys := rng.Perm(2000)
+	for _, k := range keys {
+		if err := tree.Insert(uint64(k), uint64(k)*10); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", k, err)
+		}
+	}
+
+	t.Run("LookupAll", func(t *testing.T) {
+		for _, k := range keys {
+			value, found := tree.Lookup(uint64(k))
+			if !found || value != uint64(k)*10 {
+				t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", k*10, k, value, found)
+			}
+		}
+		if _, found := tree.Lookup(5000); found {
+			t.Errorf("Expected key 5000 to not be found, but it was")
+		}
+	})
+
+	t.Run("TreeHasGrown", func(t *testing.T) {
+		root, handle, err := tree.pin(rootOf(t, tree), buffermanager.PinShared)
+		if err != nil {
+			t.Fatalf("pin root failed: %v", err)
+		}
+		defer tree.unpin(handle, false)
+		if root.isLeaf() {
+			t.Errorf("Expected root to be an internal node after %d inserts", len(keys))
+		}
+	})
+
+	t.Run("Invariants", func(t *testing.T) {
+		if n := checkTree(t, tree); n != len(keys) {
+			t.Errorf("Expected %d keys in tree, got %d", len(keys), n)
+		}
+	})
+
+	t.Run("ScanAll", func(t *testing.T) {
+		results := collectScan(t, tree, 0, 1999)
+		if len(results) != len(keys) {
+			t.Fatalf("Expected %d results, got %d", len(keys), len(results))
+		}
+		for i, kv := range results {
+			if kv.Key != uint64(i) || kv.Value != uint64(i)*10 {
+				t.Fatalf("Expected {%d %d} at position %d, got %v", i, i*10, i, kv)
+			}
+		}
+	})
+
+	t.Run("ScanAcrossBatches", func(t *testing.T) {
+		results := collectScan(t, tree, 100, 100+2*scanBatchSize+7)
+		if len(results) != 2*scanBatchSize+8 {
+			t.Errorf("Expected %d results, got %d", 2*scanBatchSize+8, len(results))
+		}
+		if !sort.SliceIsSorted(results, func(i, j int) bool { return results[i].Key < results[j].Key }) {
+			t.Error("Scan results are not sorted by key")
+		}
+	})
+
+	if n := pager.outstandingPins(); n != 0 {
+		t.Errorf("Expected no pinned pages, got %d", n)
+	}
+}
+
+func TestBPlusTree_Scan(t *testing.T) {
+	tree, _ := newTestTree(t, WithMaxKeys(3))
+	for _, k := range []uint64{50, 10, 40, 20, 30, ^uint64(0)} {
+		if err := tree.Insert(k, k*10); err != nil {
+			t.Fatalf("Insert error %v", err)
+		}
+	}
+
+	t.Run("ScanPartialRange", func(t *testing.T) {
+		expected := []btree.KeyValuePair{{Key: 20, Value: 200}, {Key: 30, Value: 300}, {Key: 40, Value: 400}}
+		if results := collectScan(t, tree, 15, 45); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Scan results mismatch.\nExpected: %v\nGot: %v", expected, results)
+		}
+	})
+
+	t.Run("ScanEmptyRange", func(t *testing.T) {
+		if results := collectScan(t, tree, 60, 70); len(results) != 0 {
+			t.Errorf("Expected 0 results for empty range, got %d", len(results))
+		}
+	})
+
+	t.Run("MinKeyGreaterThanMaxKey", func(t *testing.T) {
+		if results := collectScan(t, tree, 70, 60); len(results) != 0 {
+			t.Errorf("Expected 0 results when minKey > maxKey, got %d", len(results))
+		}
+	})
+
+	t.Run("ScanToMaxUint64", func(t *testing.T) {
+		results := collectScan(t, tree, 45, ^uint64(0))
+		if len(results) != 2 || results[1].Key != ^uint64(0) {
+			t.Errorf("Expected keys [50 max], got %v", results)
+		}
+	})
+}
+
+func TestBPlusTree_Reopen(t *testing.T) {
+	tree, pager := newTestTree(t, WithMaxKeys(4))
+	for k := uint64(0); k < 100; k++ {
+		if err := tree.Insert(k, k+1); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	reopened, err := New(pager, "test", WithMaxKeys(4))
+	if err != nil {
+		t.Fatalf("New on existing pages failed: %v", err)
+	}
+	if rootOf(t, reopened) != rootOf(t, tree) {
+		t.Errorf("Expected root page %d, got %d", rootOf(t, tree), rootOf(t, reopened))
+	}
+	for k := uint64(0); k < 100; k++ {
+		if value, found := reopened.Lookup(k); !found || value != k+1 {
+			t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", k+1, k, value, found)
+		}
+	}
+}
+
+func TestBPlusTree_New(t *testing.T) {
+	t.Run("InvalidMaxKeys", func(t *testing.T) {
+		for _, n := range []int{2, internalCapacity} {
+			if _, err := New(newTestPager(), "test", WithMaxKeys(n)); !errors.Is(err, ErrInvalidMaxKeys) {
+				t.Errorf("Expected ErrInvalidMaxKeys for %d, got: %v", n, err)
+			}
+		}
+	})
+
+	t.Run("CorruptMetaPage", func(t *testing.T) {
+		pager := newTestPager()
+		pager.AllocatePage("test")
+		if _, err := New(pager, "test"); !errors.Is(err, ErrCorruptPage) {
+			t.Errorf("Expected ErrCorruptPage, got: %v", err)
+		}
+	})
+
+	t.Run("OldFormat", func(t *testing.T) {
+		pager := newTestPager()
+		metaID, _ := pager.AllocatePage("test")
+		meta := node(pager.pages[metaID])
+		meta.initMeta(0)
+		binary.LittleEndian.PutUint64(meta[offsetMagic:], metaMagicV1)
+		if _, err := New(pager, "test"); !errors.Is(err, ErrOldFormat) {
+			t.Errorf("Expected ErrOldFormat, got: %v", err)
+		}
+	})
+
+	t.Run("FullPages", func(t *testing.T) {
+		tree, _ := newTestTree(t)
+		for k := uint64(0); k < 10*leafCapacity; k++ {
+			if err := tree.Insert(k, k); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+		}
+		if results := collectScan(t, tree, 0, ^uint64(0)); len(results) != 10*leafCapacity {
+			t.Errorf("Expected %d results, got %d", 10*leafCapacity, len(results))
+		}
+	})
+}
+
+func TestBPlusTree_FileBufferManager(t *testing.T) {
+	dir := t.TempDir()
+	open := func() buffermanager.BufferManager {
+		bm, err := buffermanager.NewFileBufferManager(
+			buffermanager.WithDirectory(dir),
+			buffermanager.WithBufferSize(8),
+			buffermanager.WithTreeFactory(Factory(WithMaxKeys(8))),
+		)
+		if err != nil {
+			t.Fatalf("NewFileBufferManager failed: %v", err)
+		}
+		return bm
+	}
+
+	bm := open()
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := bm.OpenBTree(btreeID)
+	if err != nil {
+		t.Fatalf("OpenBTree failed: %v", err)
+	}
+	for k := uint64(0); k < 1000; k++ {
+		if err := tree.Insert(k*7%1000, k); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if err := bm.CloseBTree(btreeID); err != nil {
+		t.Fatalf("CloseBTree failed: %v", err)
+	}
+
+	// A fresh manager stands in for a process restart.
+	tree, err = open().OpenBTree(btreeID)
+	if err != nil {
+		t.Fatalf("OpenBTree after restart failed: %v", err)
+	}
+	for k := uint64(0); k < 1000; k++ {
+		if value, found := tree.Lookup(k * 7 % 1000); !found || value != k {
+			t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", k, k*7%1000, value, found)
+		}
+	}
+	if results := collectScan(t, tree, 0, 999); len(results) != 1000 {
+		t.Errorf("Expected 1000 results, got %d", len(results))
+	}
+}
+
+// crashingPager forwards to a BufferManager until pinsLeft pages have been
+// pinned, then fails every pin, commit and rollback as if the process had
+// died.
+type crashingPager struct {
+	buffermanager.BufferManager
+	pinsLeft int
+}
+
+func (p *crashingPager) PinPage(btreeID string, pageID buffermanager.PageID, mode buffermanager.PinMode) (buffermanager.PageHandle, error) {
+	if p.pinsLeft == 0 {
+		return nil, errInjected
+	}
+	p.pinsLeft--
+	return p.BufferManager.PinPage(btreeID, pageID, mode)
+}
+
+func (p *crashingPager) Commit() error {
+	if p.pinsLeft == 0 {
+		return errInjected
+	}
+	return p.BufferManager.(buffermanager.Committer).Commit()
+}
+
+func (p *crashingPager) Rollback() error {
+	if p.pinsLeft == 0 {
+		return errInjected
+	}
+	return p.BufferManager.(buffermanager.Committer).Rollback()
+}
+
+func TestBPlusTree_WriteAheadLogRecovery(t *testing.T) {
+	open := func(dir string) buffermanager.BufferManager {
+		bm, err := buffermanager.NewFileBufferManager(
+			buffermanager.WithDirectory(dir),
+			buffermanager.WithBufferSize(8),
+			buffermanager.WithWriteAheadLog(),
+		)
+		if err != nil {
+			t.Fatalf("NewFileBufferManager failed: %v", err)
+		}
+		return bm
+	}
+
+	// Each run crashes at a different point, mostly in the middle of a
+	// split or merge, and recovery must bring back exactly the operations
+	// that completed.
+	for crashAt := 40; crashAt < 4000; crashAt += 173 {
+		dir := t.TempDir()
+		bm := open(dir)
+		btreeID, _ := bm.CreateBTree()
+		tree, err := New(&crashingPager{BufferManager: bm, pinsLeft: crashAt}, btreeID, WithMaxKeys(4))
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+
+		rng := rand.New(rand.NewSource(int64(crashAt)))
+		model := make(map[uint64]uint64)
+		for i := 0; ; i++ {
+			key := uint64(rng.Intn(200))
+			if i%3 == 2 {
+				if _, err := tree.Delete(key); err != nil {
+					break
+				}
+				delete(model, key)
+			} else {
+				if err := tree.Insert(key, uint64(i)); err != nil {
+					break
+				}
+				model[key] = uint64(i)
+			}
+		}
+
+		// The crashed manager is abandoned without closing it.
+		tree, err = New(open(dir), btreeID, WithMaxKeys(4))
+		if err != nil {
+			t.Fatalf("New after crash at pin %d failed: %v", crashAt, err)
+		}
+		if n := checkTree(t, tree); n != len(model) {
+			t.Errorf("Crash at pin %d: expected %d keys after recovery, got %d", crashAt, len(model), n)
+		}
+		for k, v := range model {
+			if value, found := tree.Lookup(k); !found || value != v {
+				t.Fatalf("Crash at pin %d: expected (%d, true) for key %d, got (%d, %v)", crashAt, v, k, value, found)
+			}
+		}
+	}
+}
+
+func TestBPlusTree_SmallBufferPool(t *testing.T) {
+	// The tree spans far more pages than the buffer holds, so every
+	// operation relies on unpinned pages being evicted.
+	bm := buffermanager.NewMockBufferManager(buffermanager.WithBufferSize(3))
+	btreeID, _ := bm.CreateBTree()
+	tree, err := New(bm, btreeID, WithMaxKeys(4))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for k := uint64(0); k < 500; k++ {
+		if err := tree.Insert(k, k*2); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", k, err)
+		}
+	}
+	for k := uint64(0); k < 500; k++ {
+		if value, found := tree.Lookup(k); !found || value != k*2 {
+			t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", k*2, k, value, found)
+		}
+	}
+	if results := collectScan(t, tree, 0, 499); len(results) != 500 {
+		t.Errorf("Expected 500 results, got %d", len(results))
+	}
+
+	// Rebalancing pins a parent and two siblings at once.
+	for k := uint64(0); k < 500; k += 2 {
+		if found, err := tree.Delete(k); err != nil || !found {
+			t.Fatalf("Delete(%d) = (%v, %v), expected (true, nil)", k, found, err)
+		}
+	}
+	if n := checkTree(t, tree); n != 250 {
+		t.Errorf("Expected 250 keys after deletes, got %d", n)
+	}
+}
+
+func TestBPlusTree_Delete(t *testing.T) {
+	for _, maxKeys := range []int{3, 4, 5} {
+		tree, pager := newTestTree(t, WithMaxKeys(maxKeys))
+		rng := rand.New(rand.NewSource(int64(maxKeys)))
+		model := make(map[uint64]uint64)
+
+		for _, k := range rng.Perm(1000) {
+			if err := tree.Insert(uint64(k), uint64(k)+1); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			model[uint64(k)] = uint64(k) + 1
+		}
+
+		// Interleave deletes of present and absent keys with a few inserts.
+		for i := 0; i < 3000; i++ {
+			k := uint64(rng.Intn(1200))
+			if i%5 == 0 {
+				if err := tree.Insert(k, k*3); err != nil {
+					t.Fatalf("Insert failed: %v", err)
+				}
+				model[k] = k * 3
+				continue
+			}
+			_, expected := model[k]
+			found, err := tree.Delete(k)
+			if err != nil {
+				t.Fatalf("Delete(%d) failed: %v", k, err)
+			}
+			if found != expected {
+				t.Fatalf("Delete(%d) reported found=%v, expected %v", k, found, expected)
+			}
+			delete(model, k)
+		}
+
+		if n := checkTree(t, tree); n != len(model) {
+			t.Errorf("maxKeys=%d: expected %d keys in tree, got %d", maxKeys, len(model), n)
+		}
+		for k, v := range model {
+			if value, found := tree.Lookup(k); !found || value != v {
+				t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", v, k, value, found)
+			}
+		}
+
+		// Deleting everything shrinks the tree back to a single leaf.
+		for k := range model {
+			if found, err := tree.Delete(k); err != nil || !found {
+				t.Fatalf("Delete(%d) = (%v, %v), expected (true, nil)", k, found, err)
+			}
+		}
+		if n := checkTree(t, tree); n != 0 {
+			t.Errorf("Expected empty tree, found %d keys", n)
+		}
+		if len(pager.pages) != 2 {
+			t.Errorf("Expected only the meta page and root leaf to remain, got %d pages", len(pager.pages))
+		}
+		if n := pager.outstandingPins(); n != 0 {
+			t.Errorf("Expected no pinned pages, got %d", n)
+		}
+	}
+}
+
+func TestBPlusTree_DeleteRange(t *testing.T) {
+	t.Run("MatchesModel", func(t *testing.T) {
+		for _, maxKeys := range []int{3, 4, 7} {
+			tree, pager := newTestTree(t, WithMaxKeys(maxKeys))
+			rng := rand.New(rand.NewSource(int64(maxKeys)))
+			model := make(map[uint64]uint64)
+
+			for round := 0; round < 40; round++ {
+				for i := 0; i < 100; i++ {
+					k := uint64(rng.Intn(2000))
+					if err := tree.Insert(k, k); err != nil {
+						t.Fatalf("Insert failed: %v", err)
+					}
+					model[k] = k
+				}
+
+				minKey := uint64(rng.Intn(2000))
+				maxKey := minKey + uint64(rng.Intn(400))
+				expected := 0
+				for k := range model {
+					if k >= minKey && k <= maxKey {
+						delete(model, k)
+						expected++
+					}
+				}
+				deleted, err := tree.DeleteRange(minKey, maxKey)
+				if err != nil {
+					t.Fatalf("DeleteRange(%d, %d) failed: %v", minKey, maxKey, err)
+				}
+				if deleted != expected {
+					t.Fatalf("DeleteRange(%d, %d) deleted %d keys, expected %d", minKey, maxKey, deleted, expected)
+				}
+				if n := checkTree(t, tree); n != len(model) {
+					t.Fatalf("Expected %d keys in tree, got %d", len(model), n)
+				}
+			}
+
+			results := collectScan(t, tree, 0, ^uint64(0))
+			if len(results) != len(model) {
+				t.Errorf("Expected %d scan results, got %d", len(model), len(results))
+			}
+			for _, kv := range results {
+				if _, ok := model[kv.Key]; !ok {
+					t.Errorf("Scan returned deleted key %d", kv.Key)
+				}
+			}
+			if n := pager.outstandingPins(); n != 0 {
+				t.Errorf("Expected no pinned pages, got %d", n)
+			}
+		}
+	})
+
+	t.Run("FreesCoveredSubtrees", func(t *testing.T) {
+		tree, pager := newTestTree(t)
+		for k := uint64(0); k < 20000; k++ {
+			if err := tree.Insert(k, k); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+		}
+		pagesBefore := len(pager.pages)
+		pinsBefore := pager.pinCalls
+
+		deleted, err := tree.DeleteRange(100, 19899)
+		if err != nil {
+			t.Fatalf("DeleteRange failed: %v", err)
+		}
+		if deleted != 19800 {
+			t.Errorf("Expected 19800 keys deleted, got %d", deleted)
+		}
+		// Covered leaves are pinned once each, not once per key.
+		if pins := pager.pinCalls - pinsBefore; pins > pagesBefore+50 {
+			t.Errorf("Expected about one pin per page (%d pages), got %d pins", pagesBefore, pins)
+		}
+		if len(pager.pages) > 5 {
+			t.Errorf("Expected covered pages to be freed, %d of %d pages remain", len(pager.pages), pagesBefore)
+		}
+		if n := checkTree(t, tree); n != 200 {
+			t.Errorf("Expected 200 keys left, got %d", n)
+		}
+	})
+
+	t.Run("WholeTree", func(t *testing.T) {
+		tree, pager := newTestTree(t, WithMaxKeys(4))
+		for k := uint64(0); k < 500; k++ {
+			tree.Insert(k, k)
+		}
+		if deleted, err := tree.DeleteRange(0, ^uint64(0)); err != nil || deleted != 500 {
+			t.Fatalf("Expected (500, nil), got (%d, %v)", deleted, err)
+		}
+		if len(pager.pages) != 2 {
+			t.Errorf("Expected only the meta page and a new root leaf, got %d pages", len(pager.pages))
+		}
+		if n := checkTree(t, tree); n != 0 {
+			t.Errorf("Expected empty tree, found %d keys", n)
+		}
+	})
+
+	t.Run("OpenEnded", func(t *testing.T) {
+		tree, _ := newTestTree(t, WithMaxKeys(4))
+		for k := uint64(0); k < 500; k++ {
+			tree.Insert(k, k)
+		}
+		if deleted, err := tree.DeleteRange(400, ^uint64(0)); err != nil || deleted != 100 {
+			t.Fatalf("Expected (100, nil), got (%d, %v)", deleted, err)
+		}
+		if deleted, err := tree.DeleteRange(0, 99); err != nil || deleted != 100 {
+			t.Fatalf("Expected (100, nil), got (%d, %v)", deleted, err)
+		}
+		if n := checkTree(t, tree); n != 300 {
+			t.Errorf("Expected 300 keys left, got %d", n)
+		}
+	})
+}
+
+func TestBPlusTree_ReverseScan(t *testing.T) {
+	t.Run("MatchesModel", func(t *testing.T) {
+		tree, pager := newTestTree(t, WithMaxKeys(4))
+		rng := rand.New(rand.NewSource(1))
+		model := make(map[uint64]uint64)
+		for i := 0; i < 3000; i++ {
+			k := uint64(rng.Intn(2000))
+			if rng.Intn(3) == 0 {
+				tree.Delete(k)
+				delete(model, k)
+				continue
+			}
+			tree.Insert(k, k*2)
+			model[k] = k * 2
+		}
+		checkTree(t, tree)
+
+		for i := 0; i < 50; i++ {
+			minKey := uint64(rng.Intn(2000))
+			maxKey := minKey + uint64(rng.Intn(800))
+			var expected []btree.KeyValuePair
+			for _, kv := range collectScan(t, tree, minKey, maxKey) {
+				expected = append([]btree.KeyValuePair{kv}, expected...)
+			}
+			results, _, err := tree.ReverseScan(maxKey, minKey)
+			if err != nil {
+				t.Fatalf("ReverseScan failed: %v", err)
+			}
+			var got []btree.KeyValuePair
+			for kv := range results {
+				got = append(got, kv)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("ReverseScan(%d, %d) returned %d pairs, expected %d", maxKey, minKey, len(got), len(expected))
+			}
+		}
+		if n := pager.outstandingPins(); n != 0 {
+			t.Errorf("Expected no pinned pages, got %d", n)
+		}
+	})
+
+	t.Run("FollowsSiblingLinks", func(t *testing.T) {
+		tree, pager := newTestTree(t, WithMaxKeys(4))
+		for k := uint64(0); k < 1000; k++ {
+			tree.Insert(k, k)
+		}
+		pinsBefore := pager.pinCalls
+		results, _, err := tree.ReverseScan(999, 0)
+		if err != nil {
+			t.Fatalf("ReverseScan failed: %v", err)
+		}
+		count := 0
+		for range results {
+			count++
+		}
+		if count != 1000 {
+			t.Errorf("Expected 1000 results, got %d", count)
+		}
+		// One descent per batch plus one pin per leaf, never a descent per leaf.
+		leaves := 1000 / 2
+		if pins := pager.pinCalls - pinsBefore; pins > leaves+50 {
+			t.Errorf("Expected about one pin per leaf (at most %d leaves), got %d pins", leaves, pins)
+		}
+	})
+}
+
+func TestBPlusTree_ScanErrors(t *testing.T) {
+	tree, pager := newTestTree(t, WithMaxKeys(4))
+	for k := uint64(0); k < 1000; k++ {
+		tree.Insert(k, k)
+	}
+	path, _, err := tree.descend(500)
+	if err != nil {
+		t.Fatalf("descend failed: %v", err)
+	}
+	pager.failing[path[len(path)-1]] = true
+
+	scans := []struct {
+		name string
+		scan func() (<-chan btree.KeyValuePair, btree.ScanErrFunc, error)
+	}{
+		{"Scan", func() (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) { return tree.Scan(0, 999) }},
+		{"ReverseScan", func() (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) { return tree.ReverseScan(999, 0) }},
+	}
+	for _, s := range scans {
+		t.Run(s.name, func(t *testing.T) {
+			results, scanErr, err := s.scan()
+			if err != nil {
+				t.Fatalf("%s returned unexpected error: %v", s.name, err)
+			}
+			count := 0
+			for range results {
+				count++
+			}
+			if count == 0 || count >= 1000 {
+				t.Errorf("Expected the scan to stop halfway, got %d results", count)
+			}
+			if err := scanErr(); !errors.Is(err, errInjected) {
+				t.Errorf("Expected injected error, got: %v", err)
+			}
+			if n := pager.outstandingPins(); n != 0 {
+				t.Errorf("Expected no pinned pages, got %d", n)
+			}
+		})
+	}
+}
+
+func TestBPlusTree_Cursor(t *testing.T) {
+	tree, pager := newTestTree(t, WithMaxKeys(4))
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[uint64]uint64)
+	for i := 0; i < 2000; i++ {
+		key := uint64(rng.Intn(5000))
+		if err := tree.Insert(key, key*3); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = key * 3
+	}
+	var keys []uint64
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	c, err := tree.Cursor()
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	defer c.Close()
+
+	t.Run("MatchesModel", func(t *testing.T) {
+		var forward, backward []uint64
+		for ok := c.First(); ok; ok = c.Next() {
+			if c.Value() != model[c.Key()] {
+				t.Fatalf("Expected value %d for key %d, got %d", model[c.Key()], c.Key(), c.Value())
+			}
+			forward = append(forward, c.Key())
+		}
+		for ok := c.Last(); ok; ok = c.Prev() {
+			backward = append([]uint64{c.Key()}, backward...)
+		}
+		if !reflect.DeepEqual(forward, keys) || !reflect.DeepEqual(backward, keys) {
+			t.Errorf("Expected %d keys in both directions, got %d forward and %d backward", len(keys), len(forward), len(backward))
+		}
+		for i := 0; i < 200; i++ {
+			target := uint64(rng.Intn(5100))
+			j := sort.Search(len(keys), func(j int) bool { return keys[j] >= target })
+			if ok := c.Seek(target); ok != (j < len(keys)) || (ok && c.Key() != keys[j]) {
+				t.Fatalf("Seek(%d) returned (%v, %d), expected index %d of %d", target, ok, c.Key(), j, len(keys))
+			}
+		}
+		if pager.outstandingPins() != 0 {
+			t.Errorf("Expected no outstanding pins, got %d", pager.outstandingPins())
+		}
+	})
+
+	t.Run("SurvivesDeletes", func(t *testing.T) {
+		// Delete the neighbours of the current position while iterating; the
+		// cursor must skip to the next surviving key.
+		count := 0
+		for ok := c.First(); ok; ok = c.Next() {
+			count++
+			for k := c.Key() + 1; k < c.Key()+20; k++ {
+				if _, err := tree.Delete(k); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+				delete(model, k)
+			}
+		}
+		if count != len(model) {
+			t.Errorf("Expected to visit %d surviving keys, got %d", len(model), count)
+		}
+		checkTree(t, tree)
+	})
+
+	t.Run("PageFailure", func(t *testing.T) {
+		if !c.First() {
+			t.Fatal("Expected a non-empty tree")
+		}
+		first := c.Key()
+		path, _, err := tree.descend(keys[len(keys)/2])
+		if err != nil {
+			t.Fatalf("descend failed: %v", err)
+		}
+		leaf := path[len(path)-1]
+		pager.failing[leaf] = true
+		defer delete(pager.failing, leaf)
+
+		count := 0
+		for ok := c.First(); ok; ok = c.Next() {
+			count++
+		}
+		if !errors.Is(c.Err(), errInjected) {
+			t.Errorf("Expected injected error after %d pairs, got %v", count, c.Err())
+		}
+		if c.Next() {
+			t.Error("Expected the cursor to stay invalid after an error")
+		}
+
+		delete(pager.failing, leaf)
+		if !c.First() || c.Key() != first || c.Err() != nil {
+			t.Errorf("Expected repositioning to recover, got (%d, %v)", c.Key(), c.Err())
+		}
+	})
+}
@@ -0,0 +1,195 @@
+// btree/bplustree/cursor.go
+package bplustree
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// Cursor returns a cursor over the tree. The cursor keeps a copy of the leaf
+// it is positioned in and holds no pins between calls, so an idle cursor
+// never occupies a frame of the buffer pool. Once the tree is modified, or
+// the cursor moves past the copied leaf, it re-descends from the root, so it
+// always reflects the current contents of the tree.
+func (t *BPlusTree) Cursor() (btree.Cursor, error) {
+	return &cursor{tree: t, pos: -1}, nil
+}
+
+// cursor implements btree.Cursor for a BPlusTree.
+type cursor struct {
+	tree    *BPlusTree
+	keys    []uint64
+	values  []uint64
+	lo, hi  uint64 // Key range covered by the copied leaf
+	version uint64 // Tree version the copy was taken at
+	pos     int    // Index of the current pair in keys, -1 if invalid
+	err     error
+	closed  bool
+}
+
+// load copies the leaf responsible for key into the cursor.
+func (c *cursor) load(key uint64) error {
+	t := c.tree
+	defer t.lock(false)()
+
+	// Read the version first, so a modification racing with the copy makes
+	// the cursor stale.
+	version := atomic.LoadUint64(&t.version)
+	leaf, _, handle, lo, hi, err := t.findLeafBounds(key)
+	if err != nil {
+		return err
+	}
+	count := leaf.numKeys()
+	c.keys, c.values = c.keys[:0], c.values[:0]
+	for i := 0; i < count; i++ {
+		c.keys = append(c.keys, leaf.leafKey(i))
+		c.values = append(c.values, leaf.leafValue(i))
+	}
+	c.lo, c.hi = lo, hi
+	c.version = version
+	return t.release(handle)
+}
+
+// stale reports whether the tree was modified since the leaf was copied.
+func (c *cursor) stale() bool {
+	return c.version != atomic.LoadUint64(&c.tree.version)
+}
+
+// fail invalidates the cursor because of err.
+func (c *cursor) fail(err error) bool {
+	c.err = err
+	c.pos = -1
+	return false
+}
+
+// seekForward positions the cursor at the first pair whose key is >= key,
+// moving on to the following leaves while the current one has none.
+func (c *cursor) seekForward(key uint64) bool {
+	for {
+		if err := c.load(key); err != nil {
+			return c.fail(err)
+		}
+		i := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= key })
+		if i < len(c.keys) {
+			c.pos = i
+			return true
+		}
+		if c.hi == ^uint64(0) {
+			c.pos = -1
+			return false
+		}
+		key = c.hi + 1
+	}
+}
+
+// seekBackward positions the cursor at the last pair whose key is <= key,
+// moving on to the preceding leaves while the current one has none.
+func (c *cursor) seekBackward(key uint64) bool {
+	for {
+		if err := c.load(key); err != nil {
+			return c.fail(err)
+		}
+		i := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] > key }) - 1
+		if i >= 0 {
+			c.pos = i
+			return true
+		}
+		if c.lo == 0 {
+			c.pos = -1
+			return false
+		}
+		key = c.lo - 1
+	}
+}
+
+func (c *cursor) valid() bool {
+	return !c.closed && c.pos >= 0
+}
+
+// Seek positions the cursor at the first pair whose key is >= key.
+func (c *cursor) Seek(key uint64) bool {
+	if c.closed {
+		return false
+	}
+	c.err = nil
+	return c.seekForward(key)
+}
+
+// First positions the cursor at the pair with the smallest key.
+func (c *cursor) First() bool {
+	return c.Seek(0)
+}
+
+// Last positions the cursor at the pair with the largest key.
+func (c *cursor) Last() bool {
+	if c.closed {
+		return false
+	}
+	c.err = nil
+	return c.seekBackward(^uint64(0))
+}
+
+// Next moves the cursor to the pair with the next larger key.
+func (c *cursor) Next() bool {
+	if !c.valid() {
+		return false
+	}
+	current := c.keys[c.pos]
+	if c.pos+1 < len(c.keys) && !c.stale() {
+		c.pos++
+		return true
+	}
+	if current == ^uint64(0) {
+		c.pos = -1
+		return false
+	}
+	return c.seekForward(current + 1)
+}
+
+// Prev moves the cursor to the pair with the next smaller key.
+func (c *cursor) Prev() bool {
+	if !c.valid() {
+		return false
+	}
+	current := c.keys[c.pos]
+	if c.pos > 0 && !c.stale() {
+		c.pos--
+		return true
+	}
+	if current == 0 {
+		c.pos = -1
+		return false
+	}
+	return c.seekBackward(current - 1)
+}
+
+// Key returns the key of the current pair.
+func (c *cursor) Key() uint64 {
+	if !c.valid() {
+		return 0
+	}
+	return c.keys[c.pos]
+}
+
+// Value returns the value of the current pair.
+func (c *cursor) Value() uint64 {
+	if !c.valid() {
+		return 0
+	}
+	return c.values[c.pos]
+}
+
+// Err returns the error that invalidated the cursor, if any.
+func (c *cursor) Err() error {
+	return c.err
+}
+
+// Close releases the cursor's copy of the current leaf.
+func (c *cursor) Close() error {
+	c.closed = true
+	c.keys, c.values = nil, nil
+	c.pos = -1
+	return nil
+}
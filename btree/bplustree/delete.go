@@ -0,0 +1,250 @@
+// btree/bplustree/delete.go
+package bplustree
+
+import (
+	"sync/atomic"
+
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Delete removes key from the tree. Nodes left less than half full borrow an
+// entry from a sibling or are merged with it, and the root shrinks when it is
+// left with a single child.
+func (t *BPlusTree) Delete(key uint64) (bool, error) {
+	defer t.lock(false)()
+	atomic.AddUint64(&t.version, 1)
+
+	found, err := t.delete(key)
+	return found, t.commit(err)
+}
+
+// delete implements Delete.
+func (t *BPlusTree) delete(key uint64) (bool, error) {
+	lp, err := t.descendExclusive(key, t.safeForDelete)
+	if err != nil {
+		return false, err
+	}
+	defer t.releasePath(lp)
+	path := lp.path
+	leaf, handle, err := t.pin(path[len(path)-1], buffermanager.PinExclusive)
+	if err != nil {
+		return false, err
+	}
+
+	i := leaf.leafSearch(key)
+	if i == leaf.numKeys() || leaf.leafKey(i) != key {
+		return false, t.unpin(handle, false)
+	}
+	leaf.leafRemoveAt(i)
+	if err := t.unpin(handle, true); err != nil {
+		return true, err
+	}
+	return true, t.rebalance(lp)
+}
+
+// minKeys returns the occupancy below which a non-root node is rebalanced.
+// The bounds guarantee that two siblings at the minimum fit in one node after
+// a merge and that both halves of a split start at or above it.
+func (t *BPlusTree) minKeys(n node) int {
+	if n.isLeaf() {
+		return (t.maxLeafKeys + 1) / 2
+	}
+	return t.maxInternalKeys / 2
+}
+
+// fixResult describes what fixUnderflow did to a child and its parent.
+type fixResult int
+
+const (
+	fixNone          fixResult = iota // Child was not underfull or has no sibling
+	fixRedistributed                  // Entries moved between siblings; parent count unchanged
+	fixMerged                         // Siblings merged; parent lost a key
+)
+
+// rebalance restores minimum occupancy along lp.path after an entry was
+// removed from its last node. Only the latched pages can need it: the
+// highest one is safe, so merges stop there, and the root only collapses if
+// the meta page is still latched.
+func (t *BPlusTree) rebalance(lp *latchedPath) error {
+	path, indexes := lp.path, lp.indexes
+	for level := len(path) - 1; level > lp.top; level-- {
+		result, err := t.fixUnderflow(path[level-1], indexes[level-1], path[level])
+		if err != nil || result != fixMerged {
+			return err
+		}
+	}
+	if !lp.meta {
+		return nil
+	}
+	_, err := t.collapseRoot()
+	return err
+}
+
+// fixUnderflow rebalances the child at childIndex of parentID if it holds
+// too few keys, merging it with a sibling when both fit in one node and
+// moving entries over from the sibling otherwise.
+func (t *BPlusTree) fixUnderflow(parentID buffermanager.PageID, childIndex int, childID buffermanager.PageID) (fixResult, error) {
+	parent, parentHandle, err := t.pin(parentID, buffermanager.PinExclusive)
+	if err != nil {
+		return fixNone, err
+	}
+	child, childHandle, err := t.pin(childID, buffermanager.PinExclusive)
+	if err != nil {
+		t.unpin(parentHandle, false)
+		return fixNone, err
+	}
+	if child.numKeys() >= t.minKeys(child) || parent.numKeys() == 0 {
+		t.unpin(childHandle, false)
+		return fixNone, t.unpin(parentHandle, false)
+	}
+
+	// Pair the child with its left sibling when it has one, so that the
+	// separator between them is always key sepIndex of the parent.
+	sepIndex := childIndex - 1
+	if childIndex == 0 {
+		sepIndex = 0
+	}
+	siblingIndex := sepIndex
+	if childIndex == sepIndex {
+		siblingIndex = sepIndex + 1
+	}
+	siblingID := parent.child(siblingIndex)
+	t.latch(siblingID, true)
+	defer t.unlatch(siblingID, true)
+	sibling, siblingHandle, err := t.pin(siblingID, buffermanager.PinExclusive)
+	if err != nil {
+		t.unpin(childHandle, false)
+		t.unpin(parentHandle, false)
+		return fixNone, err
+	}
+
+	left, right := sibling, child
+	leftID, rightID := siblingID, childID
+	if siblingIndex > childIndex {
+		left, right = child, sibling
+		leftID, rightID = childID, siblingID
+	}
+
+	// Internal nodes also pull the separator down when merging.
+	combined := left.numKeys() + right.numKeys()
+	maxKeys := t.maxLeafKeys
+	if !child.isLeaf() {
+		combined++
+		maxKeys = t.maxInternalKeys
+	}
+
+	result := fixRedistributed
+	var nextID buffermanager.PageID
+	switch {
+	case combined <= maxKeys:
+		if child.isLeaf() {
+			nextID = right.next()
+		}
+		mergeSiblings(parent, sepIndex, left, right)
+		result = fixMerged
+	case siblingIndex < childIndex:
+		for child.numKeys() < t.minKeys(child) {
+			borrowFromLeft(parent, sepIndex, left, right)
+		}
+	default:
+		for child.numKeys() < t.minKeys(child) {
+			borrowFromRight(parent, sepIndex, left, right)
+		}
+	}
+
+	err = t.unpin(siblingHandle, true)
+	if unpinErr := t.unpin(childHandle, true); err == nil {
+		err = unpinErr
+	}
+	if unpinErr := t.unpin(parentHandle, true); err == nil {
+		err = unpinErr
+	}
+	if err != nil || result != fixMerged {
+		return result, err
+	}
+	if nextID != 0 {
+		if err := t.setPrev(nextID, leftID); err != nil {
+			return result, err
+		}
+	}
+	return result, t.free(rightID)
+}
+
+// collapseRoot replaces an internal root without keys by its only child,
+// repeatedly if needed. It reports whether the root changed.
+func (t *BPlusTree) collapseRoot() (bool, error) {
+	collapsed := false
+	for {
+		oldRoot, err := t.rootID()
+		if err != nil {
+			return collapsed, err
+		}
+		root, handle, err := t.pin(oldRoot, buffermanager.PinShared)
+		if err != nil {
+			return collapsed, err
+		}
+		if root.isLeaf() || root.numKeys() > 0 {
+			return collapsed, t.unpin(handle, false)
+		}
+
+		newRoot := root.child(0)
+		if err := t.unpin(handle, false); err != nil {
+			return collapsed, err
+		}
+		if err := t.setRoot(newRoot); err != nil {
+			return collapsed, err
+		}
+		if err := t.free(oldRoot); err != nil {
+			return collapsed, err
+		}
+		collapsed = true
+	}
+}
+
+// borrowFromLeft moves the last entry of left to the front of right and
+// updates the separator between them.
+func borrowFromLeft(parent node, sepIndex int, left, right node) {
+	last := left.numKeys() - 1
+	if left.isLeaf() {
+		right.leafInsertAt(0, left.leafKey(last), left.leafValue(last))
+		left.setNumKeys(last)
+		parent.setInternalKey(sepIndex, right.leafKey(0))
+		return
+	}
+	// The separator moves down into right and left's last key moves up.
+	right.internalInsertFirst(left.child(last+1), parent.internalKey(sepIndex))
+	parent.setInternalKey(sepIndex, left.internalKey(last))
+	left.setNumKeys(last)
+}
+
+// borrowFromRight moves the first entry of right to the end of left and
+// updates the separator between them.
+func borrowFromRight(parent node, sepIndex int, left, right node) {
+	count := left.numKeys()
+	if left.isLeaf() {
+		left.leafInsertAt(count, right.leafKey(0), right.leafValue(0))
+		right.leafRemoveAt(0)
+		parent.setInternalKey(sepIndex, right.leafKey(0))
+		return
+	}
+	// The separator moves down into left and right's first key moves up.
+	left.internalInsertAt(count, parent.internalKey(sepIndex), right.child(0))
+	parent.setInternalKey(sepIndex, right.internalKey(0))
+	right.internalRemoveFirst()
+}
+
+// mergeSiblings appends right to left and removes the separator and the
+// pointer to right from parent. The caller frees right's page.
+func mergeSiblings(parent node, sepIndex int, left, right node) {
+	leftCount, rightCount := left.numKeys(), right.numKeys()
+	if left.isLeaf() {
+		copy(left[leafOffset(leftCount):], right[leafOffset(0):leafOffset(rightCount)])
+		left.setNumKeys(leftCount + rightCount)
+		left.setNext(right.next())
+	} else {
+		left.internalInsertAt(leftCount, parent.internalKey(sepIndex), right.child(0))
+		copy(left[keyOffset(leftCount+1):], right[keyOffset(0):keyOffset(rightCount)])
+		left.setNumKeys(leftCount + 1 + rightCount)
+	}
+	parent.internalRemoveAt(sepIndex)
+}
@@ -0,0 +1,261 @@
+// btree/bplustree/deleterange.go
+package bplustree
+
+import (
+	"sync/atomic"
+
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// DeleteRange removes all keys between minKey and maxKey (inclusive).
+// Subtrees lying entirely inside the range are released page by page through
+// FreePage without looking at their keys; only the nodes on the paths to the
+// two range boundaries are edited and then rebalanced. It runs alone, without
+// other operations in parallel.
+func (t *BPlusTree) DeleteRange(minKey uint64, maxKey uint64) (int, error) {
+	defer t.lock(true)()
+
+	if minKey > maxKey {
+		return 0, nil
+	}
+	atomic.AddUint64(&t.version, 1)
+
+	deleted, err := t.removeRange(minKey, maxKey)
+	return deleted, t.commit(err)
+}
+
+// removeRange implements DeleteRange for minKey <= maxKey.
+func (t *BPlusTree) removeRange(minKey uint64, maxKey uint64) (int, error) {
+	rootID, err := t.rootID()
+	if err != nil {
+		return 0, err
+	}
+	deleted, rootFreed, err := t.deleteRange(rootID, 0, ^uint64(0), minKey, maxKey)
+	if err != nil {
+		return deleted, err
+	}
+	if rootFreed {
+		return deleted, t.resetRoot()
+	}
+	if err := t.relinkLeaves(minKey, maxKey); err != nil {
+		return deleted, err
+	}
+	return deleted, t.repair(minKey, maxKey)
+}
+
+// deleteRange removes the keys in [minKey, maxKey] from the subtree rooted at
+// pageID, whose keys all lie in [lo, hi]. It returns the number of keys
+// removed and whether the subtree's pages were freed altogether.
+func (t *BPlusTree) deleteRange(pageID buffermanager.PageID, lo, hi, minKey, maxKey uint64) (int, bool, error) {
+	if minKey <= lo && hi <= maxKey {
+		deleted, err := t.freeSubtree(pageID)
+		return deleted, true, err
+	}
+
+	n, handle, err := t.pin(pageID, buffermanager.PinExclusive)
+	if err != nil {
+		return 0, false, err
+	}
+	if n.isLeaf() {
+		count := n.numKeys()
+		start := n.leafSearch(minKey)
+		end := start
+		for end < count && n.leafKey(end) <= maxKey {
+			end++
+		}
+		copy(n[leafOffset(start):], n[leafOffset(end):leafOffset(count)])
+		n.setNumKeys(count - (end - start))
+		return end - start, false, t.unpin(handle, end > start)
+	}
+
+	keys, children := n.internalEntries()
+	first, last := n.childIndex(minKey), n.childIndex(maxKey)
+	if err := t.unpin(handle, false); err != nil {
+		return 0, false, err
+	}
+
+	deleted := 0
+	freed := make([]bool, len(children))
+	anyFreed := false
+	for i := first; i <= last; i++ {
+		childLo, childHi := lo, hi
+		if i > 0 {
+			childLo = keys[i-1]
+		}
+		if i < len(keys) {
+			childHi = keys[i] - 1
+		}
+		count, childFreed, err := t.deleteRange(children[i], childLo, childHi, minKey, maxKey)
+		deleted += count
+		if err != nil {
+			return deleted, false, err
+		}
+		freed[i] = childFreed
+		anyFreed = anyFreed || childFreed
+	}
+	if !anyFreed {
+		return deleted, false, nil
+	}
+
+	// Drop freed children together with the separator on their left (or on
+	// their right for child 0). Working right to left keeps the surviving
+	// separators valid: the key left of a kept child never moves.
+	for i := len(children) - 1; i >= 0; i-- {
+		if !freed[i] {
+			continue
+		}
+		children = append(children[:i], children[i+1:]...)
+		k := i - 1
+		if i == 0 {
+			k = 0
+		}
+		keys = append(keys[:k], keys[k+1:]...)
+	}
+
+	n, handle, err = t.pin(pageID, buffermanager.PinExclusive)
+	if err != nil {
+		return deleted, false, err
+	}
+	n.setInternalEntries(keys, children)
+	return deleted, false, t.unpin(handle, true)
+}
+
+// freeSubtree frees every page below and including pageID and returns the
+// number of keys the subtree held. Leaves are only pinned to read their key
+// count.
+func (t *BPlusTree) freeSubtree(pageID buffermanager.PageID) (int, error) {
+	n, handle, err := t.pin(pageID, buffermanager.PinShared)
+	if err != nil {
+		return 0, err
+	}
+	if n.isLeaf() {
+		count := n.numKeys()
+		if err := t.unpin(handle, false); err != nil {
+			return 0, err
+		}
+		return count, t.free(pageID)
+	}
+
+	_, children := n.internalEntries()
+	if err := t.unpin(handle, false); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, child := range children {
+		count, err := t.freeSubtree(child)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, t.free(pageID)
+}
+
+// resetRoot installs a fresh empty leaf as root after the whole tree was freed.
+func (t *BPlusTree) resetRoot() error {
+	rootID, err := t.bm.AllocatePage(t.btreeID)
+	if err != nil {
+		return err
+	}
+	root, handle, err := t.pin(rootID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	root.initLeaf()
+	if err := t.unpin(handle, true); err != nil {
+		return err
+	}
+	return t.setRoot(rootID)
+}
+
+// relinkLeaves repairs the leaf chain across a deleted range. The surviving
+// leaves around the range are, in key order, the leaf holding minKey-1, the
+// leaves where minKey and maxKey would live, and the leaf holding maxKey+1.
+// Any leaf that used to sit between them was freed. When the range reaches
+// either end of the key space, the outermost surviving leaf becomes the end
+// of the chain.
+func (t *BPlusTree) relinkLeaves(minKey, maxKey uint64) error {
+	var bounds []uint64
+	if minKey > 0 {
+		bounds = append(bounds, minKey-1)
+	}
+	bounds = append(bounds, minKey, maxKey)
+	if maxKey < ^uint64(0) {
+		bounds = append(bounds, maxKey+1)
+	}
+
+	var leaves []buffermanager.PageID
+	for _, key := range bounds {
+		path, _, err := t.descend(key)
+		if err != nil {
+			return err
+		}
+		leaf := path[len(path)-1]
+		if len(leaves) == 0 || leaves[len(leaves)-1] != leaf {
+			leaves = append(leaves, leaf)
+		}
+	}
+
+	for i, leaf := range leaves {
+		n, handle, err := t.pin(leaf, buffermanager.PinExclusive)
+		if err != nil {
+			return err
+		}
+		next, prev := n.next(), n.prev()
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		} else if maxKey == ^uint64(0) {
+			next = 0
+		}
+		if i > 0 {
+			prev = leaves[i-1]
+		} else if minKey == 0 {
+			prev = 0
+		}
+		dirty := next != n.next() || prev != n.prev()
+		n.setNext(next)
+		n.setPrev(prev)
+		if err := t.unpin(handle, dirty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repair rebalances the nodes on the paths to keys until none is underfull.
+// Every change can reshape those paths, so each one restarts from the root.
+func (t *BPlusTree) repair(keys ...uint64) error {
+	for {
+		changed, err := t.repairOnce(keys)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			collapsed, err := t.collapseRoot()
+			if err != nil || !collapsed {
+				return err
+			}
+		}
+	}
+}
+
+// repairOnce fixes the lowest underfull node it finds on the paths to keys
+// and reports whether it changed anything.
+func (t *BPlusTree) repairOnce(keys []uint64) (bool, error) {
+	for _, key := range keys {
+		path, indexes, err := t.descend(key)
+		if err != nil {
+			return false, err
+		}
+		for level := len(path) - 1; level > 0; level-- {
+			result, err := t.fixUnderflow(path[level-1], indexes[level-1], path[level])
+			if err != nil {
+				return false, err
+			}
+			if result != fixNone {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
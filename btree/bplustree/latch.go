@@ -0,0 +1,175 @@
+// btree/bplustree/latch.go
+package bplustree
+
+import (
+	"fmt"
+
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Concurrency
+//
+// When the buffer manager is a Latcher, operations run in parallel and
+// protect pages with their latches by crabbing: a descent latches a child
+// before it lets go of the parent. Readers hold shared latches and release
+// the parent right away. Insert and Delete hold exclusive latches and keep
+// every ancestor that a split or merge below might still modify, releasing
+// them as soon as they reach a safe node, one that absorbs the change without
+// passing it upwards. The meta page counts as the parent of the root.
+//
+// Latches are only ever waited for downwards, between siblings under a parent
+// latched exclusively, and from a leaf to its right neighbour while relinking
+// the leaf chain. Scans moving sideways along the chain only try to latch the
+// next leaf; if it is taken they let go and descend again from the root.
+// These rules rule out deadlocks.
+//
+// Pins latch their pages too, shared to read a page and exclusively to change
+// it, in a table of their own. A page is only pinned under a crabbing latch
+// at least as strong as its pin, so pin latches never make an operation wait.
+//
+// DeleteRange frees whole subtrees and repairs the tree from the root, so it
+// takes the tree lock exclusively and runs alone. So does every operation
+// when the buffer manager lacks latches, or when it is a Committer, whose
+// units would otherwise mix the pages of concurrent operations. The file
+// buffer manager is a Committer, so trees stored in files run one operation
+// at a time; only the mock buffer manager provides latches.
+
+// lock takes the tree lock for an operation and returns the function that
+// releases it.
+func (t *BPlusTree) lock(exclusive bool) func() {
+	if exclusive || t.latches == nil {
+		t.mu.Lock()
+		return t.mu.Unlock
+	}
+	t.mu.RLock()
+	return t.mu.RUnlock
+}
+
+// latch acquires the latch of a page, if the tree uses latches.
+func (t *BPlusTree) latch(pageID buffermanager.PageID, exclusive bool) {
+	if t.latches != nil {
+		t.latches.LatchPage(t.btreeID, pageID, exclusive)
+	}
+}
+
+// tryLatch acquires the latch of a page if it is free.
+func (t *BPlusTree) tryLatch(pageID buffermanager.PageID, exclusive bool) bool {
+	return t.latches == nil || t.latches.TryLatchPage(t.btreeID, pageID, exclusive)
+}
+
+// unlatch releases a latch acquired through latch or tryLatch.
+func (t *BPlusTree) unlatch(pageID buffermanager.PageID, exclusive bool) {
+	if t.latches != nil {
+		t.latches.UnlatchPage(t.btreeID, pageID, exclusive)
+	}
+}
+
+// release unpins a page read under a shared latch and releases the latch.
+func (t *BPlusTree) release(handle buffermanager.PageHandle) error {
+	pageID := handle.PageID()
+	err := t.unpin(handle, false)
+	t.unlatch(pageID, false)
+	return err
+}
+
+// latchedPath is a path from the root to a leaf, root first, of which the
+// pages from path[top] down are latched exclusively, together with the meta
+// page if meta is set.
+type latchedPath struct {
+	path    []buffermanager.PageID
+	indexes []int // indexes[i] is the position of path[i+1] in path[i]
+	top     int
+	meta    bool
+}
+
+// releaseAbove releases the latches of the pages above path[level].
+func (t *BPlusTree) releaseAbove(lp *latchedPath, level int) {
+	if lp.meta {
+		t.unlatch(metaPageID, true)
+		lp.meta = false
+	}
+	for ; lp.top < level; lp.top++ {
+		t.unlatch(lp.path[lp.top], true)
+	}
+}
+
+// releasePath releases every latch of lp.
+func (t *BPlusTree) releasePath(lp *latchedPath) {
+	t.releaseAbove(lp, len(lp.path))
+}
+
+// descendExclusive walks from the root to the leaf responsible for key,
+// latching pages exclusively and releasing the ancestors of every page for
+// which safe returns true. No pages remain pinned. The caller releases the
+// latches with releasePath.
+func (t *BPlusTree) descendExclusive(key uint64, safe func(n node, root bool) bool) (*latchedPath, error) {
+	t.latch(metaPageID, true)
+	lp := &latchedPath{meta: true}
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinShared)
+	if err != nil {
+		t.unlatch(metaPageID, true)
+		return nil, err
+	}
+	pageID := meta.root()
+	if err := t.unpin(handle, false); err != nil {
+		t.unlatch(metaPageID, true)
+		return nil, err
+	}
+
+	t.latch(pageID, true)
+	lp.path = append(lp.path, pageID)
+	for {
+		n, handle, err := t.pin(pageID, buffermanager.PinShared)
+		if err != nil {
+			t.releasePath(lp)
+			return nil, err
+		}
+		level := len(lp.path) - 1
+		if safe(n, level == 0) {
+			t.releaseAbove(lp, level)
+		}
+		switch n.pageType() {
+		case pageTypeLeaf:
+			if err := t.unpin(handle, false); err != nil {
+				t.releasePath(lp)
+				return nil, err
+			}
+			return lp, nil
+		case pageTypeInternal:
+			i := n.childIndex(key)
+			pageID = n.child(i)
+			if err := t.unpin(handle, false); err != nil {
+				t.releasePath(lp)
+				return nil, err
+			}
+			t.latch(pageID, true)
+			lp.path = append(lp.path, pageID)
+			lp.indexes = append(lp.indexes, i)
+		default:
+			t.unpin(handle, false)
+			t.releasePath(lp)
+			return nil, fmt.Errorf("%w: page %d has type %d", ErrCorruptPage, pageID, n.pageType())
+		}
+	}
+}
+
+// safeForInsert reports whether n takes one more entry without splitting.
+func (t *BPlusTree) safeForInsert(n node, root bool) bool {
+	if n.isLeaf() {
+		return n.numKeys() < t.maxLeafKeys
+	}
+	return n.numKeys() < t.maxInternalKeys
+}
+
+// safeForDelete reports whether n loses one entry without being rebalanced,
+// or, for the root, without collapsing.
+func (t *BPlusTree) safeForDelete(n node, root bool) bool {
+	switch {
+	case root && n.isLeaf():
+		return true
+	case root:
+		return n.numKeys() > 1
+	default:
+		return n.numKeys() > t.minKeys(n)
+	}
+}
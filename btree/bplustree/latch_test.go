@@ -0,0 +1,328 @@
+// btree/bplustree/latch_test.go
+package bplustree
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// newLatchedTree returns a tree over a mock buffer manager, which provides
+// page latches, so operations crab through the tree in parallel.
+func newLatchedTree(t *testing.T, options ...Option) (*BPlusTree, buffermanager.Latcher) {
+	t.Helper()
+	bm := buffermanager.NewMockBufferManager(buffermanager.WithBufferSize(64))
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := New(bm, btreeID, options...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if tree.latches == nil {
+		t.Fatal("Expected the tree to use the mock's page latches")
+	}
+	return tree, bm
+}
+
+// stressValue encodes key and a sequence number, so a reader can tell a
+// value that belongs to its key from a torn or misplaced one.
+func stressValue(key uint64, seq int) uint64 {
+	return key<<20 | uint64(seq)
+}
+
+// checkPairs fails the test unless pairs are strictly ordered in the given
+// direction and every value belongs to its key.
+func checkPairs(t *testing.T, pairs []btree.KeyValuePair, reverse bool) bool {
+	for i, p := range pairs {
+		if p.Value>>20 != p.Key {
+			t.Errorf("Expected a value written for key %d, got %#x", p.Key, p.Value)
+			return false
+		}
+		if i > 0 && (p.Key > pairs[i-1].Key) == reverse {
+			t.Errorf("Expected keys in order (reverse %v), got %d after %d", reverse, p.Key, pairs[i-1].Key)
+			return false
+		}
+	}
+	return true
+}
+
+// TestBPlusTree_ConcurrentStress runs writers and readers against one tree at
+// once. Each writer owns the keys congruent to its index, so it can check
+// every lookup against its own model. Readers scan in both directions and
+// look up stable keys that no writer touches. Run with -race.
+func TestBPlusTree_ConcurrentStress(t *testing.T) {
+	for _, maxKeys := range []int{4, 16} {
+		t.Run(fmt.Sprintf("MaxKeys%d", maxKeys), func(t *testing.T) {
+			tree, _ := newLatchedTree(t, WithMaxKeys(maxKeys))
+
+			const (
+				writers   = 8
+				readers   = 4
+				keySpace  = 4000
+				opsEach   = 2000
+				stableLo  = keySpace
+				stableCnt = 200
+			)
+			// Stable keys sit above the writers' keys, so every scan that
+			// covers them must return all of them.
+			for k := uint64(stableLo); k < stableLo+stableCnt; k++ {
+				if err := tree.Insert(k, stableValue(k)); err != nil {
+					t.Fatalf("Insert(%d) failed: %v", k, err)
+				}
+			}
+
+			models := make([]map[uint64]uint64, writers)
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				models[w] = make(map[uint64]uint64)
+				wg.Add(1)
+				go func(w int, model map[uint64]uint64) {
+					defer wg.Done()
+					rng := rand.New(rand.NewSource(int64(w)))
+					for seq := 0; seq < opsEach; seq++ {
+						key := uint64(rng.Intn(keySpace/writers)*writers + w)
+						switch op := rng.Intn(10); {
+						case op < 5:
+							value := stressValue(key, seq)
+							if err := tree.Insert(key, value); err != nil {
+								t.Errorf("Insert(%d) failed: %v", key, err)
+								return
+							}
+							model[key] = value
+						case op < 8:
+							_, expected := model[key]
+							found, err := tree.Delete(key)
+							if err != nil || found != expected {
+								t.Errorf("Expected (%v, nil) deleting %d, got (%v, %v)", expected, key, found, err)
+								return
+							}
+							delete(model, key)
+						default:
+							expected, exists := model[key]
+							if value, found := tree.Lookup(key); found != exists || value != expected {
+								t.Errorf("Expected (%d, %v) for key %d, got (%d, %v)", expected, exists, key, value, found)
+								return
+							}
+						}
+					}
+				}(w, models[w])
+			}
+
+			stop := make(chan struct{})
+			var readerWG sync.WaitGroup
+			for r := 0; r < readers; r++ {
+				readerWG.Add(1)
+				go func(r int) {
+					defer readerWG.Done()
+					rng := rand.New(rand.NewSource(int64(100 + r)))
+					for i := 0; i < 200; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						lo := uint64(rng.Intn(keySpace))
+						reverse := rng.Intn(2) == 0
+						var results <-chan btree.KeyValuePair
+						var scanErr btree.ScanErrFunc
+						var err error
+						if reverse {
+							results, scanErr, err = tree.ReverseScan(stableLo+stableCnt-1, lo)
+						} else {
+							results, scanErr, err = tree.Scan(lo, stableLo+stableCnt-1)
+						}
+						if err != nil {
+							t.Errorf("Scan failed: %v", err)
+							return
+						}
+						var pairs []btree.KeyValuePair
+						for p := range results {
+							pairs = append(pairs, p)
+						}
+						if err := scanErr(); err != nil {
+							t.Errorf("Scan failed: %v", err)
+							return
+						}
+						if !checkPairs(t, pairs, reverse) {
+							return
+						}
+						stable := 0
+						for _, p := range pairs {
+							if p.Key >= stableLo {
+								stable++
+							}
+						}
+						if stable != stableCnt {
+							t.Errorf("Expected %d stable keys in the scan, got %d", stableCnt, stable)
+							return
+						}
+
+						key := uint64(stableLo + rng.Intn(stableCnt))
+						if value, found := tree.Lookup(key); !found || value != stableValue(key) {
+							t.Errorf("Expected (%d, true) for stable key %d, got (%d, %v)", stableValue(key), key, value, found)
+							return
+						}
+					}
+				}(r)
+			}
+
+			wg.Wait()
+			close(stop)
+			readerWG.Wait()
+			if t.Failed() {
+				return
+			}
+
+			// Every model, plus the stable keys, must match the final tree.
+			expected := make(map[uint64]uint64)
+			for _, model := range models {
+				for k, v := range model {
+					expected[k] = v
+				}
+			}
+			for k := uint64(stableLo); k < stableLo+stableCnt; k++ {
+				expected[k] = stableValue(k)
+			}
+			if n := checkTree(t, tree); n != len(expected) {
+				t.Errorf("Expected %d keys in the tree, got %d", len(expected), n)
+			}
+			pairs := collectScan(t, tree, 0, ^uint64(0))
+			for _, p := range pairs {
+				if expected[p.Key] != p.Value {
+					t.Errorf("Expected %d for key %d, got %d", expected[p.Key], p.Key, p.Value)
+					break
+				}
+			}
+			if len(pairs) != len(expected) {
+				t.Errorf("Expected %d pairs, got %d", len(expected), len(pairs))
+			}
+		})
+	}
+}
+
+// stableValue is the value stored under a stable key of the stress test.
+func stableValue(key uint64) uint64 {
+	return stressValue(key, 0)
+}
+
+// TestBPlusTree_LatchesAreFineGrained checks that a latched leaf only holds
+// up the operations that need it.
+func TestBPlusTree_LatchesAreFineGrained(t *testing.T) {
+	tree, latches := newLatchedTree(t, WithMaxKeys(4))
+	for k := uint64(0); k < 100; k++ {
+		if err := tree.Insert(k, k); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", k, err)
+		}
+	}
+	lp, err := tree.descendExclusive(0, tree.safeForInsert)
+	if err != nil {
+		t.Fatalf("descend failed: %v", err)
+	}
+	firstLeaf := lp.path[len(lp.path)-1]
+	tree.releasePath(lp)
+
+	latches.LatchPage(tree.btreeID, firstLeaf, true)
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		tree.Lookup(0)
+	}()
+
+	// Test case 1: Operations on other leaves proceed.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if value, found := tree.Lookup(99); !found || value != 99 {
+			t.Errorf("Expected (99, true), got (%d, %v)", value, found)
+		}
+		if err := tree.Insert(98, 1); err != nil {
+			t.Errorf("Insert failed: %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected operations on other leaves to finish while one leaf is latched")
+	}
+
+	// Test case 2: A lookup in the latched leaf waits for the latch.
+	select {
+	case <-blocked:
+		t.Fatal("Expected Lookup(0) to wait for the latched leaf")
+	case <-time.After(50 * time.Millisecond):
+	}
+	latches.UnlatchPage(tree.btreeID, firstLeaf, true)
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Lookup(0) to finish once the leaf was unlatched")
+	}
+}
+
+// TestBPlusTree_TreesShareBufferManager runs two trees over one mock buffer
+// manager from several goroutines each, then closes them, which reports the
+// stack of any pin left behind. Run with -race.
+func TestBPlusTree_TreesShareBufferManager(t *testing.T) {
+	const (
+		workers = 4
+		keys    = 500
+	)
+	bm := buffermanager.NewMockBufferManager(buffermanager.WithBufferSize(64), buffermanager.WithPinTracking())
+	trees := make([]*BPlusTree, 2)
+	btreeIDs := make([]string, len(trees))
+	for i := range trees {
+		btreeID, err := bm.CreateBTree()
+		if err != nil {
+			t.Fatalf("CreateBTree failed: %v", err)
+		}
+		btreeIDs[i] = btreeID
+		if trees[i], err = New(bm, btreeID, WithMaxKeys(4)); err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, tree := range trees {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(i int, tree *BPlusTree, w int) {
+				defer wg.Done()
+				for k := uint64(w); k < keys; k += workers {
+					if err := tree.Insert(k, k+uint64(i)); err != nil {
+						t.Errorf("Insert(%d) failed: %v", k, err)
+						return
+					}
+					if value, found := tree.Lookup(k); !found || value != k+uint64(i) {
+						t.Errorf("Expected (%d, true), got (%d, %v)", k+uint64(i), value, found)
+						return
+					}
+				}
+			}(i, tree, w)
+		}
+	}
+	wg.Wait()
+
+	for i, tree := range trees {
+		pairs := collectScan(t, tree, 0, keys)
+		if len(pairs) != keys {
+			t.Fatalf("Expected %d pairs in tree %d, got %d", keys, i, len(pairs))
+		}
+		for _, p := range pairs {
+			if p.Value != p.Key+uint64(i) {
+				t.Errorf("Expected tree %d to map %d to %d, got %d", i, p.Key, p.Key+uint64(i), p.Value)
+			}
+		}
+	}
+	for _, btreeID := range btreeIDs {
+		if err := bm.CloseBTree(btreeID); err != nil {
+			t.Errorf("CloseBTree failed: %v", err)
+		}
+	}
+}
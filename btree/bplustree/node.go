@@ -0,0 +1,266 @@
+// btree/bplustree/node.go
+package bplustree
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Page types stored in the first byte of every page owned by a BPlusTree.
+const (
+	pageTypeMeta byte = iota + 1
+	pageTypeLeaf
+	pageTypeInternal
+)
+
+// Page layout shared by leaf and internal nodes:
+//
+//	[0]      page type
+//	[2:4]    number of keys
+//	[8:16]   next leaf PageID (leaves only)
+//	[16:24]  previous leaf PageID (leaves only)
+//	[16:]    internal node entries
+//	[24:]    leaf entries
+//
+// Leaf entries are (key, value) pairs of 16 bytes. Internal nodes store
+// child 0 at offset 16 followed by (key, child) pairs, so the child to the
+// right of key i lives directly after it. Leaves are linked in both
+// directions; PageID 0 marks either end of the chain.
+const (
+	offsetType     = 0
+	offsetNumKeys  = 2
+	offsetNext     = 8
+	offsetPrev     = 16
+	headerSize     = 16
+	leafHeaderSize = 24
+	entrySize      = 16
+
+	leafCapacity     = (buffermanager.PageSize - leafHeaderSize) / entrySize
+	internalCapacity = (buffermanager.PageSize - headerSize - 8) / entrySize
+)
+
+// Meta page layout. The meta page is the first page of a tree and records
+// where the root currently lives.
+const (
+	offsetMagic = 8
+	offsetRoot  = 16
+
+	// metaMagic changes with the page layout. metaMagicV1 marks trees whose
+	// leaves had 16-byte headers without a link to the previous leaf.
+	metaMagic   uint64 = 0x62706c7573747232 // "bplustr2"
+	metaMagicV1 uint64 = 0x62706c7573747265 // "bplustre"
+)
+
+// node is a view over a page that interprets it as a B+Tree node.
+type node []byte
+
+func (n node) pageType() byte {
+	return n[offsetType]
+}
+
+func (n node) isLeaf() bool {
+	return n[offsetType] == pageTypeLeaf
+}
+
+func (n node) numKeys() int {
+	return int(binary.LittleEndian.Uint16(n[offsetNumKeys:]))
+}
+
+func (n node) setNumKeys(count int) {
+	binary.LittleEndian.PutUint16(n[offsetNumKeys:], uint16(count))
+}
+
+// initLeaf formats the page as an empty leaf.
+func (n node) initLeaf() {
+	for i := 0; i < leafHeaderSize; i++ {
+		n[i] = 0
+	}
+	n[offsetType] = pageTypeLeaf
+}
+
+// initInternal formats the page as an internal node with a single child.
+func (n node) initInternal(child buffermanager.PageID) {
+	for i := 0; i < headerSize; i++ {
+		n[i] = 0
+	}
+	n[offsetType] = pageTypeInternal
+	n.setChild(0, child)
+}
+
+// --- Leaf accessors ---
+
+func leafOffset(i int) int {
+	return leafHeaderSize + i*entrySize
+}
+
+func (n node) leafKey(i int) uint64 {
+	return binary.LittleEndian.Uint64(n[leafOffset(i):])
+}
+
+func (n node) leafValue(i int) uint64 {
+	return binary.LittleEndian.Uint64(n[leafOffset(i)+8:])
+}
+
+func (n node) setLeafEntry(i int, key, value uint64) {
+	off := leafOffset(i)
+	binary.LittleEndian.PutUint64(n[off:], key)
+	binary.LittleEndian.PutUint64(n[off+8:], value)
+}
+
+func (n node) next() buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(n[offsetNext:]))
+}
+
+func (n node) setNext(id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(n[offsetNext:], uint64(id))
+}
+
+func (n node) prev() buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(n[offsetPrev:]))
+}
+
+func (n node) setPrev(id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(n[offsetPrev:], uint64(id))
+}
+
+// leafSearch returns the index of the first key >= key.
+func (n node) leafSearch(key uint64) int {
+	return sort.Search(n.numKeys(), func(i int) bool { return n.leafKey(i) >= key })
+}
+
+// leafInsertAt shifts entries right and stores (key, value) at index i.
+// The caller must ensure the page has room for one more entry.
+func (n node) leafInsertAt(i int, key, value uint64) {
+	count := n.numKeys()
+	copy(n[leafOffset(i+1):leafOffset(count+1)], n[leafOffset(i):leafOffset(count)])
+	n.setLeafEntry(i, key, value)
+	n.setNumKeys(count + 1)
+}
+
+// leafRemoveAt deletes the entry at index i, shifting later entries left.
+func (n node) leafRemoveAt(i int) {
+	count := n.numKeys()
+	copy(n[leafOffset(i):], n[leafOffset(i+1):leafOffset(count)])
+	n.setNumKeys(count - 1)
+}
+
+// --- Internal accessors ---
+
+func childOffset(i int) int {
+	return headerSize + i*entrySize
+}
+
+func keyOffset(i int) int {
+	return headerSize + 8 + i*entrySize
+}
+
+func (n node) internalKey(i int) uint64 {
+	return binary.LittleEndian.Uint64(n[keyOffset(i):])
+}
+
+func (n node) setInternalKey(i int, key uint64) {
+	binary.LittleEndian.PutUint64(n[keyOffset(i):], key)
+}
+
+func (n node) child(i int) buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(n[childOffset(i):]))
+}
+
+func (n node) setChild(i int, id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(n[childOffset(i):], uint64(id))
+}
+
+// childIndex returns the index of the child whose subtree may contain key.
+func (n node) childIndex(key uint64) int {
+	return sort.Search(n.numKeys(), func(i int) bool { return key < n.internalKey(i) })
+}
+
+// internalEntries returns copies of the node's keys and children.
+func (n node) internalEntries() ([]uint64, []buffermanager.PageID) {
+	count := n.numKeys()
+	keys := make([]uint64, count)
+	children := make([]buffermanager.PageID, count+1)
+	for i := 0; i < count; i++ {
+		keys[i] = n.internalKey(i)
+		children[i] = n.child(i)
+	}
+	children[count] = n.child(count)
+	return keys, children
+}
+
+// setInternalEntries replaces the node's contents. len(children) must be
+// len(keys)+1.
+func (n node) setInternalEntries(keys []uint64, children []buffermanager.PageID) {
+	for i, key := range keys {
+		n.setInternalKey(i, key)
+	}
+	for i, child := range children {
+		n.setChild(i, child)
+	}
+	n.setNumKeys(len(keys))
+}
+
+// internalInsertAt inserts key at index i with child as its right neighbour.
+// The caller must ensure the page has room for one more entry.
+func (n node) internalInsertAt(i int, key uint64, child buffermanager.PageID) {
+	count := n.numKeys()
+	copy(n[keyOffset(i+1):keyOffset(count+1)], n[keyOffset(i):keyOffset(count)])
+	n.setInternalKey(i, key)
+	n.setChild(i+1, child)
+	n.setNumKeys(count + 1)
+}
+
+// internalRemoveAt deletes key i together with its right child.
+func (n node) internalRemoveAt(i int) {
+	count := n.numKeys()
+	copy(n[keyOffset(i):], n[keyOffset(i+1):keyOffset(count)])
+	n.setNumKeys(count - 1)
+}
+
+// internalInsertFirst makes child the new leftmost child, separated from the
+// previous leftmost child by key.
+func (n node) internalInsertFirst(child buffermanager.PageID, key uint64) {
+	count := n.numKeys()
+	copy(n[childOffset(1):keyOffset(count+1)], n[childOffset(0):keyOffset(count)])
+	n.setChild(0, child)
+	n.setInternalKey(0, key)
+	n.setNumKeys(count + 1)
+}
+
+// internalRemoveFirst deletes the leftmost child and the key after it.
+func (n node) internalRemoveFirst() {
+	count := n.numKeys()
+	copy(n[childOffset(0):], n[childOffset(1):keyOffset(count)])
+	n.setNumKeys(count - 1)
+}
+
+// --- Meta accessors ---
+
+func (n node) initMeta(root buffermanager.PageID) {
+	for i := range n {
+		n[i] = 0
+	}
+	n[offsetType] = pageTypeMeta
+	binary.LittleEndian.PutUint64(n[offsetMagic:], metaMagic)
+	n.setRoot(root)
+}
+
+func (n node) isMeta() bool {
+	return n[offsetType] == pageTypeMeta && binary.LittleEndian.Uint64(n[offsetMagic:]) == metaMagic
+}
+
+// isOldMeta reports whether n is the meta page of a tree in the layout of
+// metaMagicV1.
+func (n node) isOldMeta() bool {
+	return n[offsetType] == pageTypeMeta && binary.LittleEndian.Uint64(n[offsetMagic:]) == metaMagicV1
+}
+
+func (n node) root() buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(n[offsetRoot:]))
+}
+
+func (n node) setRoot(id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(n[offsetRoot:], uint64(id))
+}
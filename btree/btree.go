@@ -1,23 +1,112 @@
 // btree/btree.go
 package btree
 
+import "context"
+
 // BTree defines the interface for a B-Tree data structure
 // that stores uint64 keys and values.
 type BTree interface {
-	// Lookup finds the value associated with the given key.
-	// Returns the value and true if found, or 0 and false if not found.
-	Lookup(key uint64) (value uint64, found bool)
+	ReadOnlyBTree
 
 	// Insert adds or updates a key-value pair in the tree.
 	// Returns an error if the operation fails, nil otherwise.
 	Insert(key uint64, value uint64) error
 
+	// Delete removes the key and its value from the tree.
+	// Returns true if the key was present, and an error if the operation fails.
+	Delete(key uint64) (found bool, err error)
+
+	// DeleteRange removes all keys between minKey and maxKey (inclusive),
+	// following the same range semantics as Scan.
+	// Returns the number of keys removed, and an error if the operation fails.
+	DeleteRange(minKey uint64, maxKey uint64) (deleted int, err error)
+}
+
+// ReadOnlyBTree is the part of BTree that does not modify the tree.
+type ReadOnlyBTree interface {
+	// Lookup finds the value associated with the given key.
+	// Returns the value and true if found, or 0 and false if not found.
+	Lookup(key uint64) (value uint64, found bool)
+
 	// Scan retrieves all key-value pairs where the key is between minKey and maxKey (inclusive).
 	// Results are streamed via a channel in ascending key order.
-	// The channel is closed after the last result or if an error occurs.
-	Scan(minKey uint64, maxKey uint64) (<-chan KeyValuePair, error)
+	// The channel is closed after the last result or if an error occurs;
+	// the returned ScanErrFunc tells the two apart.
+	// A consumer that stops reading early must use ScanContext instead.
+	Scan(minKey uint64, maxKey uint64) (<-chan KeyValuePair, ScanErrFunc, error)
+
+	// ScanContext behaves like Scan but stops producing results and closes the
+	// channel once ctx is cancelled, so abandoning a scan does not leak the
+	// goroutine feeding the channel.
+	// Returns ctx.Err() if ctx is already done.
+	ScanContext(ctx context.Context, minKey uint64, maxKey uint64) (<-chan KeyValuePair, ScanErrFunc, error)
+
+	// ReverseScan retrieves all key-value pairs where the key is between minKey and maxKey (inclusive).
+	// Results are streamed via a channel in descending key order, starting at maxKey.
+	// The channel is closed after the last result or if an error occurs;
+	// the returned ScanErrFunc tells the two apart.
+	// A consumer that stops reading early must use ReverseScanContext instead.
+	ReverseScan(maxKey uint64, minKey uint64) (<-chan KeyValuePair, ScanErrFunc, error)
+
+	// ReverseScanContext behaves like ReverseScan but stops producing results
+	// and closes the channel once ctx is cancelled.
+	// Returns ctx.Err() if ctx is already done.
+	ReverseScanContext(ctx context.Context, maxKey uint64, minKey uint64) (<-chan KeyValuePair, ScanErrFunc, error)
+
+	// Cursor returns a new unpositioned cursor over the tree.
+	// Returns an error if the cursor cannot be created.
+	Cursor() (Cursor, error)
 }
 
+// Cursor iterates over the key-value pairs of a BTree in either direction.
+// A new cursor is not positioned on any pair; call First, Last or Seek before
+// Next or Prev:
+//
+//	for ok := c.First(); ok; ok = c.Next() {
+//		use(c.Key(), c.Value())
+//	}
+//
+// Every positioning method returns whether the cursor now points at a pair.
+// Once it returns false the cursor stays invalid until it is repositioned,
+// and Err reports whether iteration stopped because of a failure rather than
+// because it ran out of pairs.
+type Cursor interface {
+	// Seek positions the cursor at the first pair whose key is >= key.
+	Seek(key uint64) bool
+
+	// First positions the cursor at the pair with the smallest key.
+	First() bool
+
+	// Last positions the cursor at the pair with the largest key.
+	Last() bool
+
+	// Next moves the cursor to the pair with the next larger key.
+	Next() bool
+
+	// Prev moves the cursor to the pair with the next smaller key.
+	Prev() bool
+
+	// Key returns the key of the current pair. Only valid after a positioning
+	// method returned true.
+	Key() uint64
+
+	// Value returns the value of the current pair. Only valid after a
+	// positioning method returned true.
+	Value() uint64
+
+	// Err returns the error that invalidated the cursor, if any.
+	Err() error
+
+	// Close releases the cursor. A closed cursor is never valid again.
+	Close() error
+}
+
+// ScanErrFunc reports why the channel of a scan was closed. It blocks until
+// the scan has finished and returns nil if every pair in the range was
+// delivered, the context's error if the scan was cancelled before reaching
+// the end of the range, or the error that cut the scan short.
+type ScanErrFunc func() error
+
 // KeyValuePair represents a key-value pair in the B+Tree
 type KeyValuePair struct {
 	Key   uint64
@@ -2,12 +2,18 @@
 package btree_test
 
 import (
+	"context"
 	"reflect"
+	"runtime"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/btree/blink"
+	"github.com/pillairaunak/btree-store-go/btree/bplustree"
 	"github.com/pillairaunak/btree-store-go/btree/inmemory" // Import the inmemory implementation
+	"github.com/pillairaunak/btree-store-go/buffermanager"
 )
 
 /*func TestBTreeInterface(t *testing.T) {
@@ -45,6 +51,129 @@ func TestBTreeInterface(t *testing.T) {
 		tree := inmemory.NewInMemoryBTree()
 		testBTreeScan(t, tree)
 	})
+
+	t.Run("Delete", func(t *testing.T) {
+		tree := inmemory.NewInMemoryBTree()
+		testBTreeDelete(t, tree)
+	})
+
+	t.Run("DeleteRange", func(t *testing.T) {
+		tree := inmemory.NewInMemoryBTree()
+		testBTreeDeleteRange(t, tree)
+	})
+
+	t.Run("ScanContext", func(t *testing.T) {
+		tree := inmemory.NewInMemoryBTree()
+		testBTreeScanContext(t, tree)
+	})
+
+	t.Run("Cursor", func(t *testing.T) {
+		tree := inmemory.NewInMemoryBTree()
+		testBTreeCursor(t, tree)
+	})
+
+	t.Run("ReverseScan", func(t *testing.T) {
+		tree := inmemory.NewInMemoryBTree()
+		testBTreeReverseScan(t, tree)
+	})
+}
+
+func TestBPlusTreeInterface(t *testing.T) {
+	t.Run("Lookup", func(t *testing.T) {
+		testBTreeLookup(t, newBPlusTree(t))
+	})
+
+	t.Run("Insert", func(t *testing.T) {
+		testBTreeInsert(t, newBPlusTree(t))
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		testBTreeScan(t, newBPlusTree(t))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		testBTreeDelete(t, newBPlusTree(t))
+	})
+
+	t.Run("DeleteRange", func(t *testing.T) {
+		testBTreeDeleteRange(t, newBPlusTree(t))
+	})
+
+	t.Run("ScanContext", func(t *testing.T) {
+		testBTreeScanContext(t, newBPlusTree(t))
+	})
+
+	t.Run("Cursor", func(t *testing.T) {
+		testBTreeCursor(t, newBPlusTree(t))
+	})
+
+	t.Run("ReverseScan", func(t *testing.T) {
+		testBTreeReverseScan(t, newBPlusTree(t))
+	})
+}
+
+func TestBLinkTreeInterface(t *testing.T) {
+	t.Run("Lookup", func(t *testing.T) {
+		testBTreeLookup(t, newBLinkTree(t))
+	})
+
+	t.Run("Insert", func(t *testing.T) {
+		testBTreeInsert(t, newBLinkTree(t))
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		testBTreeScan(t, newBLinkTree(t))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		testBTreeDelete(t, newBLinkTree(t))
+	})
+
+	t.Run("DeleteRange", func(t *testing.T) {
+		testBTreeDeleteRange(t, newBLinkTree(t))
+	})
+
+	t.Run("ScanContext", func(t *testing.T) {
+		testBTreeScanContext(t, newBLinkTree(t))
+	})
+
+	t.Run("Cursor", func(t *testing.T) {
+		testBTreeCursor(t, newBLinkTree(t))
+	})
+
+	t.Run("ReverseScan", func(t *testing.T) {
+		testBTreeReverseScan(t, newBLinkTree(t))
+	})
+}
+
+// newBPlusTree creates a B+Tree backed by a fresh mock buffer manager.
+func newBPlusTree(t *testing.T) btree.BTree {
+	t.Helper()
+	bm := buffermanager.NewMockBufferManager()
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := bplustree.New(bm, btreeID)
+	if err != nil {
+		t.Fatalf("bplustree.New failed: %v", err)
+	}
+	return tree
+}
+
+// newBLinkTree creates a B-link tree backed by a fresh mock buffer manager.
+func newBLinkTree(t *testing.T) btree.BTree {
+	t.Helper()
+	bm := buffermanager.NewMockBufferManager()
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := blink.New(bm, btreeID)
+	if err != nil {
+		t.Fatalf("blink.New failed: %v", err)
+	}
+	return tree
 }
 
 func testBTreeLookup(t *testing.T, tree btree.BTree) {
@@ -116,7 +245,7 @@ func testBTreeScan(t *testing.T, tree btree.BTree) {
 	}
 
 	// Helper function to convert channel results to a slice for easier comparison
-	collectResults := func(results <-chan btree.KeyValuePair, err error) ([]btree.KeyValuePair, error) {
+	collectResults := func(results <-chan btree.KeyValuePair, scanErr btree.ScanErrFunc, err error) ([]btree.KeyValuePair, error) {
 		if err != nil {
 			return nil, err
 		}
@@ -124,13 +253,13 @@ func testBTreeScan(t *testing.T, tree btree.BTree) {
 		for r := range results {
 			collected = append(collected, r)
 		}
-		return collected, nil
+		return collected, scanErr()
 	}
 
 	// Test case 1: Scan entire range
 	t.Run("Scan entire range", func(t *testing.T) {
-		resultsChan, err := tree.Scan(0, 100)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(0, 100)
+		results, err := collectResults(resultsChan, scanErr, err)
 
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
@@ -141,8 +270,8 @@ func testBTreeScan(t *testing.T, tree btree.BTree) {
 	})
 	// Test case 2: Scan partial range
 	t.Run("Scan partial range", func(t *testing.T) {
-		resultsChan, err := tree.Scan(20, 40)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(20, 40)
+		results, err := collectResults(resultsChan, scanErr, err)
 
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
@@ -160,8 +289,8 @@ func testBTreeScan(t *testing.T, tree btree.BTree) {
 
 	// Test case 3: Scan empty range
 	t.Run("Scan empty range", func(t *testing.T) {
-		resultsChan, err := tree.Scan(60, 70)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(60, 70)
+		results, err := collectResults(resultsChan, scanErr, err)
 
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
@@ -173,8 +302,8 @@ func testBTreeScan(t *testing.T, tree btree.BTree) {
 
 	// Test case 4: minKey > maxKey
 	t.Run("minKey > maxKey", func(t *testing.T) {
-		resultsChan, err := tree.Scan(70, 60)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(70, 60)
+		results, err := collectResults(resultsChan, scanErr, err)
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
 		}
@@ -185,8 +314,8 @@ func testBTreeScan(t *testing.T, tree btree.BTree) {
 
 	// Test case 5: Scan with boundaries
 	t.Run("Scan with boundaries", func(t *testing.T) {
-		resultsChan, err := tree.Scan(10, 50) // Exact boundaries of the data
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(10, 50) // Exact boundaries of the data
+		results, err := collectResults(resultsChan, scanErr, err)
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
 		}
@@ -207,3 +336,366 @@ func testBTreeScan(t *testing.T, tree btree.BTree) {
 		}
 	})
 }
+
+func testBTreeDelete(t *testing.T, tree btree.BTree) {
+	// Test case 1: Delete a non-existent key
+	found, err := tree.Delete(42)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if found {
+		t.Errorf("Expected key 42 to not be found, but it was")
+	}
+
+	// Test case 2: Insert, delete and lookup
+	for k := uint64(1); k <= 5; k++ {
+		if err := tree.Insert(k*10, k*100); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	found, err = tree.Delete(30)
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if !found {
+		t.Errorf("Expected key 30 to be found, but it wasn't")
+	}
+	if _, found := tree.Lookup(30); found {
+		t.Errorf("Expected key 30 to be deleted, but it was found")
+	}
+
+	// Test case 3: Delete the same key again
+	if found, _ := tree.Delete(30); found {
+		t.Errorf("Expected second delete of key 30 to report not found")
+	}
+
+	// Test case 4: Other keys are untouched
+	results, _, err := tree.Scan(0, 100)
+	if err != nil {
+		t.Fatalf("Scan returned unexpected error: %v", err)
+	}
+	var keys []uint64
+	for r := range results {
+		keys = append(keys, r.Key)
+	}
+	if expected := []uint64{10, 20, 40, 50}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %v after delete, got %v", expected, keys)
+	}
+
+	// Test case 5: Re-insert a deleted key
+	if err := tree.Insert(30, 301); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if value, found := tree.Lookup(30); !found || value != 301 {
+		t.Errorf("Expected (301, true) for re-inserted key 30, got (%d, %v)", value, found)
+	}
+}
+
+func testBTreeDeleteRange(t *testing.T, tree btree.BTree) {
+	for k := uint64(1); k <= 10; k++ {
+		if err := tree.Insert(k*10, k*100); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	remainingKeys := func() []uint64 {
+		results, _, err := tree.Scan(0, ^uint64(0))
+		if err != nil {
+			t.Fatalf("Scan returned unexpected error: %v", err)
+		}
+		var keys []uint64
+		for r := range results {
+			keys = append(keys, r.Key)
+		}
+		return keys
+	}
+
+	// Test case 1: minKey > maxKey deletes nothing
+	if deleted, err := tree.DeleteRange(70, 60); err != nil || deleted != 0 {
+		t.Errorf("Expected (0, nil) when minKey > maxKey, got (%d, %v)", deleted, err)
+	}
+
+	// Test case 2: Empty range
+	if deleted, err := tree.DeleteRange(41, 49); err != nil || deleted != 0 {
+		t.Errorf("Expected (0, nil) for empty range, got (%d, %v)", deleted, err)
+	}
+
+	// Test case 3: Inclusive boundaries
+	deleted, err := tree.DeleteRange(30, 50)
+	if err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("Expected 3 keys deleted for range [30, 50], got %d", deleted)
+	}
+	if expected := []uint64{10, 20, 60, 70, 80, 90, 100}; !reflect.DeepEqual(remainingKeys(), expected) {
+		t.Errorf("Expected keys %v after DeleteRange, got %v", expected, remainingKeys())
+	}
+
+	// Test case 4: Range that overlaps deleted keys and the end of the tree
+	if deleted, _ := tree.DeleteRange(45, 1000); deleted != 5 {
+		t.Errorf("Expected 5 keys deleted for range [45, 1000], got %d", deleted)
+	}
+
+	// Test case 5: Everything
+	if deleted, _ := tree.DeleteRange(0, ^uint64(0)); deleted != 2 {
+		t.Errorf("Expected 2 keys deleted for the full range, got %d", deleted)
+	}
+	if keys := remainingKeys(); len(keys) != 0 {
+		t.Errorf("Expected empty tree, got %v", keys)
+	}
+	if err := tree.Insert(5, 50); err != nil {
+		t.Fatalf("Insert after clearing the tree failed: %v", err)
+	}
+	if value, found := tree.Lookup(5); !found || value != 50 {
+		t.Errorf("Expected (50, true) for key 5, got (%d, %v)", value, found)
+	}
+}
+
+func testBTreeScanContext(t *testing.T, tree btree.BTree) {
+	for k := uint64(0); k < 1000; k++ {
+		if err := tree.Insert(k, k); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// Test case 1: Uncancelled scans return every pair
+	t.Run("Complete", func(t *testing.T) {
+		results, scanErr, err := tree.ScanContext(context.Background(), 100, 199)
+		if err != nil {
+			t.Fatalf("ScanContext returned unexpected error: %v", err)
+		}
+		count := 0
+		for range results {
+			count++
+		}
+		if count != 100 {
+			t.Errorf("Expected 100 results, got %d", count)
+		}
+		if err := scanErr(); err != nil {
+			t.Errorf("Expected complete scan to report no error, got: %v", err)
+		}
+	})
+
+	// Test case 2: Already cancelled context
+	t.Run("CancelledBeforeScan", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, _, err := tree.ScanContext(ctx, 0, 999); err != context.Canceled {
+			t.Errorf("Expected context.Canceled, got: %v", err)
+		}
+	})
+
+	// Test case 3: Abandoned scans close their channel and leak no goroutines
+	t.Run("AbortEarly", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+		for i := 0; i < 20; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			results, scanErr, err := tree.ScanContext(ctx, 0, 999)
+			if err != nil {
+				t.Fatalf("ScanContext returned unexpected error: %v", err)
+			}
+			<-results
+			cancel()
+
+			// Pairs already in flight may still arrive, but the channel must close.
+			deadline := time.After(5 * time.Second)
+			for open := true; open; {
+				select {
+				case _, open = <-results:
+				case <-deadline:
+					t.Fatal("Channel was not closed after cancellation")
+				}
+			}
+			if err := scanErr(); err != context.Canceled {
+				t.Errorf("Expected truncated scan to report context.Canceled, got: %v", err)
+			}
+		}
+
+		for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected at most %d goroutines after aborted scans, got %d", before, runtime.NumGoroutine())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func testBTreeCursor(t *testing.T, tree btree.BTree) {
+	newCursor := func() btree.Cursor {
+		c, err := tree.Cursor()
+		if err != nil {
+			t.Fatalf("Cursor returned unexpected error: %v", err)
+		}
+		return c
+	}
+
+	// Test case 1: Empty tree
+	c := newCursor()
+	if c.First() || c.Last() || c.Seek(0) {
+		t.Error("Expected no pairs in an empty tree")
+	}
+	if c.Next() || c.Prev() {
+		t.Error("Expected Next and Prev to fail on an unpositioned cursor")
+	}
+	c.Close()
+
+	// Enough keys to span several pages in paged implementations.
+	const n = 1000
+	for k := uint64(1); k <= n; k++ {
+		if err := tree.Insert(k*10, k*100); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	c = newCursor()
+	defer c.Close()
+
+	// Test case 2: Forward iteration visits every pair in ascending order
+	count := 0
+	for ok := c.First(); ok; ok = c.Next() {
+		count++
+		if c.Key() != uint64(count)*10 || c.Value() != uint64(count)*100 {
+			t.Fatalf("Expected pair (%d, %d), got (%d, %d)", count*10, count*100, c.Key(), c.Value())
+		}
+	}
+	if count != n || c.Err() != nil {
+		t.Errorf("Expected %d pairs and no error, got %d pairs and %v", n, count, c.Err())
+	}
+
+	// Test case 3: Backward iteration visits every pair in descending order
+	count = 0
+	for ok := c.Last(); ok; ok = c.Prev() {
+		if expected := uint64(n-count) * 10; c.Key() != expected {
+			t.Fatalf("Expected key %d, got %d", expected, c.Key())
+		}
+		count++
+	}
+	if count != n || c.Err() != nil {
+		t.Errorf("Expected %d pairs and no error, got %d pairs and %v", n, count, c.Err())
+	}
+
+	// Test case 4: Seek lands on the first key >= the target
+	if !c.Seek(4995) || c.Key() != 5000 {
+		t.Errorf("Expected Seek(4995) to land on 5000, got %d", c.Key())
+	}
+	if !c.Seek(5000) || c.Key() != 5000 {
+		t.Errorf("Expected Seek(5000) to land on 5000, got %d", c.Key())
+	}
+
+	// Test case 5: Changing direction after a seek
+	if !c.Prev() || c.Key() != 4990 {
+		t.Errorf("Expected Prev after Seek(5000) to land on 4990, got %d", c.Key())
+	}
+	if !c.Next() || !c.Next() || c.Key() != 5010 {
+		t.Errorf("Expected two Next calls to land on 5010, got %d", c.Key())
+	}
+
+	// Test case 6: Seeking past the last key and running off either end
+	if c.Seek(n*10 + 1) {
+		t.Errorf("Expected Seek past the last key to fail, got key %d", c.Key())
+	}
+	if c.Next() {
+		t.Error("Expected the cursor to stay invalid after running off the end")
+	}
+	if !c.First() || c.Prev() {
+		t.Error("Expected Prev from the first pair to fail")
+	}
+
+	// Test case 7: Modifications are visible after repositioning
+	if err := tree.Insert(5, 50); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if !c.First() || c.Key() != 5 || c.Value() != 50 {
+		t.Errorf("Expected First to land on newly inserted key 5, got %d", c.Key())
+	}
+
+	// Test case 8: Closed cursors are never valid
+	if err := c.Close(); err != nil {
+		t.Errorf("Close returned unexpected error: %v", err)
+	}
+	if c.First() || c.Seek(10) {
+		t.Error("Expected a closed cursor to stay invalid")
+	}
+}
+
+func testBTreeReverseScan(t *testing.T, tree btree.BTree) {
+	reverseKeys := func(maxKey, minKey uint64) []uint64 {
+		results, scanErr, err := tree.ReverseScan(maxKey, minKey)
+		if err != nil {
+			t.Fatalf("ReverseScan returned unexpected error: %v", err)
+		}
+		var keys []uint64
+		for r := range results {
+			if r.Value != r.Key*100 {
+				t.Errorf("Expected value %d for key %d, got %d", r.Key*100, r.Key, r.Value)
+			}
+			keys = append(keys, r.Key)
+		}
+		if err := scanErr(); err != nil {
+			t.Errorf("ReverseScan reported unexpected error: %v", err)
+		}
+		return keys
+	}
+
+	// Test case 1: Empty tree
+	if keys := reverseKeys(^uint64(0), 0); len(keys) != 0 {
+		t.Errorf("Expected no results from an empty tree, got %v", keys)
+	}
+
+	// Enough keys to span several pages in paged implementations.
+	const n = 1000
+	for k := uint64(1); k <= n; k++ {
+		if err := tree.Insert(k, k*100); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// Test case 2: Whole tree in descending order
+	keys := reverseKeys(^uint64(0), 0)
+	if len(keys) != n {
+		t.Fatalf("Expected %d results, got %d", n, len(keys))
+	}
+	for i, key := range keys {
+		if key != uint64(n-i) {
+			t.Fatalf("Expected key %d at position %d, got %d", n-i, i, key)
+		}
+	}
+
+	// Test case 3: Inclusive bounds
+	if keys := reverseKeys(505, 500); !reflect.DeepEqual(keys, []uint64{505, 504, 503, 502, 501, 500}) {
+		t.Errorf("Expected [505 ... 500], got %v", keys)
+	}
+
+	// Test case 4: maxKey < minKey yields nothing
+	if keys := reverseKeys(10, 20); len(keys) != 0 {
+		t.Errorf("Expected no results when maxKey < minKey, got %v", keys)
+	}
+
+	// Test case 5: Bounds outside the stored keys
+	if keys := reverseKeys(5000, 998); !reflect.DeepEqual(keys, []uint64{1000, 999, 998}) {
+		t.Errorf("Expected [1000 999 998], got %v", keys)
+	}
+	if keys := reverseKeys(2, 0); !reflect.DeepEqual(keys, []uint64{2, 1}) {
+		t.Errorf("Expected [2 1], got %v", keys)
+	}
+
+	// Test case 6: Abandoned reverse scans stop once the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	results, _, err := tree.ReverseScanContext(ctx, ^uint64(0), 0)
+	if err != nil {
+		t.Fatalf("ReverseScanContext returned unexpected error: %v", err)
+	}
+	if first := <-results; first.Key != n {
+		t.Errorf("Expected first key %d, got %d", n, first.Key)
+	}
+	cancel()
+	deadline := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-results:
+		case <-deadline:
+			t.Fatal("Channel was not closed after cancellation")
+		}
+	}
+}
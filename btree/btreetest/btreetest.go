@@ -0,0 +1,83 @@
+// btree/btreetest/btreetest.go
+
+// Package btreetest provides helpers shared by the tests of BTree
+// implementations and of the packages built on them.
+package btreetest
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// Drain returns a function that reads every pair from a scan, failing the
+// test if the scan fails, so it can be applied to the results of Scan:
+//
+//	pairs := btreetest.Drain(t)(tree.Scan(0, 100))
+func Drain(t testing.TB) func(<-chan btree.KeyValuePair, btree.ScanErrFunc, error) []btree.KeyValuePair {
+	return func(results <-chan btree.KeyValuePair, scanErr btree.ScanErrFunc, err error) []btree.KeyValuePair {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		var pairs []btree.KeyValuePair
+		for p := range results {
+			pairs = append(pairs, p)
+		}
+		if err := scanErr(); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		return pairs
+	}
+}
+
+// Contents reads every pair of tree with a scan, a reverse scan and a cursor
+// moving in each direction, and fails the test unless all of them agree with
+// each other and with lookups.
+func Contents(t testing.TB, tree btree.ReadOnlyBTree) map[uint64]uint64 {
+	t.Helper()
+	forward := Drain(t)(tree.Scan(0, math.MaxUint64))
+	backward := Drain(t)(tree.ReverseScan(math.MaxUint64, 0))
+
+	c, err := tree.Cursor()
+	if err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+	defer c.Close()
+	var cursoredForward, cursoredBackward []btree.KeyValuePair
+	for ok := c.First(); ok; ok = c.Next() {
+		cursoredForward = append(cursoredForward, btree.KeyValuePair{Key: c.Key(), Value: c.Value()})
+	}
+	for ok := c.Last(); ok; ok = c.Prev() {
+		cursoredBackward = append(cursoredBackward, btree.KeyValuePair{Key: c.Key(), Value: c.Value()})
+	}
+	if err := c.Err(); err != nil {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+
+	contents := make(map[uint64]uint64)
+	for i, p := range forward {
+		if i > 0 && p.Key <= forward[i-1].Key {
+			t.Fatalf("Expected ascending keys, got %d after %d", p.Key, forward[i-1].Key)
+		}
+		if j := len(backward) - 1 - i; j < 0 || backward[j] != p {
+			t.Fatalf("Expected the reverse scan to mirror the scan at key %d", p.Key)
+		}
+		if value, found := tree.Lookup(p.Key); !found || value != p.Value {
+			t.Fatalf("Expected (%d, true) for key %d, got (%d, %v)", p.Value, p.Key, value, found)
+		}
+		contents[p.Key] = p.Value
+	}
+	if len(backward) != len(forward) {
+		t.Fatalf("Expected %d pairs in the reverse scan, got %d", len(forward), len(backward))
+	}
+	if !reflect.DeepEqual(cursoredForward, forward) {
+		t.Fatalf("Expected the cursor to match the scan, got %d pairs instead of %d", len(cursoredForward), len(forward))
+	}
+	if !reflect.DeepEqual(cursoredBackward, backward) {
+		t.Fatalf("Expected the cursor moving backwards to match the reverse scan, got %d pairs instead of %d", len(cursoredBackward), len(backward))
+	}
+	return contents
+}
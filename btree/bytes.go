@@ -0,0 +1,43 @@
+// btree/bytes.go
+package btree
+
+import "context"
+
+// BytesTree defines the interface for a B-Tree that stores variable-length
+// byte-slice keys and values. Keys are ordered lexicographically, as by
+// bytes.Compare, and a nil key is the same as an empty one.
+//
+// Slices passed to a BytesTree may be reused by the caller once the call
+// returns, and slices returned by it are owned by the caller.
+type BytesTree interface {
+	// Lookup finds the value associated with the given key.
+	// Returns the value and true if found, or nil and false if not found.
+	Lookup(key []byte) (value []byte, found bool)
+
+	// Insert adds or updates a key-value pair in the tree.
+	// Returns an error if the pair is too large to store or the operation fails.
+	Insert(key []byte, value []byte) error
+
+	// Delete removes the key and its value from the tree.
+	// Returns true if the key was present, and an error if the operation fails.
+	Delete(key []byte) (found bool, err error)
+
+	// Scan retrieves all key-value pairs where start <= key < end.
+	// A nil end means the range has no upper bound.
+	// Results are streamed via a channel in ascending key order.
+	// The channel is closed after the last result or if an error occurs;
+	// the returned ScanErrFunc tells the two apart.
+	// A consumer that stops reading early must use ScanContext instead.
+	Scan(start []byte, end []byte) (<-chan BytesPair, ScanErrFunc, error)
+
+	// ScanContext behaves like Scan but stops producing results and closes the
+	// channel once ctx is cancelled.
+	// Returns ctx.Err() if ctx is already done.
+	ScanContext(ctx context.Context, start []byte, end []byte) (<-chan BytesPair, ScanErrFunc, error)
+}
+
+// BytesPair represents a key-value pair in a BytesTree.
+type BytesPair struct {
+	Key   []byte
+	Value []byte
+}
@@ -0,0 +1,99 @@
+// btree/bytes_test.go
+package btree_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/btree/bytestree"
+	"github.com/pillairaunak/btree-store-go/btree/inmemory"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+func TestBytesTreeInterface(t *testing.T) {
+	t.Run("InMemory", func(t *testing.T) {
+		testBytesTree(t, inmemory.NewInMemoryBytesTree())
+	})
+
+	t.Run("BytesTree", func(t *testing.T) {
+		bm := buffermanager.NewMockBufferManager()
+		btreeID, err := bm.CreateBTree()
+		if err != nil {
+			t.Fatalf("CreateBTree failed: %v", err)
+		}
+		tree, err := bytestree.New(bm, btreeID)
+		if err != nil {
+			t.Fatalf("bytestree.New failed: %v", err)
+		}
+		testBytesTree(t, tree)
+	})
+}
+
+func testBytesTree(t *testing.T, tree btree.BytesTree) {
+	// Test case 1: Looking up a non-existent key
+	if _, found := tree.Lookup([]byte("missing")); found {
+		t.Error("Expected key \"missing\" to not be found, but it was")
+	}
+
+	// Test case 2: Insert, update and lookup, including the empty key
+	for _, kv := range []struct{ key, value string }{
+		{"banana", "yellow"}, {"apple", "red"}, {"", "empty key"}, {"apple", "green"}, {"b", ""},
+	} {
+		if err := tree.Insert([]byte(kv.key), []byte(kv.value)); err != nil {
+			t.Fatalf("Insert(%q) failed: %v", kv.key, err)
+		}
+	}
+	if value, found := tree.Lookup([]byte("apple")); !found || string(value) != "green" {
+		t.Errorf("Expected (\"green\", true) for key \"apple\", got (%q, %v)", value, found)
+	}
+	if value, found := tree.Lookup(nil); !found || string(value) != "empty key" {
+		t.Errorf("Expected nil to look up the empty key, got (%q, %v)", value, found)
+	}
+
+	// Test case 3: The tree keeps its own copies of keys and values
+	key, value := []byte("cherry"), []byte("dark red")
+	tree.Insert(key, value)
+	key[0], value[0] = 'x', 'x'
+	if got, found := tree.Lookup([]byte("cherry")); !found || string(got) != "dark red" {
+		t.Errorf("Expected the tree to be unaffected by caller writes, got (%q, %v)", got, found)
+	}
+	got, _ := tree.Lookup([]byte("cherry"))
+	got[0] = 'x'
+	if got, _ := tree.Lookup([]byte("cherry")); string(got) != "dark red" {
+		t.Errorf("Expected returned values to be copies, got %q", got)
+	}
+
+	// Test case 4: Lexicographic scan order with a half-open range
+	results, scanErr, err := tree.Scan([]byte("a"), []byte("c"))
+	if err != nil {
+		t.Fatalf("Scan returned unexpected error: %v", err)
+	}
+	var keys []string
+	for r := range results {
+		keys = append(keys, string(r.Key))
+	}
+	if err := scanErr(); err != nil {
+		t.Errorf("Scan reported unexpected error: %v", err)
+	}
+	if expected := []string{"apple", "b", "banana"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected keys %q, got %q", expected, keys)
+	}
+
+	// Test case 5: Delete
+	if found, err := tree.Delete([]byte("banana")); err != nil || !found {
+		t.Errorf("Expected (true, nil) deleting \"banana\", got (%v, %v)", found, err)
+	}
+	if found, _ := tree.Delete([]byte("banana")); found {
+		t.Error("Expected second delete of \"banana\" to report not found")
+	}
+	results, _, _ = tree.Scan(nil, nil)
+	var all [][]byte
+	for r := range results {
+		all = append(all, r.Key)
+	}
+	if len(all) != 4 || len(all[0]) != 0 || !bytes.Equal(all[3], []byte("cherry")) {
+		t.Errorf("Expected [\"\" apple b cherry], got %q", all)
+	}
+}
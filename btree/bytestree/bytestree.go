@@ -0,0 +1,653 @@
+// btree/bytestree/bytestree.go
+package bytestree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Common errors returned by BytesTree operations.
+var (
+	ErrCorruptPage     = errors.New("corrupt bytestree page")
+	ErrEntryTooLarge   = errors.New("key-value pair too large for a bytestree page")
+	ErrMetaPageMissing = errors.New("btree has pages but no bytestree meta page")
+)
+
+// MaxEntrySize is the largest combined length of a key and its value that a
+// BytesTree accepts.
+const MaxEntrySize = maxCellSize - leafCellHeader
+
+// MaxKeySize is the largest key a BytesTree accepts. Keys are copied into
+// internal nodes as separators, which need room for a child pointer.
+const MaxKeySize = maxCellSize - internalCellHeader
+
+// metaPageID is the page holding the root pointer. It is the first page
+// allocated for a tree.
+const metaPageID buffermanager.PageID = 1
+
+// scanBatchSize bounds how many pairs a scan collects while holding the tree
+// lock before handing them to the consumer.
+const scanBatchSize = 256
+
+// minFill is the number of used bytes below which a node that lost an entry
+// is merged with a sibling, provided both fit in one page.
+const minFill = pageCapacity / 4
+
+// BytesTree implements the btree.BytesTree interface as a B+Tree of slotted
+// pages managed by a BufferManager. Keys and values are stored inline in the
+// leaves, and leaves are linked in both directions.
+type BytesTree struct {
+	mu      sync.Mutex
+	bm      buffermanager.BufferManager
+	btreeID string
+}
+
+// New opens the BytesTree stored in the pages of btreeID, initializing an
+// empty tree if the BTree has no pages yet.
+func New(bm buffermanager.BufferManager, btreeID string) (*BytesTree, error) {
+	t := &BytesTree{
+		bm:      bm,
+		btreeID: btreeID,
+	}
+	if err := t.commit(t.load()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load checks the meta page, creating it and an empty root leaf when the tree
+// is brand new.
+func (t *BytesTree) load() error {
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinShared)
+	if err == nil {
+		if !meta.isMeta() {
+			t.unpin(handle, false)
+			return fmt.Errorf("%w: page %d is not a meta page", ErrCorruptPage, metaPageID)
+		}
+		return t.unpin(handle, false)
+	}
+	if !errors.Is(err, buffermanager.ErrPageNotFound) {
+		return err
+	}
+
+	metaID, err := t.bm.AllocatePage(t.btreeID)
+	if err != nil {
+		return err
+	}
+	if metaID != metaPageID {
+		return ErrMetaPageMissing
+	}
+	rootID, err := t.newPage(pageTypeLeaf)
+	if err != nil {
+		return err
+	}
+
+	meta, handle, err = t.pin(metaPageID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	meta.initMeta(rootID)
+	return t.unpin(handle, true)
+}
+
+// commit ends a modification that returned err. If the buffer manager is a
+// Committer, the pages a successful modification changed are committed as one
+// unit, and the changes of a failed one are rolled back.
+func (t *BytesTree) commit(err error) error {
+	c, ok := t.bm.(buffermanager.Committer)
+	if !ok {
+		return err
+	}
+	if err != nil {
+		if rollbackErr := c.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return c.Commit()
+}
+
+// pin pins a page of this tree in the given mode.
+func (t *BytesTree) pin(pageID buffermanager.PageID, mode buffermanager.PinMode) (page, buffermanager.PageHandle, error) {
+	handle, err := t.bm.PinPage(t.btreeID, pageID, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	return page(handle.Data()), handle, nil
+}
+
+// unpin releases a page obtained through pin.
+func (t *BytesTree) unpin(handle buffermanager.PageHandle, dirty bool) error {
+	if dirty {
+		handle.MarkDirty()
+	}
+	return handle.Release()
+}
+
+// newPage allocates a page and formats it as an empty node.
+func (t *BytesTree) newPage(pageType byte) (buffermanager.PageID, error) {
+	pageID, err := t.bm.AllocatePage(t.btreeID)
+	if err != nil {
+		return 0, err
+	}
+	p, handle, err := t.pin(pageID, buffermanager.PinExclusive)
+	if err != nil {
+		return 0, err
+	}
+	p.init(pageType)
+	return pageID, t.unpin(handle, true)
+}
+
+// setRoot records a new root in the meta page.
+func (t *BytesTree) setRoot(rootID buffermanager.PageID) error {
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	meta.setRoot(rootID)
+	return t.unpin(handle, true)
+}
+
+// rootID reads the root pointer from the meta page. It is not cached, so a
+// rollback by the buffer manager that restores an earlier root is seen by
+// the next operation.
+func (t *BytesTree) rootID() (buffermanager.PageID, error) {
+	meta, handle, err := t.pin(metaPageID, buffermanager.PinShared)
+	if err != nil {
+		return 0, err
+	}
+	rootID := meta.root()
+	return rootID, t.unpin(handle, false)
+}
+
+// setPrev points the backward link of leaf pageID at prevID.
+func (t *BytesTree) setPrev(pageID, prevID buffermanager.PageID) error {
+	leaf, handle, err := t.pin(pageID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	leaf.setPrev(prevID)
+	return t.unpin(handle, true)
+}
+
+// descend walks from the root to the leaf responsible for key and returns the
+// page IDs along the way, ending with the leaf, together with the index of
+// each page within its parent. No pages remain pinned.
+func (t *BytesTree) descend(key []byte) ([]buffermanager.PageID, []int, error) {
+	rootID, err := t.rootID()
+	if err != nil {
+		return nil, nil, err
+	}
+	path := []buffermanager.PageID{rootID}
+	var indexes []int
+	for {
+		p, handle, err := t.pin(path[len(path)-1], buffermanager.PinShared)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch p.pageType() {
+		case pageTypeLeaf:
+			return path, indexes, t.unpin(handle, false)
+		case pageTypeInternal:
+			i := p.childIndex(key)
+			child := p.child(i)
+			if err := t.unpin(handle, false); err != nil {
+				return nil, nil, err
+			}
+			path = append(path, child)
+			indexes = append(indexes, i)
+		default:
+			t.unpin(handle, false)
+			return nil, nil, fmt.Errorf("%w: page %d has type %d", ErrCorruptPage, path[len(path)-1], p.pageType())
+		}
+	}
+}
+
+// findLeaf returns the leaf responsible for key, pinned.
+func (t *BytesTree) findLeaf(key []byte) (page, buffermanager.PageHandle, error) {
+	path, _, err := t.descend(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.pin(path[len(path)-1], buffermanager.PinShared)
+}
+
+// Lookup finds the value associated with the given key.
+func (t *BytesTree) Lookup(key []byte) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	leaf, handle, err := t.findLeaf(key)
+	if err != nil {
+		return nil, false
+	}
+	defer t.unpin(handle, false)
+
+	i, found := leaf.search(key)
+	if !found {
+		return nil, false
+	}
+	return append([]byte{}, leaf.value(i)...), true
+}
+
+// Insert adds or updates a key-value pair in the tree.
+func (t *BytesTree) Insert(key []byte, value []byte) error {
+	if len(key) > MaxKeySize || len(key)+len(value) > MaxEntrySize {
+		return fmt.Errorf("%w: %d byte key and %d byte value", ErrEntryTooLarge, len(key), len(value))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.commit(t.insert(key, value))
+}
+
+// insert implements Insert for a pair known to fit in a page.
+func (t *BytesTree) insert(key []byte, value []byte) error {
+	path, _, err := t.descend(key)
+	if err != nil {
+		return err
+	}
+	leafID := path[len(path)-1]
+	leaf, handle, err := t.pin(leafID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+
+	i, found := leaf.search(key)
+	if found {
+		leaf.removeCell(i)
+	}
+	cell := leafCell(key, value)
+	if leaf.insertCell(i, cell) {
+		return t.unpin(handle, true)
+	}
+
+	cells := leaf.cells()
+	cells = append(cells[:i], append([][]byte{cell}, cells[i:]...)...)
+	separator, rightID, err := t.splitLeaf(leafID, leaf, cells)
+	if unpinErr := t.unpin(handle, true); err == nil {
+		err = unpinErr
+	}
+	if err != nil {
+		return err
+	}
+	return t.insertIntoParent(path[:len(path)-1], leafID, separator, rightID)
+}
+
+// splitPoint returns the index at which cells are divided so that both halves
+// hold about the same number of bytes. The first half always gets at least
+// one cell and the second at least keep cells.
+func splitPoint(cells [][]byte, keep int) int {
+	total := 0
+	for _, c := range cells {
+		total += len(c) + slotSize
+	}
+	mid, size := 0, 0
+	for mid < len(cells)-keep && (mid == 0 || size < total/2) {
+		size += len(cells[mid]) + slotSize
+		mid++
+	}
+	return mid
+}
+
+// splitLeaf distributes cells, the contents of an overflowing leaf, between
+// the leaf and a new right sibling. It returns the sibling's first key and
+// page ID.
+func (t *BytesTree) splitLeaf(leftID buffermanager.PageID, left page, cells [][]byte) ([]byte, buffermanager.PageID, error) {
+	rightID, err := t.bm.AllocatePage(t.btreeID)
+	if err != nil {
+		return nil, 0, err
+	}
+	right, handle, err := t.pin(rightID, buffermanager.PinExclusive)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mid := splitPoint(cells, 1)
+	left.setCells(cells[:mid])
+	right.init(pageTypeLeaf)
+	right.setCells(cells[mid:])
+
+	nextID := left.next()
+	right.setNext(nextID)
+	right.setPrev(leftID)
+	left.setNext(rightID)
+
+	separator := append([]byte(nil), right.key(0)...)
+	if err := t.unpin(handle, true); err != nil {
+		return nil, 0, err
+	}
+	if nextID != 0 {
+		if err := t.setPrev(nextID, rightID); err != nil {
+			return nil, 0, err
+		}
+	}
+	return separator, rightID, nil
+}
+
+// splitInternal distributes cells, the contents of an overflowing internal
+// node, between the node and a new right sibling. It returns the separator
+// pushed up and the sibling's ID.
+func (t *BytesTree) splitInternal(left page, cells [][]byte) ([]byte, buffermanager.PageID, error) {
+	rightID, err := t.bm.AllocatePage(t.btreeID)
+	if err != nil {
+		return nil, 0, err
+	}
+	right, handle, err := t.pin(rightID, buffermanager.PinExclusive)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The middle cell moves up; its child becomes the sibling's leftmost.
+	mid := splitPoint(cells, 2)
+	separator := append([]byte(nil), cellKey(cells[mid])...)
+	left.setCells(cells[:mid])
+	right.init(pageTypeInternal)
+	right.setChild0(cellChild(cells[mid]))
+	right.setCells(cells[mid+1:])
+
+	return separator, rightID, t.unpin(handle, true)
+}
+
+// insertIntoParent links rightID into the parent of leftID, splitting
+// ancestors as needed. path holds the ancestors of leftID, root first.
+func (t *BytesTree) insertIntoParent(path []buffermanager.PageID, leftID buffermanager.PageID, key []byte, rightID buffermanager.PageID) error {
+	for len(path) > 0 {
+		parentID := path[len(path)-1]
+		path = path[:len(path)-1]
+
+		parent, handle, err := t.pin(parentID, buffermanager.PinExclusive)
+		if err != nil {
+			return err
+		}
+		i := parent.childIndex(key)
+		cell := internalCell(key, rightID)
+		if parent.insertCell(i, cell) {
+			return t.unpin(handle, true)
+		}
+
+		cells := parent.cells()
+		cells = append(cells[:i], append([][]byte{cell}, cells[i:]...)...)
+		separator, newID, err := t.splitInternal(parent, cells)
+		if unpinErr := t.unpin(handle, true); err == nil {
+			err = unpinErr
+		}
+		if err != nil {
+			return err
+		}
+		leftID, key, rightID = parentID, separator, newID
+	}
+	return t.growRoot(leftID, key, rightID)
+}
+
+// growRoot replaces the root with a new internal node over leftID and rightID.
+func (t *BytesTree) growRoot(leftID buffermanager.PageID, key []byte, rightID buffermanager.PageID) error {
+	rootID, err := t.bm.AllocatePage(t.btreeID)
+	if err != nil {
+		return err
+	}
+	root, handle, err := t.pin(rootID, buffermanager.PinExclusive)
+	if err != nil {
+		return err
+	}
+	root.init(pageTypeInternal)
+	root.setChild0(leftID)
+	root.insertCell(0, internalCell(key, rightID))
+	if err := t.unpin(handle, true); err != nil {
+		return err
+	}
+	return t.setRoot(rootID)
+}
+
+// Delete removes key from the tree. A node left less than a quarter full is
+// merged with a sibling when the two fit in one page, and the root shrinks
+// when it is left with a single child.
+func (t *BytesTree) Delete(key []byte) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	found, err := t.delete(key)
+	return found, t.commit(err)
+}
+
+// delete implements Delete.
+func (t *BytesTree) delete(key []byte) (bool, error) {
+	path, indexes, err := t.descend(key)
+	if err != nil {
+		return false, err
+	}
+	leaf, handle, err := t.pin(path[len(path)-1], buffermanager.PinExclusive)
+	if err != nil {
+		return false, err
+	}
+
+	i, found := leaf.search(key)
+	if !found {
+		return false, t.unpin(handle, false)
+	}
+	leaf.removeCell(i)
+	if err := t.unpin(handle, true); err != nil {
+		return true, err
+	}
+
+	for level := len(path) - 1; level > 0; level-- {
+		merged, err := t.mergeUnderfull(path[level-1], indexes[level-1], path[level])
+		if err != nil || !merged {
+			return true, err
+		}
+	}
+	return true, t.collapseRoot()
+}
+
+// mergeUnderfull merges the child at childIndex of parentID with a sibling if
+// the child is less than a quarter full and both fit in one page. It reports
+// whether a merge happened, in which case the parent lost an entry.
+func (t *BytesTree) mergeUnderfull(parentID buffermanager.PageID, childIndex int, childID buffermanager.PageID) (bool, error) {
+	parent, parentHandle, err := t.pin(parentID, buffermanager.PinExclusive)
+	if err != nil {
+		return false, err
+	}
+	child, childHandle, err := t.pin(childID, buffermanager.PinExclusive)
+	if err != nil {
+		t.unpin(parentHandle, false)
+		return false, err
+	}
+	if child.usedSpace() >= minFill || parent.numSlots() == 0 {
+		t.unpin(childHandle, false)
+		return false, t.unpin(parentHandle, false)
+	}
+
+	// Pair the child with its left sibling when it has one, so that the
+	// separator between them is always slot sepIndex of the parent.
+	sepIndex := childIndex - 1
+	if childIndex == 0 {
+		sepIndex = 0
+	}
+	siblingIndex := sepIndex
+	if childIndex == sepIndex {
+		siblingIndex = sepIndex + 1
+	}
+	siblingID := parent.child(siblingIndex)
+	sibling, siblingHandle, err := t.pin(siblingID, buffermanager.PinExclusive)
+	if err != nil {
+		t.unpin(childHandle, false)
+		t.unpin(parentHandle, false)
+		return false, err
+	}
+
+	left, right := sibling, child
+	leftID, rightID := siblingID, childID
+	if siblingIndex > childIndex {
+		left, right = child, sibling
+		leftID, rightID = childID, siblingID
+	}
+
+	// Internal nodes also pull the separator down, pointing at the right
+	// node's leftmost child.
+	cells := left.cells()
+	if !left.isLeaf() {
+		cells = append(cells, internalCell(parent.key(sepIndex), right.child(0)))
+	}
+	cells = append(cells, right.cells()...)
+	size := 0
+	for _, c := range cells {
+		size += len(c) + slotSize
+	}
+	merged := size <= pageCapacity
+
+	var nextID buffermanager.PageID
+	if merged {
+		left.setCells(cells)
+		if left.isLeaf() {
+			nextID = right.next()
+			left.setNext(nextID)
+		}
+		parent.removeCell(sepIndex)
+	}
+
+	err = t.unpin(siblingHandle, merged)
+	if unpinErr := t.unpin(childHandle, merged); err == nil {
+		err = unpinErr
+	}
+	if unpinErr := t.unpin(parentHandle, merged); err == nil {
+		err = unpinErr
+	}
+	if err != nil || !merged {
+		return merged, err
+	}
+	if nextID != 0 {
+		if err := t.setPrev(nextID, leftID); err != nil {
+			return merged, err
+		}
+	}
+	return merged, t.bm.FreePage(t.btreeID, rightID)
+}
+
+// collapseRoot replaces an internal root without keys by its only child,
+// repeatedly if needed.
+func (t *BytesTree) collapseRoot() error {
+	for {
+		oldRoot, err := t.rootID()
+		if err != nil {
+			return err
+		}
+		root, handle, err := t.pin(oldRoot, buffermanager.PinShared)
+		if err != nil {
+			return err
+		}
+		if root.isLeaf() || root.numSlots() > 0 {
+			return t.unpin(handle, false)
+		}
+
+		newRoot := root.child(0)
+		if err := t.unpin(handle, false); err != nil {
+			return err
+		}
+		if err := t.setRoot(newRoot); err != nil {
+			return err
+		}
+		if err := t.bm.FreePage(t.btreeID, oldRoot); err != nil {
+			return err
+		}
+	}
+}
+
+// Scan retrieves all key-value pairs with start <= key < end.
+func (t *BytesTree) Scan(start []byte, end []byte) (<-chan btree.BytesPair, btree.ScanErrFunc, error) {
+	return t.ScanContext(context.Background(), start, end)
+}
+
+// ScanContext retrieves all key-value pairs with start <= key < end until
+// ctx is cancelled. Pairs are gathered in batches under the tree lock and
+// sent without holding any pins.
+func (t *BytesTree) ScanContext(ctx context.Context, start []byte, end []byte) (<-chan btree.BytesPair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	from := append([]byte{}, start...)
+	if end != nil {
+		end = append([]byte{}, end...)
+	}
+	results := make(chan btree.BytesPair)
+	done := make(chan struct{})
+	var scanErr error
+
+	go func() {
+		defer close(done)
+		defer close(results)
+		for {
+			batch, more, err := t.collect(from, end, scanBatchSize)
+			if err != nil {
+				scanErr = err
+				return
+			}
+			for _, pair := range batch {
+				select {
+				case results <- pair:
+				case <-ctx.Done():
+					scanErr = ctx.Err()
+					return
+				}
+			}
+			if !more {
+				return
+			}
+			// The smallest key after the last one sent is that key followed
+			// by a zero byte.
+			last := batch[len(batch)-1].Key
+			from = append(last[:len(last):len(last)], 0)
+		}
+	}()
+
+	return results, func() error {
+		<-done
+		return scanErr
+	}, nil
+}
+
+// collect gathers up to limit pairs with from <= key < end by walking the
+// leaf chain. A nil end is unbounded. more reports whether the range may
+// hold further pairs.
+func (t *BytesTree) collect(from, end []byte, limit int) (batch []btree.BytesPair, more bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	leaf, handle, err := t.findLeaf(from)
+	if err != nil {
+		return nil, false, err
+	}
+	i, _ := leaf.search(from)
+	for {
+		for ; i < leaf.numSlots(); i++ {
+			key := leaf.key(i)
+			if end != nil && bytes.Compare(key, end) >= 0 {
+				return batch, false, t.unpin(handle, false)
+			}
+			if len(batch) == limit {
+				return batch, true, t.unpin(handle, false)
+			}
+			batch = append(batch, btree.BytesPair{
+				Key:   append([]byte{}, key...),
+				Value: append([]byte{}, leaf.value(i)...),
+			})
+		}
+
+		nextID := leaf.next()
+		if err := t.unpin(handle, false); err != nil {
+			return nil, false, err
+		}
+		if nextID == 0 {
+			return batch, false, nil
+		}
+		if leaf, handle, err = t.pin(nextID, buffermanager.PinShared); err != nil {
+			return nil, false, err
+		}
+		i = 0
+	}
+}
@@ -0,0 +1,312 @@
+// btree/bytestree/bytestree_test.go
+package bytestree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// newTestTree creates a tree on a small mock buffer pool, so that leaked pins
+// quickly surface as ErrBufferFull.
+func newTestTree(t *testing.T) (*BytesTree, buffermanager.BufferManager, string) {
+	t.Helper()
+	bm := buffermanager.NewMockBufferManager(buffermanager.WithBufferSize(8))
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := New(bm, btreeID)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return tree, bm, btreeID
+}
+
+// rootOf returns the root page of tree, failing the test on error.
+func rootOf(t *testing.T, tree *BytesTree) buffermanager.PageID {
+	t.Helper()
+	rootID, err := tree.rootID()
+	if err != nil {
+		t.Fatalf("rootID failed: %v", err)
+	}
+	return rootID
+}
+
+// checkTree walks the whole tree and fails the test if any node is out of
+// order or overfull, or if the leaf chain skips a leaf in either direction.
+// It returns the number of keys stored.
+func checkTree(t *testing.T, tree *BytesTree) int {
+	t.Helper()
+	var leaves []buffermanager.PageID
+	var walk func(pageID buffermanager.PageID, lo, hi []byte) int
+	walk = func(pageID buffermanager.PageID, lo, hi []byte) int {
+		data, handle, err := tree.pin(pageID, buffermanager.PinShared)
+		if err != nil {
+			t.Fatalf("pin(%d) failed: %v", pageID, err)
+		}
+		// Work on a copy so the walk never holds more than one pin.
+		p := page(append([]byte(nil), data...))
+		tree.unpin(handle, false)
+
+		if p.usedSpace() > pageCapacity || p.freeSpace() < 0 {
+			t.Errorf("Page %d uses %d bytes, above the capacity %d", pageID, p.usedSpace(), pageCapacity)
+		}
+		count := p.numSlots()
+		for i := 0; i < count; i++ {
+			k := p.key(i)
+			if (lo != nil && bytes.Compare(k, lo) < 0) || (hi != nil && bytes.Compare(k, hi) >= 0) ||
+				(i > 0 && bytes.Compare(k, p.key(i-1)) <= 0) {
+				t.Errorf("Page %d has key %q out of order or outside [%q, %q)", pageID, k, lo, hi)
+			}
+		}
+		if p.isLeaf() {
+			leaves = append(leaves, pageID)
+			return count
+		}
+
+		total := 0
+		for i := 0; i <= count; i++ {
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = append([]byte(nil), p.key(i-1)...)
+			}
+			if i < count {
+				childHi = append([]byte(nil), p.key(i)...)
+			}
+			total += walk(p.child(i), childLo, childHi)
+		}
+		return total
+	}
+	total := walk(rootOf(t, tree), nil, nil)
+
+	for i, pageID := range leaves {
+		p, handle, err := tree.pin(pageID, buffermanager.PinShared)
+		if err != nil {
+			t.Fatalf("pin(%d) failed: %v", pageID, err)
+		}
+		next, prev := buffermanager.PageID(0), buffermanager.PageID(0)
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if p.next() != next || p.prev() != prev {
+			t.Errorf("Leaf %d links to (%d, %d), expected (%d, %d)", pageID, p.prev(), p.next(), prev, next)
+		}
+		tree.unpin(handle, false)
+	}
+	return total
+}
+
+func collectScan(t *testing.T, tree btree.BytesTree, start, end []byte) []btree.BytesPair {
+	t.Helper()
+	results, scanErr, err := tree.Scan(start, end)
+	if err != nil {
+		t.Fatalf("Scan returned unexpected error: %v", err)
+	}
+	var collected []btree.BytesPair
+	for r := range results {
+		collected = append(collected, r)
+	}
+	if err := scanErr(); err != nil {
+		t.Fatalf("Scan reported unexpected error: %v", err)
+	}
+	return collected
+}
+
+func randomBytes(rng *rand.Rand, maxLen int) []byte {
+	b := make([]byte, rng.Intn(maxLen+1))
+	for i := range b {
+		// A small alphabet makes shared prefixes and equal keys common.
+		b[i] = byte('a' + rng.Intn(4))
+	}
+	return b
+}
+
+func TestBytesTree_MatchesModel(t *testing.T) {
+	tree, _, _ := newTestTree(t)
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[string][]byte)
+
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 500; i++ {
+			key := randomBytes(rng, 12)
+			if rng.Intn(4) == 0 {
+				found, err := tree.Delete(key)
+				if err != nil {
+					t.Fatalf("Delete(%q) failed: %v", key, err)
+				}
+				if _, expected := model[string(key)]; found != expected {
+					t.Fatalf("Delete(%q) reported %v, expected %v", key, found, expected)
+				}
+				delete(model, string(key))
+				continue
+			}
+			value := randomBytes(rng, 300)
+			if err := tree.Insert(key, value); err != nil {
+				t.Fatalf("Insert(%q) failed: %v", key, err)
+			}
+			model[string(key)] = value
+		}
+
+		if n := checkTree(t, tree); n != len(model) {
+			t.Fatalf("Expected %d keys in tree, got %d", len(model), n)
+		}
+		for k, v := range model {
+			if value, found := tree.Lookup([]byte(k)); !found || !bytes.Equal(value, v) {
+				t.Fatalf("Lookup(%q) = (%q, %v), expected (%q, true)", k, value, found, v)
+			}
+		}
+	}
+
+	var keys []string
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	results := collectScan(t, tree, nil, nil)
+	if len(results) != len(keys) {
+		t.Fatalf("Expected %d scan results, got %d", len(keys), len(results))
+	}
+	for i, r := range results {
+		if string(r.Key) != keys[i] || !bytes.Equal(r.Value, model[keys[i]]) {
+			t.Fatalf("Scan result %d is %q, expected %q", i, r.Key, keys[i])
+		}
+	}
+}
+
+func TestBytesTree_Scan(t *testing.T) {
+	tree, _, _ := newTestTree(t)
+	// Enough pairs to fill several leaves and more than one scan batch.
+	for i := 0; i < 2000; i++ {
+		key := []byte(fmt.Sprintf("user/%04d", i))
+		if err := tree.Insert(key, bytes.Repeat([]byte{'v'}, 20)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	tree.Insert([]byte("user"), []byte("prefix itself"))
+	tree.Insert([]byte("zzz"), []byte("last"))
+
+	tests := []struct {
+		name       string
+		start, end []byte
+		expected   int
+	}{
+		{"Everything", nil, nil, 2002},
+		{"HalfOpen", []byte("user/0100"), []byte("user/0200"), 100},
+		{"Prefix", []byte("user/"), []byte("user0"), 2000},
+		{"StartBetweenKeys", []byte("user/0999x"), []byte("user/1002"), 2},
+		{"EmptyEnd", nil, []byte{}, 0},
+		{"EndBeforeStart", []byte("b"), []byte("a"), 0},
+		{"Unbounded", []byte("user/1990"), nil, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := collectScan(t, tree, tt.start, tt.end)
+			if len(results) != tt.expected {
+				t.Errorf("Expected %d results, got %d", tt.expected, len(results))
+			}
+			for i := 1; i < len(results); i++ {
+				if bytes.Compare(results[i-1].Key, results[i].Key) >= 0 {
+					t.Fatalf("Results out of order: %q before %q", results[i-1].Key, results[i].Key)
+				}
+			}
+		})
+	}
+}
+
+func TestBytesTree_LargeEntries(t *testing.T) {
+	tree, _, _ := newTestTree(t)
+
+	if err := tree.Insert(make([]byte, MaxKeySize+1), nil); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("Expected ErrEntryTooLarge for an oversized key, got: %v", err)
+	}
+	if err := tree.Insert([]byte("k"), make([]byte, MaxEntrySize)); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("Expected ErrEntryTooLarge for an oversized value, got: %v", err)
+	}
+
+	// Entries of the maximum size, with long keys that also fill the
+	// internal nodes.
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 300; i++ {
+		key := append([]byte(fmt.Sprintf("%06d", rng.Intn(1000000))), make([]byte, MaxKeySize-6)...)
+		if err := tree.Insert(key, make([]byte, MaxEntrySize-len(key))); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	checkTree(t, tree)
+
+	// Shrinking a value in place and growing it again.
+	key := []byte("grow")
+	for _, size := range []int{0, MaxEntrySize - len(key), 10, MaxEntrySize - len(key)} {
+		value := bytes.Repeat([]byte{byte(size)}, size)
+		if err := tree.Insert(key, value); err != nil {
+			t.Fatalf("Insert of %d bytes failed: %v", size, err)
+		}
+		if got, found := tree.Lookup(key); !found || !bytes.Equal(got, value) {
+			t.Fatalf("Expected %d byte value, got %d bytes (found=%v)", size, len(got), found)
+		}
+	}
+	checkTree(t, tree)
+}
+
+func TestBytesTree_DeleteShrinks(t *testing.T) {
+	tree, _, _ := newTestTree(t)
+	var keys [][]byte
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key-%05d", i))
+		keys = append(keys, key)
+		if err := tree.Insert(key, make([]byte, 100)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	rand.New(rand.NewSource(3)).Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	for i, key := range keys {
+		if found, err := tree.Delete(key); err != nil || !found {
+			t.Fatalf("Delete(%q) = (%v, %v), expected (true, nil)", key, found, err)
+		}
+		if i%500 == 0 {
+			checkTree(t, tree)
+		}
+	}
+
+	if n := checkTree(t, tree); n != 0 {
+		t.Errorf("Expected empty tree, found %d keys", n)
+	}
+	root, handle, err := tree.pin(rootOf(t, tree), buffermanager.PinShared)
+	if err != nil {
+		t.Fatalf("pin failed: %v", err)
+	}
+	defer tree.unpin(handle, false)
+	if !root.isLeaf() {
+		t.Error("Expected the root to collapse back into a single leaf")
+	}
+}
+
+func TestBytesTree_Reopen(t *testing.T) {
+	tree, bm, btreeID := newTestTree(t)
+	for i := 0; i < 500; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("value %d", i))); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	reopened, err := New(bm, btreeID)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if value, found := reopened.Lookup([]byte("123")); !found || string(value) != "value 123" {
+		t.Errorf("Expected (\"value 123\", true), got (%q, %v)", value, found)
+	}
+	if n := checkTree(t, reopened); n != 500 {
+		t.Errorf("Expected 500 keys after reopening, got %d", n)
+	}
+}
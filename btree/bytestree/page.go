@@ -0,0 +1,308 @@
+// btree/bytestree/page.go
+package bytestree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Page types stored in the first byte of every page owned by a BytesTree.
+const (
+	pageTypeMeta byte = iota + 1
+	pageTypeLeaf
+	pageTypeInternal
+)
+
+// Slotted page layout shared by leaf and internal nodes:
+//
+//	[0]      page type
+//	[2:4]    number of slots
+//	[4:6]    offset of the lowest cell
+//	[6:8]    bytes lost to removed cells
+//	[8:16]   next leaf PageID (leaves), leftmost child PageID (internal)
+//	[16:24]  previous leaf PageID (leaves only)
+//	[24:]    slot array of 2-byte cell offsets, in key order
+//
+// Cells are packed from the end of the page towards the slot array. A leaf
+// cell holds a key and a value; an internal cell holds a key and the child to
+// its right, the leftmost child living in the header:
+//
+//	leaf cell:     [key length: 2][value length: 2][key][value]
+//	internal cell: [key length: 2][child: 8][key]
+//
+// Removing a cell only drops its slot. The space it occupied is reclaimed by
+// compacting the page once a new cell no longer fits between the slot array
+// and the cells.
+const (
+	offsetType       = 0
+	offsetNumSlots   = 2
+	offsetCellStart  = 4
+	offsetFragmented = 6
+	offsetNext       = 8
+	offsetChild0     = 8
+	offsetPrev       = 16
+	headerSize       = 24
+	slotSize         = 2
+
+	leafCellHeader     = 4
+	internalCellHeader = 10
+
+	// pageCapacity is the room available for slots and cells.
+	pageCapacity = buffermanager.PageSize - headerSize
+
+	// maxCellSize bounds a single cell so that any node holding at least
+	// two cells can be split into two halves that each fit in a page.
+	maxCellSize = pageCapacity/4 - slotSize
+)
+
+// Meta page layout. The meta page is the first page of a tree and records
+// where the root currently lives.
+const (
+	offsetMagic = 8
+	offsetRoot  = 16
+
+	metaMagic uint64 = 0x6279746573747265 // "bytestre"
+)
+
+// page is a view over a buffer page that interprets it as a slotted node.
+type page []byte
+
+func (p page) pageType() byte {
+	return p[offsetType]
+}
+
+func (p page) isLeaf() bool {
+	return p[offsetType] == pageTypeLeaf
+}
+
+func (p page) numSlots() int {
+	return int(binary.LittleEndian.Uint16(p[offsetNumSlots:]))
+}
+
+func (p page) setNumSlots(count int) {
+	binary.LittleEndian.PutUint16(p[offsetNumSlots:], uint16(count))
+}
+
+func (p page) cellStart() int {
+	return int(binary.LittleEndian.Uint16(p[offsetCellStart:]))
+}
+
+func (p page) setCellStart(off int) {
+	binary.LittleEndian.PutUint16(p[offsetCellStart:], uint16(off))
+}
+
+func (p page) fragmented() int {
+	return int(binary.LittleEndian.Uint16(p[offsetFragmented:]))
+}
+
+func (p page) setFragmented(size int) {
+	binary.LittleEndian.PutUint16(p[offsetFragmented:], uint16(size))
+}
+
+// init formats the page as an empty node of the given type.
+func (p page) init(pageType byte) {
+	for i := 0; i < headerSize; i++ {
+		p[i] = 0
+	}
+	p[offsetType] = pageType
+	p.setCellStart(len(p))
+}
+
+func (p page) next() buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(p[offsetNext:]))
+}
+
+func (p page) setNext(id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(p[offsetNext:], uint64(id))
+}
+
+func (p page) prev() buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(p[offsetPrev:]))
+}
+
+func (p page) setPrev(id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(p[offsetPrev:], uint64(id))
+}
+
+// --- Slots and cells ---
+
+func slotOffset(i int) int {
+	return headerSize + i*slotSize
+}
+
+// cell returns the bytes of the cell referenced by slot i.
+func (p page) cell(i int) []byte {
+	off := int(binary.LittleEndian.Uint16(p[slotOffset(i):]))
+	keyLen := int(binary.LittleEndian.Uint16(p[off:]))
+	if p.isLeaf() {
+		valueLen := int(binary.LittleEndian.Uint16(p[off+2:]))
+		return p[off : off+leafCellHeader+keyLen+valueLen]
+	}
+	return p[off : off+internalCellHeader+keyLen]
+}
+
+// key returns the key of slot i. The slice aliases the page.
+func (p page) key(i int) []byte {
+	c := p.cell(i)
+	if p.isLeaf() {
+		return c[leafCellHeader : leafCellHeader+int(binary.LittleEndian.Uint16(c))]
+	}
+	return c[internalCellHeader:]
+}
+
+// value returns the value of leaf slot i. The slice aliases the page.
+func (p page) value(i int) []byte {
+	c := p.cell(i)
+	return c[leafCellHeader+int(binary.LittleEndian.Uint16(c)):]
+}
+
+// child returns child i of an internal node. Child 0 lives in the header and
+// child i+1 in the cell of slot i.
+func (p page) child(i int) buffermanager.PageID {
+	if i == 0 {
+		return buffermanager.PageID(binary.LittleEndian.Uint64(p[offsetChild0:]))
+	}
+	return buffermanager.PageID(binary.LittleEndian.Uint64(p.cell(i - 1)[2:]))
+}
+
+func (p page) setChild0(id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(p[offsetChild0:], uint64(id))
+}
+
+// freeSpace returns the contiguous room between the slot array and the cells.
+func (p page) freeSpace() int {
+	return p.cellStart() - slotOffset(p.numSlots())
+}
+
+// usedSpace returns the bytes taken by live slots and cells.
+func (p page) usedSpace() int {
+	return pageCapacity - p.freeSpace() - p.fragmented()
+}
+
+// insertCell stores cell at slot i, shifting later slots right and compacting
+// the page first if needed. It reports false, leaving the page untouched, if
+// the cell does not fit.
+func (p page) insertCell(i int, cell []byte) bool {
+	need := len(cell) + slotSize
+	if p.freeSpace() < need {
+		if p.freeSpace()+p.fragmented() < need {
+			return false
+		}
+		p.compact()
+	}
+	count := p.numSlots()
+	off := p.cellStart() - len(cell)
+	copy(p[off:], cell)
+	copy(p[slotOffset(i+1):slotOffset(count+1)], p[slotOffset(i):slotOffset(count)])
+	binary.LittleEndian.PutUint16(p[slotOffset(i):], uint16(off))
+	p.setCellStart(off)
+	p.setNumSlots(count + 1)
+	return true
+}
+
+// removeCell drops slot i, shifting later slots left.
+func (p page) removeCell(i int) {
+	count := p.numSlots()
+	size := len(p.cell(i))
+	copy(p[slotOffset(i):], p[slotOffset(i+1):slotOffset(count)])
+	p.setNumSlots(count - 1)
+	p.setFragmented(p.fragmented() + size)
+}
+
+// cells returns copies of all cells in slot order.
+func (p page) cells() [][]byte {
+	count := p.numSlots()
+	cells := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		cells[i] = append([]byte(nil), p.cell(i)...)
+	}
+	return cells
+}
+
+// setCells replaces the node's contents with cells, packed without gaps. The
+// header fields other than the slot bookkeeping are preserved.
+func (p page) setCells(cells [][]byte) {
+	off := len(p)
+	for i, c := range cells {
+		off -= len(c)
+		copy(p[off:], c)
+		binary.LittleEndian.PutUint16(p[slotOffset(i):], uint16(off))
+	}
+	p.setNumSlots(len(cells))
+	p.setCellStart(off)
+	p.setFragmented(0)
+}
+
+// compact rewrites the cells contiguously, reclaiming the space of removed
+// cells.
+func (p page) compact() {
+	p.setCells(p.cells())
+}
+
+// search returns the index of the first slot whose key is >= key and whether
+// that key equals key.
+func (p page) search(key []byte) (int, bool) {
+	count := p.numSlots()
+	i := sort.Search(count, func(i int) bool { return bytes.Compare(p.key(i), key) >= 0 })
+	return i, i < count && bytes.Equal(p.key(i), key)
+}
+
+// childIndex returns the index of the child whose subtree may contain key.
+func (p page) childIndex(key []byte) int {
+	return sort.Search(p.numSlots(), func(i int) bool { return bytes.Compare(key, p.key(i)) < 0 })
+}
+
+// leafCell encodes a key-value pair as a leaf cell.
+func leafCell(key, value []byte) []byte {
+	c := make([]byte, leafCellHeader+len(key)+len(value))
+	binary.LittleEndian.PutUint16(c, uint16(len(key)))
+	binary.LittleEndian.PutUint16(c[2:], uint16(len(value)))
+	copy(c[leafCellHeader:], key)
+	copy(c[leafCellHeader+len(key):], value)
+	return c
+}
+
+// internalCell encodes a separator key and the child to its right.
+func internalCell(key []byte, child buffermanager.PageID) []byte {
+	c := make([]byte, internalCellHeader+len(key))
+	binary.LittleEndian.PutUint16(c, uint16(len(key)))
+	binary.LittleEndian.PutUint64(c[2:], uint64(child))
+	copy(c[internalCellHeader:], key)
+	return c
+}
+
+// cellKey returns the key stored in an internal cell.
+func cellKey(c []byte) []byte {
+	return c[internalCellHeader:]
+}
+
+// cellChild returns the child stored in an internal cell.
+func cellChild(c []byte) buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(c[2:]))
+}
+
+// --- Meta accessors ---
+
+func (p page) initMeta(root buffermanager.PageID) {
+	for i := range p {
+		p[i] = 0
+	}
+	p[offsetType] = pageTypeMeta
+	binary.LittleEndian.PutUint64(p[offsetMagic:], metaMagic)
+	p.setRoot(root)
+}
+
+func (p page) isMeta() bool {
+	return p[offsetType] == pageTypeMeta && binary.LittleEndian.Uint64(p[offsetMagic:]) == metaMagic
+}
+
+func (p page) root() buffermanager.PageID {
+	return buffermanager.PageID(binary.LittleEndian.Uint64(p[offsetRoot:]))
+}
+
+func (p page) setRoot(id buffermanager.PageID) {
+	binary.LittleEndian.PutUint64(p[offsetRoot:], uint64(id))
+}
@@ -0,0 +1,121 @@
+// btree/inmemory/bytes.go
+package inmemory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// InMemoryBytesTree implements the BytesTree interface with an in-memory map.
+//
+// InMemoryBytesTree is safe for concurrent use by multiple goroutines, with
+// the same guarantees as InMemoryBTree: a scan sees the tree as it was at a
+// single moment during the call.
+type InMemoryBytesTree struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+// NewInMemoryBytesTree creates a new instance of the in-memory BytesTree.
+func NewInMemoryBytesTree() *InMemoryBytesTree {
+	return &InMemoryBytesTree{
+		data: make(map[string][]byte),
+	}
+}
+
+// Pairs returns a copy of every key-value pair in the tree. It replaces the
+// Data field, which exposed the map without locking.
+func (m *InMemoryBytesTree) Pairs() map[string][]byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pairs := make(map[string][]byte, len(m.data))
+	for k, v := range m.data {
+		pairs[k] = append([]byte{}, v...)
+	}
+	return pairs
+}
+
+// Lookup finds the value associated with the given key.
+func (m *InMemoryBytesTree) Lookup(key []byte) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, found := m.data[string(key)]
+	if !found {
+		return nil, false
+	}
+	return append([]byte{}, value...), true
+}
+
+// Insert adds or updates a key-value pair in the tree.
+func (m *InMemoryBytesTree) Insert(key []byte, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete removes a key-value pair from the tree.
+func (m *InMemoryBytesTree) Delete(key []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, found := m.data[string(key)]
+	delete(m.data, string(key))
+	return found, nil
+}
+
+// pairs returns the pairs with start <= key < end in ascending key order.
+// Keys and values are copies, so the caller may use them without the lock.
+func (m *InMemoryBytesTree) pairs(start []byte, end []byte) []btree.BytesPair {
+	m.mu.RLock()
+	var pairs []btree.BytesPair
+	for k, v := range m.data {
+		if bytes.Compare([]byte(k), start) >= 0 && (end == nil || bytes.Compare([]byte(k), end) < 0) {
+			pairs = append(pairs, btree.BytesPair{Key: []byte(k), Value: append([]byte{}, v...)})
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].Key, pairs[j].Key) < 0 })
+	return pairs
+}
+
+// Scan retrieves all key-value pairs with start <= key < end.
+func (m *InMemoryBytesTree) Scan(start []byte, end []byte) (<-chan btree.BytesPair, btree.ScanErrFunc, error) {
+	return m.ScanContext(context.Background(), start, end)
+}
+
+// ScanContext retrieves all key-value pairs with start <= key < end until ctx
+// is cancelled.
+func (m *InMemoryBytesTree) ScanContext(ctx context.Context, start []byte, end []byte) (<-chan btree.BytesPair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	// The pairs are copied before the scan returns, so the goroutine below
+	// touches neither the map nor the caller's start and end.
+	pairs := m.pairs(start, end)
+	results := make(chan btree.BytesPair)
+	done := make(chan struct{})
+	var scanErr error
+
+	go func() {
+		defer close(done)
+		defer close(results)
+
+		for _, pair := range pairs {
+			select {
+			case results <- pair:
+			case <-ctx.Done():
+				scanErr = ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return results, func() error {
+		<-done
+		return scanErr
+	}, nil
+}
@@ -2,54 +2,255 @@
 package inmemory
 
 import (
-	"github.com/pillairaunak/btree-store-go/btree" // Import the btree interface
+	"context"
+	"math"
 	"sort"
+	"sync"
+
+	"github.com/pillairaunak/btree-store-go/btree" // Import the btree interface
 )
 
 // InMemoryBTree implements the BTree interface with an in-memory map.
+//
+// InMemoryBTree is safe for concurrent use by multiple goroutines. Each
+// method call is atomic: Lookup sees every write that completed before it
+// started, and a scan or cursor positioning call sees the tree as it was at
+// a single moment during the call, unaffected by writes made while the
+// results are consumed.
 type InMemoryBTree struct {
-	Data map[uint64]uint64
+	mu   sync.RWMutex
+	data map[uint64]uint64
 }
 
 // NewInMemoryBTree creates a new instance of the in-memory BTree.
 func NewInMemoryBTree() *InMemoryBTree {
 	return &InMemoryBTree{
-		Data: make(map[uint64]uint64),
+		data: make(map[uint64]uint64),
+	}
+}
+
+// Pairs returns a copy of every key-value pair in the tree. It replaces the
+// Data field, which exposed the map without locking.
+func (m *InMemoryBTree) Pairs() map[uint64]uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pairs := make(map[uint64]uint64, len(m.data))
+	for k, v := range m.data {
+		pairs[k] = v
 	}
+	return pairs
 }
 
 // Lookup finds the value associated with the given key.
 func (m *InMemoryBTree) Lookup(key uint64) (uint64, bool) {
-	value, found := m.Data[key]
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, found := m.data[key]
 	return value, found
 }
 
 // Insert adds or updates a key-value pair in the tree.
 func (m *InMemoryBTree) Insert(key uint64, value uint64) error {
-	m.Data[key] = value
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
 	return nil
 }
 
+// Delete removes a key-value pair from the tree.
+func (m *InMemoryBTree) Delete(key uint64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, found := m.data[key]
+	delete(m.data, key)
+	return found, nil
+}
+
+// DeleteRange removes all key-value pairs within the given range.
+func (m *InMemoryBTree) DeleteRange(minKey uint64, maxKey uint64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for k := range m.data {
+		if k >= minKey && k <= maxKey {
+			delete(m.data, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// pairs returns the pairs within the given range in ascending key order, or
+// descending if reverse is set.
+func (m *InMemoryBTree) pairs(minKey uint64, maxKey uint64, reverse bool) []btree.KeyValuePair {
+	m.mu.RLock()
+	var pairs []btree.KeyValuePair
+	for k, v := range m.data {
+		if k >= minKey && k <= maxKey {
+			pairs = append(pairs, btree.KeyValuePair{Key: k, Value: v})
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(pairs, func(i, j int) bool { return (pairs[i].Key < pairs[j].Key) != reverse })
+	return pairs
+}
+
 // Scan retrieves all key-value pairs within the given range.
-func (m *InMemoryBTree) Scan(minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, error) {
+func (m *InMemoryBTree) Scan(minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return m.ScanContext(context.Background(), minKey, maxKey)
+}
+
+// ScanContext retrieves all key-value pairs within the given range until ctx
+// is cancelled.
+func (m *InMemoryBTree) ScanContext(ctx context.Context, minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return m.scan(ctx, minKey, maxKey, false)
+}
+
+// ReverseScan retrieves all key-value pairs within the given range in
+// descending key order.
+func (m *InMemoryBTree) ReverseScan(maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return m.ReverseScanContext(context.Background(), maxKey, minKey)
+}
+
+// ReverseScanContext retrieves all key-value pairs within the given range in
+// descending key order until ctx is cancelled.
+func (m *InMemoryBTree) ReverseScanContext(ctx context.Context, maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return m.scan(ctx, minKey, maxKey, true)
+}
+
+// scan streams the pairs within the given range in ascending key order, or
+// descending if reverse is set.
+func (m *InMemoryBTree) scan(ctx context.Context, minKey uint64, maxKey uint64, reverse bool) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	// The pairs are copied before the scan returns, so the goroutine below
+	// never touches the map.
+	pairs := m.pairs(minKey, maxKey, reverse)
 	results := make(chan btree.KeyValuePair)
+	done := make(chan struct{})
+	var scanErr error
 
 	go func() {
+		defer close(done)
 		defer close(results)
 
-		// Create a slice of keys for sorting
-		var keys []uint64
-		for k := range m.Data {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
-
-		for _, k := range keys {
-			if k >= minKey && k <= maxKey {
-				results <- btree.KeyValuePair{Key: k, Value: m.Data[k]}
+		for _, kv := range pairs {
+			select {
+			case results <- kv:
+			case <-ctx.Done():
+				scanErr = ctx.Err()
+				return
 			}
 		}
 	}()
 
-	return results, nil
+	return results, func() error {
+		<-done
+		return scanErr
+	}, nil
+}
+
+// Cursor returns a cursor over the tree. Each call to First, Last or Seek
+// takes a sorted snapshot of the keys, so Next and Prev do not observe
+// modifications made after the cursor was last positioned. The cursor itself
+// must be used by one goroutine at a time.
+func (m *InMemoryBTree) Cursor() (btree.Cursor, error) {
+	return &cursor{tree: m, pos: -1}, nil
+}
+
+// cursor implements btree.Cursor over a sorted snapshot of an InMemoryBTree.
+type cursor struct {
+	tree   *InMemoryBTree
+	pairs  []btree.KeyValuePair
+	pos    int
+	closed bool
+}
+
+// snapshot collects the tree's pairs in ascending key order.
+func (c *cursor) snapshot() {
+	c.pairs = c.tree.pairs(0, math.MaxUint64, false)
+}
+
+// moveTo positions the cursor at index i of the snapshot, invalidating it if
+// i is out of range.
+func (c *cursor) moveTo(i int) bool {
+	if c.closed || i < 0 || i >= len(c.pairs) {
+		c.pos = -1
+		return false
+	}
+	c.pos = i
+	return true
+}
+
+func (c *cursor) valid() bool {
+	return !c.closed && c.pos >= 0
+}
+
+// Seek positions the cursor at the first pair whose key is >= key.
+func (c *cursor) Seek(key uint64) bool {
+	if c.closed {
+		return false
+	}
+	c.snapshot()
+	return c.moveTo(sort.Search(len(c.pairs), func(i int) bool { return c.pairs[i].Key >= key }))
+}
+
+// First positions the cursor at the pair with the smallest key.
+func (c *cursor) First() bool {
+	return c.Seek(0)
+}
+
+// Last positions the cursor at the pair with the largest key.
+func (c *cursor) Last() bool {
+	if c.closed {
+		return false
+	}
+	c.snapshot()
+	return c.moveTo(len(c.pairs) - 1)
+}
+
+// Next moves the cursor to the pair with the next larger key.
+func (c *cursor) Next() bool {
+	if !c.valid() {
+		return false
+	}
+	return c.moveTo(c.pos + 1)
+}
+
+// Prev moves the cursor to the pair with the next smaller key.
+func (c *cursor) Prev() bool {
+	if !c.valid() {
+		return false
+	}
+	return c.moveTo(c.pos - 1)
+}
+
+// Key returns the key of the current pair.
+func (c *cursor) Key() uint64 {
+	if !c.valid() {
+		return 0
+	}
+	return c.pairs[c.pos].Key
+}
+
+// Value returns the value of the current pair.
+func (c *cursor) Value() uint64 {
+	if !c.valid() {
+		return 0
+	}
+	return c.pairs[c.pos].Value
+}
+
+// Err always returns nil; an in-memory cursor cannot fail.
+func (c *cursor) Err() error {
+	return nil
+}
+
+// Close releases the snapshot.
+func (c *cursor) Close() error {
+	c.closed = true
+	c.pairs = nil
+	c.pos = -1
+	return nil
 }
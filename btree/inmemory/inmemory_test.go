@@ -2,8 +2,11 @@
 package inmemory
 
 import (
+	"bytes"
+	"math"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 
 	"github.com/pillairaunak/btree-store-go/btree"
@@ -89,7 +92,7 @@ func TestInMemoryBTree_Scan(t *testing.T) {
 	}
 
 	// Helper function to convert channel results to a slice
-	collectResults := func(results <-chan btree.KeyValuePair, err error) ([]btree.KeyValuePair, error) {
+	collectResults := func(results <-chan btree.KeyValuePair, scanErr btree.ScanErrFunc, err error) ([]btree.KeyValuePair, error) {
 		if err != nil {
 			return nil, err
 		}
@@ -97,13 +100,13 @@ func TestInMemoryBTree_Scan(t *testing.T) {
 		for r := range results {
 			collected = append(collected, r)
 		}
-		return collected, nil
+		return collected, scanErr()
 	}
 
 	// --- Test Cases ---
 	t.Run("ScanEntireRange", func(t *testing.T) {
-		resultsChan, err := tree.Scan(0, 100)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(0, 100)
+		results, err := collectResults(resultsChan, scanErr, err)
 
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
@@ -114,8 +117,8 @@ func TestInMemoryBTree_Scan(t *testing.T) {
 	})
 
 	t.Run("ScanPartialRange", func(t *testing.T) {
-		resultsChan, err := tree.Scan(20, 40)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(20, 40)
+		results, err := collectResults(resultsChan, scanErr, err)
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
 		}
@@ -131,8 +134,8 @@ func TestInMemoryBTree_Scan(t *testing.T) {
 	})
 
 	t.Run("ScanEmptyRange", func(t *testing.T) {
-		resultsChan, err := tree.Scan(60, 70)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(60, 70)
+		results, err := collectResults(resultsChan, scanErr, err)
 
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
@@ -143,8 +146,8 @@ func TestInMemoryBTree_Scan(t *testing.T) {
 	})
 
 	t.Run("MinKeyGreaterThanMaxKey", func(t *testing.T) {
-		resultsChan, err := tree.Scan(70, 60)
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(70, 60)
+		results, err := collectResults(resultsChan, scanErr, err)
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
 		}
@@ -154,8 +157,8 @@ func TestInMemoryBTree_Scan(t *testing.T) {
 	})
 
 	t.Run("ScanWithBoundaries", func(t *testing.T) {
-		resultsChan, err := tree.Scan(10, 50) // Exact boundaries
-		results, err := collectResults(resultsChan, err)
+		resultsChan, scanErr, err := tree.Scan(10, 50) // Exact boundaries
+		results, err := collectResults(resultsChan, scanErr, err)
 		if err != nil {
 			t.Errorf("Scan returned unexpected error: %v", err)
 		}
@@ -175,3 +178,204 @@ func TestInMemoryBTree_Scan(t *testing.T) {
 		}
 	})
 }
+
+func TestInMemoryBTree_Pairs(t *testing.T) {
+	tree := NewInMemoryBTree()
+	tree.Insert(1, 10)
+	tree.Insert(2, 20)
+
+	pairs := tree.Pairs()
+	if !reflect.DeepEqual(pairs, map[uint64]uint64{1: 10, 2: 20}) {
+		t.Errorf("Expected both pairs, got %v", pairs)
+	}
+	pairs[3] = 30
+	if _, found := tree.Lookup(3); found {
+		t.Error("Expected Pairs to return a copy")
+	}
+}
+
+func TestInMemoryBTree_Delete(t *testing.T) {
+	tree := NewInMemoryBTree()
+	if err := tree.Insert(1, 100); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	t.Run("DeleteExistingKey", func(t *testing.T) {
+		found, err := tree.Delete(1)
+		if err != nil || !found {
+			t.Errorf("Expected (true, nil), got (%v, %v)", found, err)
+		}
+		if _, found := tree.Lookup(1); found {
+			t.Errorf("Expected key 1 to be deleted, but it was found")
+		}
+	})
+
+	t.Run("DeleteNonExistentKey", func(t *testing.T) {
+		found, err := tree.Delete(1)
+		if err != nil || found {
+			t.Errorf("Expected (false, nil), got (%v, %v)", found, err)
+		}
+	})
+}
+
+// TestInMemoryBTree_Concurrent runs lookups, scans and cursors alongside
+// writers. Run it with -race to check the locking.
+func TestInMemoryBTree_Concurrent(t *testing.T) {
+	tree := NewInMemoryBTree()
+	const keys = 2000
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// One writer inserts keys in ascending order, so every consistent view
+	// holds a prefix of them; another churns keys outside that range.
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(stop)
+		for k := uint64(0); k < keys; k++ {
+			if err := tree.Insert(k, k*2); err != nil {
+				t.Errorf("Insert failed: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for k := uint64(keys); ; k++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tree.Insert(k, k)
+			tree.Delete(k - 1)
+			if k%100 == 0 {
+				tree.DeleteRange(keys, k)
+			}
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				results, scanErr, err := tree.Scan(0, keys-1)
+				if err != nil {
+					t.Errorf("Scan failed: %v", err)
+					return
+				}
+				var n uint64
+				for p := range results {
+					if p.Key != n || p.Value != n*2 {
+						t.Errorf("Expected (%d, %d) in a consistent scan, got (%d, %d)", n, n*2, p.Key, p.Value)
+					}
+					n++
+				}
+				if err := scanErr(); err != nil {
+					t.Errorf("Scan failed: %v", err)
+				}
+				if n > 0 {
+					if value, found := tree.Lookup(n - 1); !found || value != (n-1)*2 {
+						t.Errorf("Expected key %d to stay inserted, got (%d, %v)", n-1, value, found)
+					}
+				}
+
+				c, _ := tree.Cursor()
+				if r%2 == 0 {
+					for ok := c.Last(); ok && c.Key() >= keys; ok = c.Prev() {
+					}
+				} else {
+					c.Seek(math.MaxUint64 / 2)
+				}
+				c.Close()
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	if value, found := tree.Lookup(keys - 1); !found || value != (keys-1)*2 {
+		t.Errorf("Expected (%d, true) for the last key, got (%d, %v)", (keys-1)*2, value, found)
+	}
+}
+
+func TestInMemoryBytesTree_Pairs(t *testing.T) {
+	tree := NewInMemoryBytesTree()
+	tree.Insert([]byte("a"), []byte("1"))
+
+	pairs := tree.Pairs()
+	if !reflect.DeepEqual(pairs, map[string][]byte{"a": []byte("1")}) {
+		t.Errorf("Expected the pair, got %v", pairs)
+	}
+	pairs["a"][0] = '2'
+	pairs["b"] = []byte("3")
+	if value, _ := tree.Lookup([]byte("a")); string(value) != "1" {
+		t.Errorf("Expected Pairs to return a copy, got value %q", value)
+	}
+	if _, found := tree.Lookup([]byte("b")); found {
+		t.Error("Expected Pairs to return a copy")
+	}
+}
+
+// TestInMemoryBytesTree_Concurrent scans while a writer inserts, reusing
+// the bounds of each scan as soon as the call returns. Run it with -race to
+// check the locking.
+func TestInMemoryBytesTree_Concurrent(t *testing.T) {
+	tree := NewInMemoryBytesTree()
+	const keys = 500
+	key := func(k int) []byte { return []byte{byte(k >> 8), byte(k)} }
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(stop)
+		for k := 0; k < keys; k++ {
+			if err := tree.Insert(key(k), key(k)); err != nil {
+				t.Errorf("Insert failed: %v", err)
+				return
+			}
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				start, end := key(0), key(keys)
+				results, scanErr, err := tree.Scan(start, end)
+				if err != nil {
+					t.Errorf("Scan failed: %v", err)
+					return
+				}
+				start[1], end[1] = 0xff, 0 // The tree must not use them anymore
+				n := 0
+				for p := range results {
+					if !bytes.Equal(p.Key, key(n)) || !bytes.Equal(p.Value, key(n)) {
+						t.Errorf("Expected key %v in a consistent scan, got %v", key(n), p.Key)
+					}
+					n++
+				}
+				if err := scanErr(); err != nil {
+					t.Errorf("Scan failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
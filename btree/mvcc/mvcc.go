@@ -0,0 +1,542 @@
+// btree/mvcc/mvcc.go
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// ErrSnapshotClosed is returned when reading from a closed Snapshot.
+var ErrSnapshotClosed = errors.New("snapshot closed")
+
+// scanBatchSize bounds how many pairs a scan collects while holding the tree
+// lock before handing them to the consumer.
+const scanBatchSize = 256
+
+// Tree adds multi-version concurrency control to a btree.BTree. Every write
+// gets a timestamp, and Snapshot returns a read-only view of the tree as of
+// the latest one. The wrapped tree always holds the newest version of each
+// pair; when a write replaces a version that a live snapshot can still see,
+// the old version is kept in memory until no snapshot can see it anymore.
+//
+// Tree is safe for concurrent use. Writers wait only for the short steps in
+// which readers collect a batch of pairs, never for a whole scan. The wrapped
+// tree is used by one goroutine at a time, so it need not be safe for
+// concurrent use, but it must not be modified except through the Tree.
+type Tree struct {
+	mu        sync.Mutex
+	tree      btree.BTree
+	now       uint64               // Timestamp of the latest write
+	snapshots map[uint64]int       // Live snapshots per timestamp
+	versions  map[uint64][]version // Replaced versions per key, oldest first
+	keys      []uint64             // Keys of versions, in ascending order
+}
+
+// version is a pair as it was before the write at ts replaced it.
+type version struct {
+	ts    uint64
+	value uint64
+	found bool // False if the key was absent
+}
+
+// New wraps tree with multi-version concurrency control.
+func New(tree btree.BTree) *Tree {
+	return &Tree{
+		tree:      tree,
+		snapshots: make(map[uint64]int),
+		versions:  make(map[uint64][]version),
+	}
+}
+
+// Snapshot returns a read-only view of the tree as of now. Writes made later
+// are invisible to it. The snapshot must be closed so the versions it keeps
+// alive can be discarded.
+func (t *Tree) Snapshot() *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshots[t.now]++
+	return &Snapshot{view: &view{tree: t, ts: t.now}}
+}
+
+// release ends a snapshot taken at ts and discards the versions no remaining
+// snapshot can see.
+func (t *Tree) release(ts uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.snapshots[ts]--; t.snapshots[ts] == 0 {
+		delete(t.snapshots, ts)
+	}
+	t.collectGarbage()
+}
+
+// collectGarbage keeps, for each key, only the versions that some snapshot
+// sees: for a snapshot at ts, the first version replaced after ts.
+func (t *Tree) collectGarbage() {
+	live := make([]uint64, 0, len(t.snapshots))
+	for ts := range t.snapshots {
+		live = append(live, ts)
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i] < live[j] })
+
+	keys := t.keys[:0]
+	for _, key := range t.keys {
+		var kept []version
+		s := 0
+		for _, v := range t.versions[key] {
+			seen := false
+			for ; s < len(live) && live[s] < v.ts; s++ {
+				seen = true
+			}
+			if seen {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.versions, key)
+			continue
+		}
+		t.versions[key] = kept
+		keys = append(keys, key)
+	}
+	t.keys = keys
+}
+
+// record keeps the current version of key before the write at t.now replaces
+// it, unless no snapshot would see it. Must hold t.mu for writing.
+func (t *Tree) record(key uint64) {
+	if len(t.snapshots) == 0 {
+		return
+	}
+	var newest uint64
+	for ts := range t.snapshots {
+		if ts > newest {
+			newest = ts
+		}
+	}
+	vs, exists := t.versions[key]
+	if exists && vs[len(vs)-1].ts > newest {
+		// Every snapshot already sees an older version.
+		return
+	}
+
+	value, found := t.tree.Lookup(key)
+	if !exists {
+		i := sort.Search(len(t.keys), func(i int) bool { return t.keys[i] >= key })
+		t.keys = append(t.keys, 0)
+		copy(t.keys[i+1:], t.keys[i:])
+		t.keys[i] = key
+	}
+	t.versions[key] = append(vs, version{ts: t.now, value: value, found: found})
+}
+
+// Insert adds or updates a key-value pair in the tree.
+func (t *Tree) Insert(key uint64, value uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now++
+	t.record(key)
+	return t.tree.Insert(key, value)
+}
+
+// Delete removes a key-value pair from the tree.
+func (t *Tree) Delete(key uint64) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now++
+	t.record(key)
+	return t.tree.Delete(key)
+}
+
+// DeleteRange removes all key-value pairs within the given range.
+func (t *Tree) DeleteRange(minKey uint64, maxKey uint64) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now++
+	if len(t.snapshots) > 0 && minKey <= maxKey {
+		// Every pair in the range is replaced by its absence.
+		latest := t.latest()
+		for from := minKey; ; {
+			batch, more, err := latest.collectLocked(from, maxKey, scanBatchSize, false)
+			if err != nil {
+				return 0, err
+			}
+			for _, p := range batch {
+				t.record(p.Key)
+			}
+			if !more {
+				break
+			}
+			from = batch[len(batch)-1].Key + 1
+		}
+	}
+	return t.tree.DeleteRange(minKey, maxKey)
+}
+
+// Lookup finds the value associated with the given key.
+func (t *Tree) Lookup(key uint64) (uint64, bool) {
+	return t.latest().Lookup(key)
+}
+
+// Scan retrieves all key-value pairs within the given range, as of the
+// moment the scan starts.
+func (t *Tree) Scan(minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.ScanContext(context.Background(), minKey, maxKey)
+}
+
+// ScanContext retrieves all key-value pairs within the given range, as of the
+// moment the scan starts, until ctx is cancelled.
+func (t *Tree) ScanContext(ctx context.Context, minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.scan(ctx, minKey, maxKey, false)
+}
+
+// ReverseScan retrieves all key-value pairs within the given range in
+// descending key order, as of the moment the scan starts.
+func (t *Tree) ReverseScan(maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.ReverseScanContext(context.Background(), maxKey, minKey)
+}
+
+// ReverseScanContext retrieves all key-value pairs within the given range in
+// descending key order, as of the moment the scan starts, until ctx is
+// cancelled.
+func (t *Tree) ReverseScanContext(ctx context.Context, maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return t.scan(ctx, minKey, maxKey, true)
+}
+
+// scan runs a scan over a snapshot of its own, released when the scan ends.
+func (t *Tree) scan(ctx context.Context, minKey uint64, maxKey uint64, reverse bool) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	s := t.Snapshot()
+	results, scanErr := s.stream(ctx, minKey, maxKey, reverse, func() { s.Close() })
+	return results, scanErr, nil
+}
+
+// Cursor returns a cursor over the newest version of the tree. Each
+// positioning call sees the writes completed before it.
+func (t *Tree) Cursor() (btree.Cursor, error) {
+	return &cursor{view: t.latest()}, nil
+}
+
+// latest returns a view of the newest version of every pair.
+func (t *Tree) latest() *view {
+	return &view{tree: t, ts: math.MaxUint64}
+}
+
+// Snapshot is a read-only view of a Tree as of a timestamp. It implements
+// btree.ReadOnlyBTree and is safe for concurrent use.
+type Snapshot struct {
+	*view
+	once sync.Once
+}
+
+// Close releases the snapshot. Reading from a closed snapshot fails with
+// ErrSnapshotClosed, and Lookup finds nothing.
+func (s *Snapshot) Close() error {
+	s.once.Do(func() {
+		s.tree.mu.Lock()
+		s.closed = true
+		s.tree.mu.Unlock()
+		s.tree.release(s.ts)
+	})
+	return nil
+}
+
+// Scan retrieves all key-value pairs within the given range as of the
+// snapshot.
+func (s *Snapshot) Scan(minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return s.ScanContext(context.Background(), minKey, maxKey)
+}
+
+// ScanContext retrieves all key-value pairs within the given range as of the
+// snapshot until ctx is cancelled.
+func (s *Snapshot) ScanContext(ctx context.Context, minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	results, scanErr := s.stream(ctx, minKey, maxKey, false, nil)
+	return results, scanErr, nil
+}
+
+// ReverseScan retrieves all key-value pairs within the given range in
+// descending key order as of the snapshot.
+func (s *Snapshot) ReverseScan(maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	return s.ReverseScanContext(context.Background(), maxKey, minKey)
+}
+
+// ReverseScanContext retrieves all key-value pairs within the given range in
+// descending key order as of the snapshot until ctx is cancelled.
+func (s *Snapshot) ReverseScanContext(ctx context.Context, maxKey uint64, minKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	results, scanErr := s.stream(ctx, minKey, maxKey, true, nil)
+	return results, scanErr, nil
+}
+
+// Cursor returns a cursor over the snapshot.
+func (s *Snapshot) Cursor() (btree.Cursor, error) {
+	return &cursor{view: s.view}, nil
+}
+
+// view reads a Tree as of timestamp ts, using the replaced versions of keys
+// written after ts in place of the wrapped tree's newest ones.
+type view struct {
+	tree   *Tree
+	ts     uint64
+	closed bool // Set under tree.mu when the snapshot is closed
+}
+
+// valueAt returns the version of key seen at v.ts, given its newest version.
+// Must hold tree.mu.
+func (v *view) valueAt(key uint64, value uint64, found bool) (uint64, bool) {
+	for _, old := range v.tree.versions[key] {
+		if old.ts > v.ts {
+			return old.value, old.found
+		}
+	}
+	return value, found
+}
+
+// Lookup finds the value associated with the given key as of v.ts.
+func (v *view) Lookup(key uint64) (uint64, bool) {
+	v.tree.mu.Lock()
+	defer v.tree.mu.Unlock()
+	if v.closed {
+		return 0, false
+	}
+	value, found := v.tree.tree.Lookup(key)
+	return v.valueAt(key, value, found)
+}
+
+// stream sends the pairs within the given range on a channel, collecting
+// them in batches so that writers are only held up while a batch is read.
+// finish, if not nil, runs once the scan has ended.
+func (v *view) stream(ctx context.Context, minKey uint64, maxKey uint64, reverse bool, finish func()) (<-chan btree.KeyValuePair, btree.ScanErrFunc) {
+	results := make(chan btree.KeyValuePair)
+	done := make(chan struct{})
+	var scanErr error
+
+	from, to := minKey, maxKey
+	if reverse {
+		from, to = maxKey, minKey
+	}
+	go func() {
+		defer close(done)
+		defer close(results)
+		if finish != nil {
+			defer finish()
+		}
+		if minKey > maxKey {
+			return
+		}
+		for {
+			batch, more, err := v.collect(from, to, scanBatchSize, reverse)
+			if err != nil {
+				scanErr = err
+				return
+			}
+			for _, kv := range batch {
+				select {
+				case results <- kv:
+				case <-ctx.Done():
+					scanErr = ctx.Err()
+					return
+				}
+			}
+			if !more {
+				return
+			}
+			last := batch[len(batch)-1].Key
+			if from = last + 1; reverse {
+				from = last - 1
+			}
+		}
+	}()
+
+	return results, func() error {
+		<-done
+		return scanErr
+	}
+}
+
+// collect gathers up to limit pairs visible at v.ts, starting at from and
+// moving towards to, both inclusive. more reports whether the range holds
+// further candidates.
+func (v *view) collect(from, to uint64, limit int, reverse bool) (batch []btree.KeyValuePair, more bool, err error) {
+	v.tree.mu.Lock()
+	defer v.tree.mu.Unlock()
+	if v.closed {
+		return nil, false, ErrSnapshotClosed
+	}
+	return v.collectLocked(from, to, limit, reverse)
+}
+
+// collectLocked is collect for callers holding tree.mu. Keys deleted after
+// v.ts are missing from the wrapped tree, so its keys are merged with the
+// keys that have replaced versions.
+func (v *view) collectLocked(from, to uint64, limit int, reverse bool) (batch []btree.KeyValuePair, more bool, err error) {
+	c, err := v.tree.tree.Cursor()
+	if err != nil {
+		return nil, false, err
+	}
+	defer c.Close()
+
+	// Position both sources at the first key at or beyond from.
+	var ok bool
+	keys := v.tree.keys
+	var i int
+	if !reverse {
+		ok = c.Seek(from)
+		i = sort.Search(len(keys), func(i int) bool { return keys[i] >= from })
+	} else {
+		if from == math.MaxUint64 || !c.Seek(from+1) {
+			ok = c.Err() == nil && c.Last()
+		} else {
+			ok = c.Prev()
+		}
+		i = sort.Search(len(keys), func(i int) bool { return keys[i] > from }) - 1
+	}
+	inRange := func(key uint64) bool {
+		if reverse {
+			return key >= to
+		}
+		return key <= to
+	}
+	before := func(a, b uint64) bool {
+		if reverse {
+			return a > b
+		}
+		return a < b
+	}
+	step := 1
+	if reverse {
+		step = -1
+	}
+
+	for {
+		treeKey := ok && inRange(c.Key())
+		historyKey := i >= 0 && i < len(keys) && inRange(keys[i])
+		if !treeKey && !historyKey {
+			break
+		}
+		if len(batch) == limit {
+			more = true
+			break
+		}
+
+		var key, value uint64
+		var found bool
+		switch {
+		case treeKey && (!historyKey || !before(keys[i], c.Key())):
+			key, value, found = c.Key(), c.Value(), true
+			if historyKey && keys[i] == key {
+				i += step
+			}
+			if reverse {
+				ok = c.Prev()
+			} else {
+				ok = c.Next()
+			}
+		default:
+			key = keys[i]
+			i += step
+		}
+		if value, found = v.valueAt(key, value, found); found {
+			batch = append(batch, btree.KeyValuePair{Key: key, Value: value})
+		}
+	}
+	return batch, more, c.Err()
+}
+
+// cursor iterates over a view. It holds no lock between calls; each move
+// looks up the neighbouring pair of the current key afresh.
+type cursor struct {
+	view   *view
+	key    uint64
+	value  uint64
+	valid  bool
+	err    error
+	closed bool
+}
+
+// position moves the cursor to the first pair visible from from towards the
+// end given by reverse.
+func (c *cursor) position(from uint64, reverse bool) bool {
+	if c.closed || c.err != nil {
+		c.valid = false
+		return false
+	}
+	to := uint64(math.MaxUint64)
+	if reverse {
+		to = 0
+	}
+	batch, _, err := c.view.collect(from, to, 1, reverse)
+	if err != nil {
+		c.err = err
+	}
+	if c.valid = len(batch) == 1; c.valid {
+		c.key, c.value = batch[0].Key, batch[0].Value
+	}
+	return c.valid
+}
+
+// Seek positions the cursor at the first pair whose key is >= key.
+func (c *cursor) Seek(key uint64) bool {
+	return c.position(key, false)
+}
+
+// First positions the cursor at the pair with the smallest key.
+func (c *cursor) First() bool {
+	return c.position(0, false)
+}
+
+// Last positions the cursor at the pair with the largest key.
+func (c *cursor) Last() bool {
+	return c.position(math.MaxUint64, true)
+}
+
+// Next moves the cursor to the pair with the next larger key.
+func (c *cursor) Next() bool {
+	if !c.valid || c.key == math.MaxUint64 {
+		c.valid = false
+		return false
+	}
+	return c.position(c.key+1, false)
+}
+
+// Prev moves the cursor to the pair with the next smaller key.
+func (c *cursor) Prev() bool {
+	if !c.valid || c.key == 0 {
+		c.valid = false
+		return false
+	}
+	return c.position(c.key-1, true)
+}
+
+// Key returns the key of the current pair.
+func (c *cursor) Key() uint64 {
+	return c.key
+}
+
+// Value returns the value of the current pair.
+func (c *cursor) Value() uint64 {
+	return c.value
+}
+
+// Err returns the error that invalidated the cursor, if any.
+func (c *cursor) Err() error {
+	return c.err
+}
+
+// Close releases the cursor.
+func (c *cursor) Close() error {
+	c.closed = true
+	c.valid = false
+	return nil
+}
@@ -0,0 +1,241 @@
+// btree/mvcc/mvcc_test.go
+package mvcc
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/btree/bplustree"
+	"github.com/pillairaunak/btree-store-go/btree/btreetest"
+	"github.com/pillairaunak/btree-store-go/btree/inmemory"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// Snapshot must be usable wherever a read-only tree is expected.
+var _ btree.ReadOnlyBTree = (*Snapshot)(nil)
+
+// newTrees returns a fresh Tree over every BTree implementation, keyed by
+// name.
+func newTrees(t *testing.T) map[string]*Tree {
+	t.Helper()
+	bm := buffermanager.NewMockBufferManager()
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	paged, err := bplustree.New(bm, btreeID, bplustree.WithMaxKeys(4))
+	if err != nil {
+		t.Fatalf("bplustree.New failed: %v", err)
+	}
+	return map[string]*Tree{
+		"InMemory":  New(inmemory.NewInMemoryBTree()),
+		"BPlusTree": New(paged),
+	}
+}
+
+// versionCount returns how many replaced versions tree keeps.
+func versionCount(tree *Tree) int {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	n := 0
+	for _, vs := range tree.versions {
+		n += len(vs)
+	}
+	return n
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	for name, tree := range newTrees(t) {
+		t.Run(name, func(t *testing.T) {
+			expected := make(map[uint64]uint64)
+			for k := uint64(0); k < 50; k++ {
+				if err := tree.Insert(k, k); err != nil {
+					t.Fatalf("Insert(%d) failed: %v", k, err)
+				}
+				expected[k] = k
+			}
+			s := tree.Snapshot()
+
+			// Test case 1: Updates, deletes and range deletes after the
+			// snapshot are invisible to it.
+			for k := uint64(0); k < 50; k += 2 {
+				tree.Insert(k, k+1000)
+			}
+			tree.Delete(7)
+			tree.Delete(7)
+			if _, err := tree.DeleteRange(20, 29); err != nil {
+				t.Fatalf("DeleteRange failed: %v", err)
+			}
+			tree.Insert(25, 1)
+			tree.Insert(100, 100)
+			if got := btreetest.Contents(t, s); !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected the snapshot to keep %d pairs, got %v", len(expected), got)
+			}
+			if _, found := s.Lookup(100); found {
+				t.Error("Expected a key inserted after the snapshot to be invisible")
+			}
+
+			// Test case 2: The tree itself sees the latest writes.
+			latest := btreetest.Contents(t, tree)
+			if value, found := latest[4]; !found || value != 1004 {
+				t.Errorf("Expected (1004, true) for key 4, got (%d, %v)", value, found)
+			}
+			if _, found := latest[7]; found {
+				t.Error("Expected key 7 to be deleted")
+			}
+			if len(latest) != 50-1-10+2 {
+				t.Errorf("Expected %d pairs, got %d", 50-1-10+2, len(latest))
+			}
+
+			// Test case 3: A second snapshot sees the tree as of its own time.
+			s2 := tree.Snapshot()
+			tree.DeleteRange(0, math.MaxUint64)
+			if got := btreetest.Contents(t, s2); !reflect.DeepEqual(got, latest) {
+				t.Errorf("Expected the second snapshot to hold %d pairs, got %d", len(latest), len(got))
+			}
+			if got := btreetest.Contents(t, s); !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected the first snapshot to be unchanged, got %d pairs", len(got))
+			}
+			if got := btreetest.Contents(t, tree); len(got) != 0 {
+				t.Errorf("Expected an empty tree, got %v", got)
+			}
+
+			// Test case 4: Closed snapshots refuse to be read.
+			s.Close()
+			s2.Close()
+			if _, found := s.Lookup(1); found {
+				t.Error("Expected Lookup on a closed snapshot to find nothing")
+			}
+			if _, scanErr, _ := s.Scan(0, 10); !errors.Is(scanErr(), ErrSnapshotClosed) {
+				t.Errorf("Expected ErrSnapshotClosed from Scan, got %v", scanErr())
+			}
+			c, _ := s2.Cursor()
+			if c.First() || !errors.Is(c.Err(), ErrSnapshotClosed) {
+				t.Errorf("Expected ErrSnapshotClosed from the cursor, got %v", c.Err())
+			}
+		})
+	}
+}
+
+func TestSnapshotGarbageCollection(t *testing.T) {
+	tree := New(inmemory.NewInMemoryBTree())
+	for k := uint64(0); k < 10; k++ {
+		tree.Insert(k, 0)
+	}
+
+	// Test case 1: Without snapshots no versions are kept.
+	tree.Insert(1, 1)
+	if n := versionCount(tree); n != 0 {
+		t.Errorf("Expected no versions without snapshots, got %d", n)
+	}
+
+	// Test case 2: One version per key serves every write after a snapshot.
+	s1 := tree.Snapshot()
+	for i := uint64(0); i < 5; i++ {
+		tree.Insert(1, 10+i)
+	}
+	if n := versionCount(tree); n != 1 {
+		t.Errorf("Expected 1 version, got %d", n)
+	}
+
+	// Test case 3: A newer snapshot needs a version of its own.
+	s2 := tree.Snapshot()
+	tree.Insert(1, 20)
+	tree.Insert(2, 20)
+	if n := versionCount(tree); n != 3 {
+		t.Errorf("Expected 3 versions, got %d", n)
+	}
+	if value, _ := s1.Lookup(1); value != 1 {
+		t.Errorf("Expected 1 in the first snapshot, got %d", value)
+	}
+	if value, _ := s2.Lookup(1); value != 14 {
+		t.Errorf("Expected 14 in the second snapshot, got %d", value)
+	}
+
+	// Test case 4: Closing a snapshot drops the versions only it could see.
+	s1.Close()
+	if n := versionCount(tree); n != 2 {
+		t.Errorf("Expected 2 versions after closing the first snapshot, got %d", n)
+	}
+	if value, _ := s2.Lookup(1); value != 14 {
+		t.Errorf("Expected 14 in the second snapshot, got %d", value)
+	}
+	s2.Close()
+	s2.Close()
+	if n := versionCount(tree); n != 0 {
+		t.Errorf("Expected no versions after closing every snapshot, got %d", n)
+	}
+	if len(tree.keys) != 0 || len(tree.snapshots) != 0 {
+		t.Errorf("Expected no bookkeeping left, got %d keys and %d snapshots", len(tree.keys), len(tree.snapshots))
+	}
+}
+
+func TestSnapshotConcurrentWriter(t *testing.T) {
+	for name, tree := range newTrees(t) {
+		t.Run(name, func(t *testing.T) {
+			const keys = 1000
+			for k := uint64(0); k < keys; k++ {
+				tree.Insert(k, 0)
+			}
+
+			// The writer moves every key to the next round, one at a time, so
+			// a consistent view holds at most two rounds, with every key of
+			// the newer one below every key of the older one.
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for round := uint64(1); ; round++ {
+					for k := uint64(0); k < keys; k++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						if err := tree.Insert(k, round); err != nil {
+							t.Errorf("Insert failed: %v", err)
+							return
+						}
+					}
+				}
+			}()
+
+			check := func(pairs []btree.KeyValuePair) {
+				if len(pairs) != keys {
+					t.Fatalf("Expected %d pairs, got %d", keys, len(pairs))
+				}
+				for i := 1; i < len(pairs); i++ {
+					if pairs[i].Value > pairs[i-1].Value || pairs[i].Value+1 < pairs[0].Value {
+						t.Fatalf("Expected a consistent view, got round %d at key %d after round %d",
+							pairs[i].Value, pairs[i].Key, pairs[i-1].Value)
+					}
+				}
+			}
+			drain := btreetest.Drain(t)
+
+			for i := 0; i < 20; i++ {
+				s := tree.Snapshot()
+				first := drain(s.Scan(0, math.MaxUint64))
+				check(first)
+				if second := drain(s.Scan(0, math.MaxUint64)); !reflect.DeepEqual(first, second) {
+					t.Fatal("Expected repeated scans of a snapshot to agree")
+				}
+				s.Close()
+
+				// Scans of the tree itself run over a snapshot of their own.
+				check(drain(tree.Scan(0, math.MaxUint64)))
+			}
+			close(stop)
+			wg.Wait()
+
+			if n := versionCount(tree); n != 0 {
+				t.Errorf("Expected no versions once every snapshot is closed, got %d", n)
+			}
+		})
+	}
+}
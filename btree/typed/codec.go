@@ -0,0 +1,150 @@
+// btree/typed/codec.go
+package typed
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Codec converts values of type T to and from bytes.
+//
+// A codec used for the keys of a Map must be order-preserving: for any a and
+// b, bytes.Compare(Encode(a), Encode(b)) must agree with the natural order of
+// a and b, so that scans return keys in that order. Every codec in this
+// package except JSONCodec is order-preserving.
+type Codec[T any] interface {
+	// Encode returns the byte representation of v.
+	Encode(v T) ([]byte, error)
+
+	// Decode reconstructs a value from the output of Encode.
+	Decode(data []byte) (T, error)
+}
+
+// Int64Codec returns an order-preserving codec for int64. Values are stored
+// big-endian in 8 bytes with the sign bit flipped, so negative numbers sort
+// before positive ones.
+func Int64Codec() Codec[int64] {
+	return int64Codec{}
+}
+
+type int64Codec struct{}
+
+func (int64Codec) Encode(v int64) ([]byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(v)^signBit)
+	return data, nil
+}
+
+func (int64Codec) Decode(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("%w: int64 needs 8 bytes, got %d", ErrInvalidEncoding, len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data) ^ signBit), nil
+}
+
+// signBit is the most significant bit of a 64-bit word.
+const signBit = 1 << 63
+
+// Uint64Codec returns an order-preserving codec for uint64. Values are
+// stored big-endian in 8 bytes.
+func Uint64Codec() Codec[uint64] {
+	return uint64Codec{}
+}
+
+type uint64Codec struct{}
+
+func (uint64Codec) Encode(v uint64) ([]byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, v)
+	return data, nil
+}
+
+func (uint64Codec) Decode(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("%w: uint64 needs 8 bytes, got %d", ErrInvalidEncoding, len(data))
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// StringCodec returns an order-preserving codec for strings. Strings are
+// stored as their raw bytes, which sort the same way Go compares strings.
+func StringCodec() Codec[string] {
+	return stringCodec{}
+}
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (stringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// BytesCodec returns an order-preserving codec for byte slices, which are
+// stored unchanged.
+func BytesCodec() Codec[[]byte] {
+	return bytesCodec{}
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (bytesCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// TimeCodec returns an order-preserving codec for time.Time with nanosecond
+// precision. Only the instant is stored: decoded times are in UTC and carry
+// neither the original location nor a monotonic clock reading.
+func TimeCodec() Codec[time.Time] {
+	return timeCodec{}
+}
+
+type timeCodec struct{}
+
+func (timeCodec) Encode(v time.Time) ([]byte, error) {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint64(data, uint64(v.Unix())^signBit)
+	binary.BigEndian.PutUint32(data[8:], uint32(v.Nanosecond()))
+	return data, nil
+}
+
+func (timeCodec) Decode(data []byte) (time.Time, error) {
+	if len(data) != 12 {
+		return time.Time{}, fmt.Errorf("%w: time needs 12 bytes, got %d", ErrInvalidEncoding, len(data))
+	}
+	sec := int64(binary.BigEndian.Uint64(data) ^ signBit)
+	nsec := int64(binary.BigEndian.Uint32(data[8:]))
+	if nsec >= int64(time.Second) {
+		return time.Time{}, fmt.Errorf("%w: time has %d nanoseconds", ErrInvalidEncoding, nsec)
+	}
+	return time.Unix(sec, nsec).UTC(), nil
+}
+
+// JSONCodec returns a codec that stores values as JSON, which suits structs
+// and other composite values. It is not order-preserving, so it must not be
+// used for keys.
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+type jsonCodec[T any] struct{}
+
+func (jsonCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	return v, nil
+}
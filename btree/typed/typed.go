@@ -0,0 +1,172 @@
+// btree/typed/typed.go
+package typed
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// ErrInvalidEncoding is returned when stored bytes cannot be decoded by a
+// Codec.
+var ErrInvalidEncoding = errors.New("invalid encoding")
+
+// Map is a type-safe view of a btree.BytesTree that stores keys of type K and
+// values of type V. Keys are encoded with an order-preserving Codec, so scans
+// return pairs in the natural order of K.
+type Map[K, V any] struct {
+	tree   btree.BytesTree
+	keys   Codec[K]
+	values Codec[V]
+}
+
+// Pair represents a key-value pair in a Map.
+type Pair[K, V any] struct {
+	Key   K
+	Value V
+}
+
+// New creates a Map over tree that encodes keys with keys and values with
+// values.
+func New[K, V any](tree btree.BytesTree, keys Codec[K], values Codec[V]) *Map[K, V] {
+	return &Map[K, V]{
+		tree:   tree,
+		keys:   keys,
+		values: values,
+	}
+}
+
+// Get finds the value associated with the given key.
+// Returns the value and true if found, the zero value and false if not found,
+// and an error if the key cannot be encoded or the stored value decoded.
+func (m *Map[K, V]) Get(key K) (V, bool, error) {
+	var zero V
+	k, err := m.keys.Encode(key)
+	if err != nil {
+		return zero, false, err
+	}
+	data, found := m.tree.Lookup(k)
+	if !found {
+		return zero, false, nil
+	}
+	value, err := m.values.Decode(data)
+	if err != nil {
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// Put adds or updates a key-value pair.
+func (m *Map[K, V]) Put(key K, value V) error {
+	k, err := m.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	v, err := m.values.Encode(value)
+	if err != nil {
+		return err
+	}
+	return m.tree.Insert(k, v)
+}
+
+// Delete removes the key and its value.
+// Returns true if the key was present.
+func (m *Map[K, V]) Delete(key K) (bool, error) {
+	k, err := m.keys.Encode(key)
+	if err != nil {
+		return false, err
+	}
+	return m.tree.Delete(k)
+}
+
+// Scan retrieves all pairs where start <= key < end, in ascending key order.
+// A consumer that stops reading early must use ScanContext instead.
+func (m *Map[K, V]) Scan(start K, end K) (<-chan Pair[K, V], btree.ScanErrFunc, error) {
+	return m.ScanContext(context.Background(), start, end)
+}
+
+// ScanContext behaves like Scan but stops producing results and closes the
+// channel once ctx is cancelled.
+// A pair that cannot be decoded ends the scan, and the ScanErrFunc reports
+// the decoding error.
+func (m *Map[K, V]) ScanContext(ctx context.Context, start K, end K) (<-chan Pair[K, V], btree.ScanErrFunc, error) {
+	s, err := m.keys.Encode(start)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := m.keys.Encode(end)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e == nil {
+		// A nil end is unbounded in a BytesTree, while an empty one bounds
+		// the range to nothing.
+		e = []byte{}
+	}
+	return m.scan(ctx, s, e)
+}
+
+// All retrieves every pair in ascending key order until ctx is cancelled.
+func (m *Map[K, V]) All(ctx context.Context) (<-chan Pair[K, V], btree.ScanErrFunc, error) {
+	return m.scan(ctx, nil, nil)
+}
+
+// scan decodes the pairs of a BytesTree scan over [start, end). The
+// underlying scan runs under its own context so that a decoding failure can
+// stop it.
+func (m *Map[K, V]) scan(ctx context.Context, start, end []byte) (<-chan Pair[K, V], btree.ScanErrFunc, error) {
+	innerCtx, cancel := context.WithCancel(ctx)
+	raw, rawErr, err := m.tree.ScanContext(innerCtx, start, end)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	results := make(chan Pair[K, V])
+	done := make(chan struct{})
+	var scanErr error
+
+	go func() {
+		defer close(done)
+		defer close(results)
+		defer cancel()
+
+		for kv := range raw {
+			pair, err := m.decode(kv)
+			if err != nil {
+				cancel()
+				rawErr()
+				scanErr = err
+				return
+			}
+			select {
+			case results <- pair:
+			case <-ctx.Done():
+				cancel()
+				rawErr()
+				scanErr = ctx.Err()
+				return
+			}
+		}
+		scanErr = rawErr()
+	}()
+
+	return results, func() error {
+		<-done
+		return scanErr
+	}, nil
+}
+
+// decode converts a raw pair into a typed one.
+func (m *Map[K, V]) decode(kv btree.BytesPair) (Pair[K, V], error) {
+	var pair Pair[K, V]
+	key, err := m.keys.Decode(kv.Key)
+	if err != nil {
+		return pair, err
+	}
+	value, err := m.values.Decode(kv.Value)
+	if err != nil {
+		return pair, err
+	}
+	return Pair[K, V]{Key: key, Value: value}, nil
+}
@@ -0,0 +1,259 @@
+// btree/typed/typed_test.go
+package typed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/btree/bytestree"
+	"github.com/pillairaunak/btree-store-go/btree/inmemory"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// newTrees returns a fresh BytesTree of every implementation, keyed by name.
+func newTrees(t *testing.T) map[string]btree.BytesTree {
+	t.Helper()
+	bm := buffermanager.NewMockBufferManager()
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	paged, err := bytestree.New(bm, btreeID)
+	if err != nil {
+		t.Fatalf("bytestree.New failed: %v", err)
+	}
+	return map[string]btree.BytesTree{
+		"InMemory":  inmemory.NewInMemoryBytesTree(),
+		"BytesTree": paged,
+	}
+}
+
+// collect drains a scan and returns its pairs and final error.
+func collect[K, V any](results <-chan Pair[K, V], scanErr btree.ScanErrFunc, err error) ([]Pair[K, V], error) {
+	if err != nil {
+		return nil, err
+	}
+	var pairs []Pair[K, V]
+	for p := range results {
+		pairs = append(pairs, p)
+	}
+	return pairs, scanErr()
+}
+
+// checkOrder fails the test unless the codec encodes values, which are in
+// ascending order, to ascending byte strings that decode back to the same
+// values.
+func checkOrder[T any](t *testing.T, codec Codec[T], values []T) {
+	t.Helper()
+	var prev []byte
+	for i, v := range values {
+		data, err := codec.Encode(v)
+		if err != nil {
+			t.Fatalf("Encode(%v) failed: %v", v, err)
+		}
+		if i > 0 && bytes.Compare(prev, data) >= 0 {
+			t.Errorf("Expected encoding of %v to sort after %v", v, values[i-1])
+		}
+		got, err := codec.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode of %v failed: %v", v, err)
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("Expected %v to round-trip, got %v", v, got)
+		}
+		prev = data
+	}
+}
+
+func TestCodecOrder(t *testing.T) {
+	checkOrder(t, Int64Codec(), []int64{math.MinInt64, -1000, -1, 0, 1, 1000, math.MaxInt64})
+	checkOrder(t, Uint64Codec(), []uint64{0, 1, 255, 256, math.MaxUint64})
+	checkOrder(t, StringCodec(), []string{"", "a", "ab", "b", "ba", "\xff"})
+	checkOrder(t, BytesCodec(), [][]byte{{}, {0}, {0, 0}, {1}, {255}})
+	checkOrder(t, TimeCodec(), []time.Time{
+		time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 999999999, time.UTC),
+		time.Unix(0, 0).UTC(),
+		time.Unix(0, 1).UTC(),
+		time.Date(2262, 4, 12, 0, 0, 0, 0, time.UTC),
+		time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC),
+	})
+}
+
+func TestCodecInvalidEncoding(t *testing.T) {
+	if _, err := Int64Codec().Decode([]byte{1, 2, 3}); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("Expected ErrInvalidEncoding for a short int64, got %v", err)
+	}
+	if _, err := TimeCodec().Decode(make([]byte, 8)); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("Expected ErrInvalidEncoding for a short time, got %v", err)
+	}
+	if _, err := JSONCodec[struct{ A int }]().Decode([]byte("{")); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("Expected ErrInvalidEncoding for malformed JSON, got %v", err)
+	}
+}
+
+func TestTimeCodecDropsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*60*60)
+	v := time.Date(2024, 3, 1, 12, 30, 0, 42, loc)
+	data, _ := TimeCodec().Encode(v)
+	got, err := TimeCodec().Decode(data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !got.Equal(v) || got.Location() != time.UTC {
+		t.Errorf("Expected %v in UTC, got %v", v.UTC(), got)
+	}
+}
+
+type account struct {
+	Owner   string
+	Balance int64
+}
+
+func TestMap(t *testing.T) {
+	for name, tree := range newTrees(t) {
+		t.Run(name, func(t *testing.T) {
+			m := New(tree, Int64Codec(), JSONCodec[account]())
+
+			// Test case 1: Looking up a missing key
+			if _, found, err := m.Get(7); err != nil || found {
+				t.Errorf("Expected (false, nil) for missing key, got (%v, %v)", found, err)
+			}
+
+			// Test case 2: Put, update and Get
+			keys := []int64{5, -3, 100, 0, -200, 42}
+			for _, k := range keys {
+				if err := m.Put(k, account{Owner: "owner", Balance: k * 10}); err != nil {
+					t.Fatalf("Put(%d) failed: %v", k, err)
+				}
+			}
+			if err := m.Put(42, account{Owner: "updated", Balance: 1}); err != nil {
+				t.Fatalf("Put(42) failed: %v", err)
+			}
+			if v, found, err := m.Get(42); err != nil || !found || v != (account{Owner: "updated", Balance: 1}) {
+				t.Errorf("Expected updated account for key 42, got (%v, %v, %v)", v, found, err)
+			}
+
+			// Test case 3: Scans follow int64 order, negative keys first
+			pairs, err := collect(m.All(context.Background()))
+			if err != nil {
+				t.Fatalf("All failed: %v", err)
+			}
+			var got []int64
+			for _, p := range pairs {
+				got = append(got, p.Key)
+			}
+			sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+			if !reflect.DeepEqual(got, keys) {
+				t.Errorf("Expected keys %v, got %v", keys, got)
+			}
+
+			pairs, err = collect(m.Scan(-3, 42))
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			got = got[:0]
+			for _, p := range pairs {
+				got = append(got, p.Key)
+			}
+			if expected := []int64{-3, 0, 5}; !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected keys %v in [-3, 42), got %v", expected, got)
+			}
+			if pairs[0].Value.Balance != -30 {
+				t.Errorf("Expected balance -30 for key -3, got %d", pairs[0].Value.Balance)
+			}
+
+			// Test case 4: Delete
+			if found, err := m.Delete(-3); err != nil || !found {
+				t.Errorf("Expected (true, nil) deleting -3, got (%v, %v)", found, err)
+			}
+			if _, found, _ := m.Get(-3); found {
+				t.Error("Expected key -3 to be deleted")
+			}
+		})
+	}
+}
+
+func TestMapStringKeys(t *testing.T) {
+	m := New(inmemory.NewInMemoryBytesTree(), StringCodec(), Int64Codec())
+	for i, k := range []string{"pear", "", "apple", "applesauce", "b"} {
+		m.Put(k, int64(i))
+	}
+
+	// An empty end key bounds the range to nothing rather than leaving it open.
+	if pairs, err := collect(m.Scan("", "")); err != nil || len(pairs) != 0 {
+		t.Errorf("Expected no pairs in [\"\", \"\"), got %v (err %v)", pairs, err)
+	}
+
+	pairs, err := collect(m.Scan("", "b"))
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	var got []string
+	for _, p := range pairs {
+		got = append(got, p.Key)
+	}
+	if expected := []string{"", "apple", "applesauce"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected keys %q, got %q", expected, got)
+	}
+}
+
+func TestMapDecodeError(t *testing.T) {
+	tree := inmemory.NewInMemoryBytesTree()
+	m := New(tree, Uint64Codec(), Int64Codec())
+	for k := uint64(1); k <= 3; k++ {
+		m.Put(k, int64(k))
+	}
+	// Store a value that is not a valid int64 encoding under key 2.
+	k, _ := Uint64Codec().Encode(2)
+	tree.Insert(k, []byte("bad"))
+
+	if _, _, err := m.Get(2); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("Expected ErrInvalidEncoding from Get, got %v", err)
+	}
+
+	pairs, err := collect(m.All(context.Background()))
+	if !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("Expected scan to report ErrInvalidEncoding, got %v", err)
+	}
+	if len(pairs) != 1 || pairs[0].Key != 1 {
+		t.Errorf("Expected only key 1 before the bad value, got %v", pairs)
+	}
+}
+
+func TestMapScanContextCancel(t *testing.T) {
+	m := New(inmemory.NewInMemoryBytesTree(), Int64Codec(), Int64Codec())
+	for i := int64(0); i < 100; i++ {
+		m.Put(i, i)
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		results, scanErr, err := m.ScanContext(ctx, 0, 100)
+		if err != nil {
+			t.Fatalf("ScanContext returned unexpected error: %v", err)
+		}
+		<-results
+		cancel()
+		for range results {
+		}
+		if err := scanErr(); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected at most %d goroutines after aborted scans, got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
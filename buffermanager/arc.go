@@ -0,0 +1,111 @@
+// buffermanager/arc.go
+package buffermanager
+
+// arcPolicy implements the Adaptive Replacement Cache of Megiddo and Modha.
+// T1 holds pages seen once recently and T2 pages seen at least twice; B1 and
+// B2 remember pages recently evicted from each. A miss that hits a ghost list
+// shifts the target size p of T1 towards the list that would have kept the
+// page, balancing recency against frequency as the workload changes.
+//
+// Unlike the original formulation, the buffer pool evicts before it knows
+// which page is being loaded, so p is adapted when the page is inserted.
+type arcPolicy struct {
+	capacity int
+	p        int // Target size of T1
+	t1       *frameList
+	t2       *frameList
+	b1       *ghostList
+	b2       *ghostList
+	keys     map[int]PageKey
+}
+
+// NewARCPolicy creates an adaptive replacement cache policy.
+func NewARCPolicy(frames int) ReplacementPolicy {
+	return &arcPolicy{
+		capacity: frames,
+		t1:       newFrameList(),
+		t2:       newFrameList(),
+		b1:       newGhostList(),
+		b2:       newGhostList(),
+		keys:     make(map[int]PageKey, frames),
+	}
+}
+
+func (p *arcPolicy) Insert(frame int, page PageKey) {
+	p.keys[frame] = page
+
+	switch {
+	case p.b1.contains(page):
+		delta := 1
+		if p.b2.len() > p.b1.len() {
+			delta = p.b2.len() / p.b1.len()
+		}
+		p.p += delta
+		if p.p > p.capacity {
+			p.p = p.capacity
+		}
+		p.b1.remove(page)
+		p.t2.pushBack(frame)
+
+	case p.b2.contains(page):
+		delta := 1
+		if p.b1.len() > p.b2.len() {
+			delta = p.b1.len() / p.b2.len()
+		}
+		p.p -= delta
+		if p.p < 0 {
+			p.p = 0
+		}
+		p.b2.remove(page)
+		p.t2.pushBack(frame)
+
+	default:
+		// Keep |T1|+|B1| <= c and the directory at no more than 2c entries.
+		if p.t1.len()+p.b1.len() >= p.capacity && p.b1.len() > 0 {
+			p.b1.removeOldest()
+		} else if p.t1.len()+p.t2.len()+p.b1.len()+p.b2.len() >= 2*p.capacity && p.b2.len() > 0 {
+			p.b2.removeOldest()
+		}
+		p.t1.pushBack(frame)
+	}
+}
+
+func (p *arcPolicy) Access(frame int) {
+	if p.t1.remove(frame) {
+		p.t2.pushBack(frame)
+		return
+	}
+	p.t2.moveToBack(frame)
+}
+
+func (p *arcPolicy) Evict(evictable func(frame int) bool) (int, bool) {
+	if p.t1.len() > 0 && p.t1.len() > p.p {
+		if frame, found := p.evictFrom(p.t1, p.b1, evictable); found {
+			return frame, true
+		}
+		return p.evictFrom(p.t2, p.b2, evictable)
+	}
+	if frame, found := p.evictFrom(p.t2, p.b2, evictable); found {
+		return frame, true
+	}
+	return p.evictFrom(p.t1, p.b1, evictable)
+}
+
+// evictFrom evicts the oldest evictable frame of resident and remembers its
+// page in ghost.
+func (p *arcPolicy) evictFrom(resident *frameList, ghost *ghostList, evictable func(frame int) bool) (int, bool) {
+	frame, found := resident.oldestEvictable(evictable)
+	if !found {
+		return 0, false
+	}
+	resident.remove(frame)
+	ghost.pushBack(p.keys[frame])
+	delete(p.keys, frame)
+	return frame, true
+}
+
+func (p *arcPolicy) Remove(frame int) {
+	p.t1.remove(frame)
+	p.t2.remove(frame)
+	delete(p.keys, frame)
+}
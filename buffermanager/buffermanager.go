@@ -2,8 +2,11 @@
 package buffermanager
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+
 	"github.com/pillairaunak/btree-store-go/btree" // Import the btree interface
 	"github.com/pillairaunak/btree-store-go/btree/inmemory"
 )
@@ -15,10 +18,28 @@ var (
 	ErrBufferFull    = errors.New("buffer is full")
 )
 
+// PageSize is the size in bytes of every page handed out by a BufferManager.
+const PageSize = 4096
+
 // PageID uniquely identifies a page within a BTree
 type PageID uint64
 
+// PinMode selects the latch that a pin holds on its page until the page is
+// unpinned.
+type PinMode int
+
+const (
+	// PinShared is for reading a page. Shared pins of a page coexist.
+	PinShared PinMode = iota
+
+	// PinExclusive is for modifying a page. An exclusive pin waits until no
+	// other goroutine has the page pinned and keeps them out until it is
+	// released.
+	PinExclusive
+)
+
 // BufferManager defines the interface for managing the buffer pool and BTrees.
+// Implementations are safe for concurrent use.
 type BufferManager interface {
 	// CreateBTree creates a new empty BTree and returns its identifier.
 	CreateBTree() (string, error)
@@ -34,12 +55,21 @@ type BufferManager interface {
 	CloseBTree(btreeID string) error
 
 	// PinPage loads a page into the buffer pool and pins it, preventing eviction.
-	// Returns the page data and its position in the buffer.
-	PinPage(btreeID string, pageID PageID) ([]byte, int, error)
-
-	// UnpinPage marks a page as unpinned, making it eligible for eviction.
-	// The dirty parameter indicates whether the page was modified.
-	UnpinPage(bufferPos int, dirty bool) error
+	// Returns a handle through which the page is read, modified and
+	// unpinned. The pin latches the page in the given mode, blocking until
+	// the latch is free, and holds the latch until the handle is released.
+	// Pins of one page by different goroutines share its buffer slot, which
+	// counts them. Like a sync.RWMutex, the latch must not be acquired again
+	// by a goroutine that already holds it, not even in shared mode: a
+	// waiting exclusive pin keeps further shared pins out.
+	PinPage(btreeID string, pageID PageID, mode PinMode) (PageHandle, error)
+
+	// PinPageContext is PinPage that, instead of failing with ErrBufferFull
+	// when every page in the buffer pool is pinned, waits for a page to be
+	// unpinned. Waiting pinners are served in the order they arrived. It
+	// returns the context's error if ctx is done before a page can be
+	// evicted; waiting for the latch of the pin is not cut short.
+	PinPageContext(ctx context.Context, btreeID string, pageID PageID, mode PinMode) (PageHandle, error)
 
 	// AllocatePage creates a new page for a BTree and returns its PageID.
 	AllocatePage(btreeID string) (PageID, error)
@@ -67,27 +97,44 @@ func WithBufferSize(pages int) Option {
 
 // bufferManagerConfig holds the internal configuration for the buffer manager.
 type bufferManagerConfig struct {
-	directory  string
-	bufferSize int
+	directory     string
+	bufferSize    int
+	treeFactory   TreeFactory
+	policy        PolicyFactory
+	writeAheadLog bool
+	trackPins     bool
 }
 
 // mockBufferManager implements the BufferManager interface for testing.
 type mockBufferManager struct {
-	btrees      map[string]btree.BTree // Map BTreeID to BTree interface
-	pages       map[string]map[PageID][]byte
+	latchTable             // Implements Latcher
+	pinLatches latchTable  // Latches held by pins
+	pins       *pinTracker // Pins recorded by WithPinTracking
+
+	mu          sync.Mutex                   // Guards the fields below
+	btrees      map[string]btree.BTree       // Map BTreeID to BTree interface
+	pages       map[string]map[PageID][]byte // Backing store, behind the buffer
 	buffer      map[int]bufferEntry
+	pageTable   map[PageKey]int // Buffer position of every buffered page
+	generation  uint64          // Generation of the latest buffer entry
+	waiters     frameQueue      // Pinners waiting for a buffer position
+	policy      ReplacementPolicy
 	nextBTreeID int
 	nextPageID  map[string]PageID
 	config      bufferManagerConfig
 }
 
-// bufferEntry represents a page in the buffer pool.
+// bufferEntry represents a page in the buffer pool. All pins of the page
+// share the entry, whose data is a copy of the page in the backing store.
 type bufferEntry struct {
-	btreeID string
-	pageID  PageID
-	data    []byte
-	pinned  bool
-	dirty   bool
+	btreeID    string
+	pageID     PageID
+	data       []byte
+	clean      []byte // data as of its last dirty release, to discard changes
+	pinCount   int
+	mode       PinMode // Mode of the pins, while pinned
+	dirty      bool    // Whether data must be written back
+	generation uint64  // Tells handles of this entry from older ones
 }
 
 // NewMockBufferManager creates a new mock buffer manager with optional parameters.
@@ -95,6 +142,7 @@ func NewMockBufferManager(options ...Option) *mockBufferManager {
 	config := bufferManagerConfig{
 		directory:  ".", // Default directory
 		bufferSize: 10,  // Default buffer size
+		policy:     NewLRUPolicy,
 	}
 
 	for _, option := range options {
@@ -102,9 +150,12 @@ func NewMockBufferManager(options ...Option) *mockBufferManager {
 	}
 
 	return &mockBufferManager{
+		pins:        newPinTracker(config.trackPins),
 		btrees:      make(map[string]btree.BTree),
 		pages:       make(map[string]map[PageID][]byte),
 		buffer:      make(map[int]bufferEntry),
+		pageTable:   make(map[PageKey]int),
+		policy:      config.policy(config.bufferSize),
 		nextBTreeID: 1,
 		nextPageID:  make(map[string]PageID),
 		config:      config,
@@ -113,6 +164,8 @@ func NewMockBufferManager(options ...Option) *mockBufferManager {
 
 // CreateBTree creates a new empty BTree and returns its identifier.
 func (m *mockBufferManager) CreateBTree() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	btreeID := fmt.Sprintf("btree_%d", m.nextBTreeID)
 	m.nextBTreeID++
 	//For Mock Implementation, We are creating a new in memory Btree and saving.
@@ -124,6 +177,8 @@ func (m *mockBufferManager) CreateBTree() (string, error) {
 
 // OpenBTree opens an existing BTree by its identifier.
 func (m *mockBufferManager) OpenBTree(btreeID string) (btree.BTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	b, exists := m.btrees[btreeID]
 	if !exists {
 		return nil, ErrBTreeNotFound
@@ -133,9 +188,14 @@ func (m *mockBufferManager) OpenBTree(btreeID string) (btree.BTree, error) {
 
 // DeleteBTree permanently removes a BTree.
 func (m *mockBufferManager) DeleteBTree(btreeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if _, exists := m.btrees[btreeID]; !exists {
 		return ErrBTreeNotFound
 	}
+	if m.pinnedIn(btreeID) {
+		return fmt.Errorf("cannot delete BTree %s: %w", btreeID, m.pins.leak(btreeID))
+	}
 	delete(m.btrees, btreeID)
 	delete(m.pages, btreeID)
 	delete(m.nextPageID, btreeID)
@@ -143,7 +203,7 @@ func (m *mockBufferManager) DeleteBTree(btreeID string) error {
 	// Clean up any buffer entries associated with this B-Tree
 	for pos, entry := range m.buffer {
 		if entry.btreeID == btreeID {
-			delete(m.buffer, pos)
+			m.drop(pos)
 		}
 	}
 	return nil
@@ -151,100 +211,226 @@ func (m *mockBufferManager) DeleteBTree(btreeID string) error {
 
 // CloseBTree closes an open BTree.
 func (m *mockBufferManager) CloseBTree(btreeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if _, exists := m.btrees[btreeID]; !exists {
 		return ErrBTreeNotFound
 	}
 
 	// Check for pinned pages.  In a real implementation, we would likely
 	// want to either return an error or force-flush pinned pages.
-	for _, entry := range m.buffer {
-		if entry.btreeID == btreeID && entry.pinned {
-			return fmt.Errorf("cannot close BTree %s: pages still pinned", btreeID)
-		}
+	if m.pinnedIn(btreeID) {
+		return fmt.Errorf("cannot close BTree %s: %w", btreeID, m.pins.leak(btreeID))
 	}
 
 	//Flush all the dirty pages before closing
 	for pos, entry := range m.buffer {
 		if entry.btreeID == btreeID {
-			if entry.dirty {
-				m.pages[entry.btreeID][entry.pageID] = entry.data
-			}
-			delete(m.buffer, pos)
+			m.writeBack(entry)
+			m.drop(pos)
 		}
 	}
 	return nil
 }
 
-// PinPage loads a page into the buffer pool and pins it.
-func (m *mockBufferManager) PinPage(btreeID string, pageID PageID) ([]byte, int, error) {
+// pinnedIn reports whether any page of btreeID is pinned.
+func (m *mockBufferManager) pinnedIn(btreeID string) bool {
+	for _, entry := range m.buffer {
+		if entry.btreeID == btreeID && entry.pinCount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// drop forgets the page at buffer position pos without writing it back.
+func (m *mockBufferManager) drop(pos int) {
+	entry := m.buffer[pos]
+	delete(m.pageTable, PageKey{BTreeID: entry.btreeID, PageID: entry.pageID})
+	delete(m.buffer, pos)
+	m.policy.Remove(pos)
+	m.waiters.notify()
+}
+
+// PinPage loads a page into the buffer pool and pins it. Pinning a page that
+// is already buffered shares its position and increments its pin count.
+// The latch of the pin is acquired before the buffer manager is locked, so
+// waiting for it does not hold up other goroutines, and all the pins of an
+// entry share one mode.
+func (m *mockBufferManager) PinPage(btreeID string, pageID PageID, mode PinMode) (PageHandle, error) {
+	m.pinLatches.LatchPage(btreeID, pageID, mode == PinExclusive)
+	m.mu.Lock()
+	h, err := m.pin(btreeID, pageID, mode)
+	m.mu.Unlock()
+	if err != nil {
+		m.pinLatches.UnlatchPage(btreeID, pageID, mode == PinExclusive)
+		return nil, err
+	}
+	m.pins.track(h)
+	return h, nil
+}
+
+// PinPageContext is PinPage that waits for a buffer position to become
+// free instead of failing with ErrBufferFull.
+func (m *mockBufferManager) PinPageContext(ctx context.Context, btreeID string, pageID PageID, mode PinMode) (PageHandle, error) {
+	h, err := pinContext(ctx, m, &m.mu, &m.pinLatches, &m.waiters, btreeID, pageID, mode)
+	if err != nil {
+		return nil, err
+	}
+	m.pins.track(h)
+	return h, nil
+}
+
+// PinnedPages returns the pins that have not been released yet, oldest
+// first. It returns nil unless the manager was created with WithPinTracking.
+func (m *mockBufferManager) PinnedPages() []PinInfo {
+	return m.pins.pinned(func(*pageHandle) bool { return true })
+}
+
+// pin implements PinPage once the latch is held and the manager is locked.
+func (m *mockBufferManager) pin(btreeID string, pageID PageID, mode PinMode) (*pageHandle, error) {
 	if _, exists := m.btrees[btreeID]; !exists {
-		return nil, 0, ErrBTreeNotFound
+		return nil, ErrBTreeNotFound
 	}
 
 	pageData, exists := m.pages[btreeID][pageID]
 	if !exists {
-		return nil, 0, ErrPageNotFound
+		return nil, ErrPageNotFound
+	}
+
+	key := PageKey{BTreeID: btreeID, PageID: pageID}
+	bufferPos, exists := m.pageTable[key]
+	if exists {
+		m.policy.Access(bufferPos)
+	} else {
+		var err error
+		if bufferPos, err = m.findBufferPos(); err != nil {
+			return nil, err
+		}
+		// The entry holds a copy, so changes reach the backing store only
+		// when the entry is written back, and a second copy to discard
+		// changes released without MarkDirty.
+		m.generation++
+		m.buffer[bufferPos] = bufferEntry{
+			btreeID:    btreeID,
+			pageID:     pageID,
+			data:       append(make([]byte, 0, PageSize), pageData...),
+			clean:      append(make([]byte, 0, PageSize), pageData...),
+			generation: m.generation,
+		}
+		m.pageTable[key] = bufferPos
+		m.policy.Insert(bufferPos, key)
 	}
 
-	// Find a free buffer position (simplified - no eviction yet)
-	bufferPos := -1
+	entry := m.buffer[bufferPos]
+	entry.pinCount++
+	entry.mode = mode
+	m.buffer[bufferPos] = entry
+	return &pageHandle{
+		manager:    m,
+		btreeID:    btreeID,
+		pageID:     pageID,
+		data:       entry.data,
+		pos:        bufferPos,
+		generation: entry.generation,
+		mode:       mode,
+	}, nil
+}
+
+// buffered reports whether a page is in the buffer.
+func (m *mockBufferManager) buffered(key PageKey) bool {
+	_, exists := m.pageTable[key]
+	return exists
+}
+
+// findBufferPos returns a free buffer position, evicting an unpinned page
+// chosen by the replacement policy if every position is taken.
+func (m *mockBufferManager) findBufferPos() (int, error) {
 	for i := 0; i < m.config.bufferSize; i++ {
 		if _, exists := m.buffer[i]; !exists {
-			bufferPos = i
-			break
+			return i, nil
 		}
 	}
-	if bufferPos == -1 {
-		return nil, 0, ErrBufferFull // Simplified - no eviction
-	}
 
-	m.buffer[bufferPos] = bufferEntry{
-		btreeID: btreeID,
-		pageID:  pageID,
-		data:    pageData,
-		pinned:  true,
-		dirty:   false,
+	victim, found := m.policy.Evict(func(pos int) bool {
+		return m.buffer[pos].pinCount == 0
+	})
+	if !found {
+		return 0, ErrBufferFull // Every page in the buffer is pinned
 	}
 
-	return pageData, bufferPos, nil
+	// Write back the victim before reusing its position.
+	entry := m.buffer[victim]
+	m.writeBack(entry)
+	delete(m.pageTable, PageKey{BTreeID: entry.btreeID, PageID: entry.pageID})
+	delete(m.buffer, victim)
+	return victim, nil
 }
 
-// UnpinPage marks a page as unpinned.
-func (m *mockBufferManager) UnpinPage(bufferPos int, dirty bool) error {
-	entry, exists := m.buffer[bufferPos]
-	if !exists {
-		return ErrPageNotFound
+// release drops the pin of h and releases its latch. The page becomes
+// evictable once its last pin is released.
+func (m *mockBufferManager) release(h *pageHandle) error {
+	if err := m.unpin(h); err != nil {
+		return err
 	}
+	m.pins.forget(h)
+	m.pinLatches.UnlatchPage(h.btreeID, h.pageID, h.mode == PinExclusive)
+	return nil
+}
 
-	if !entry.pinned {
-		return fmt.Errorf("page at buffer position %d is not pinned", bufferPos)
+// unpin implements release up to releasing the latch.
+func (m *mockBufferManager) unpin(h *pageHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, exists := m.buffer[h.pos]
+	if !exists || entry.generation != h.generation || entry.pinCount == 0 {
+		return ErrStaleHandle
 	}
 
-	entry.pinned = false
-	if dirty {
+	entry.pinCount--
+	if entry.pinCount == 0 {
+		m.waiters.notify()
+	}
+	// Only an exclusive pin may change the page, and no other pin of the
+	// entry reads it meanwhile, so its changes are kept or discarded here.
+	if h.dirty {
 		entry.dirty = true
-
-		m.pages[entry.btreeID][entry.pageID] = entry.data
+		copy(entry.clean, entry.data)
+	} else if h.mode == PinExclusive {
+		copy(entry.data, entry.clean)
 	}
-	m.buffer[bufferPos] = entry
+	m.buffer[h.pos] = entry
 
 	return nil
 }
 
+// writeBack copies the contents of entry to the backing store if they were
+// modified. Modifications released without MarkDirty were already
+// discarded by unpin.
+func (m *mockBufferManager) writeBack(entry bufferEntry) {
+	if entry.dirty {
+		copy(m.pages[entry.btreeID][entry.pageID], entry.data)
+	}
+}
+
 // AllocatePage creates a new page for a BTree.
 func (m *mockBufferManager) AllocatePage(btreeID string) (PageID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if _, exists := m.btrees[btreeID]; !exists {
 		return 0, ErrBTreeNotFound
 	}
 
 	pageID := m.nextPageID[btreeID]
 	m.nextPageID[btreeID]++
-	m.pages[btreeID][pageID] = make([]byte, 4096) // 4KB page size
+	m.pages[btreeID][pageID] = make([]byte, PageSize)
 	return pageID, nil
 }
 
 // FreePage marks a page as free.
 func (m *mockBufferManager) FreePage(btreeID string, pageID PageID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if _, exists := m.btrees[btreeID]; !exists {
 		return ErrBTreeNotFound
 	}
@@ -252,13 +438,13 @@ func (m *mockBufferManager) FreePage(btreeID string, pageID PageID) error {
 	if _, exists := m.pages[btreeID][pageID]; !exists {
 		return ErrPageNotFound
 	}
-
-	// Remove from buffer if present (important for consistency)
-	for pos, entry := range m.buffer {
-		if entry.btreeID == btreeID && entry.pageID == pageID {
-			delete(m.buffer, pos)
-			break
+	// Remove from buffer if present (important for consistency): a dirty
+	// copy left behind would bring the page back when it is evicted.
+	if pos, exists := m.pageTable[PageKey{BTreeID: btreeID, PageID: pageID}]; exists {
+		if m.buffer[pos].pinCount > 0 {
+			return fmt.Errorf("cannot free page %d of BTree %s: page is pinned", pageID, btreeID)
 		}
+		m.drop(pos)
 	}
 
 	delete(m.pages[btreeID], pageID)
@@ -2,7 +2,16 @@
 package buffermanager
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestBufferManager_CreateAndDeleteBTree(t *testing.T) {
@@ -99,7 +108,7 @@ func TestBufferManager_CloseBTree(t *testing.T) {
 		btreeID, _ := bm.CreateBTree()
 		pageID, _ := bm.AllocatePage(btreeID)
 
-		_, _, _ = bm.PinPage(btreeID, pageID)
+		_, _ = bm.PinPage(btreeID, pageID, PinShared)
 
 		err := bm.CloseBTree(btreeID)
 		if err == nil {
@@ -125,36 +134,41 @@ func TestBufferManager_PageOperations(t *testing.T) {
 
 	t.Run("PinPage", func(t *testing.T) {
 		pageID, _ := bm.AllocatePage(btreeID)
-		data, bufferPos, err := bm.PinPage(btreeID, pageID)
+		h, err := bm.PinPage(btreeID, pageID, PinShared)
 		if err != nil {
 			t.Fatalf("PinPage failed: %v", err)
 		}
-		if len(data) == 0 {
+		if len(h.Data()) == 0 {
 			t.Error("PinPage returned empty data")
 		}
-		if bufferPos < 0 {
-			t.Error("PinPage returned invalid buffer position")
+		if h.BTreeID() != btreeID || h.PageID() != pageID {
+			t.Errorf("Expected a handle of page %d of %s, got page %d of %s", pageID, btreeID, h.PageID(), h.BTreeID())
 		}
 	})
 
-	t.Run("UnpinPage", func(t *testing.T) {
+	t.Run("Release", func(t *testing.T) {
 		pageID, _ := bm.AllocatePage(btreeID)
-		_, bufferPos, _ := bm.PinPage(btreeID, pageID)
-		err := bm.UnpinPage(bufferPos, true)
+		h, _ := bm.PinPage(btreeID, pageID, PinExclusive)
+		h.MarkDirty()
+		err := h.Release()
 		if err != nil {
-			t.Fatalf("UnpinPage failed: %v", err)
+			t.Fatalf("Release failed: %v", err)
 		}
 	})
-	t.Run("Unpin Not pinned", func(t *testing.T) {
-		bufferPos := 1000
-		err := bm.UnpinPage(bufferPos, true)
-		if err == nil {
-			t.Fatalf("UnpinPage on unpinned buffer position didn't fail")
+	t.Run("Release twice", func(t *testing.T) {
+		pageID, _ := bm.AllocatePage(btreeID)
+		h, _ := bm.PinPage(btreeID, pageID, PinShared)
+		h.Release()
+		if err := h.Release(); !errors.Is(err, ErrStaleHandle) {
+			t.Fatalf("Expected ErrStaleHandle, got: %v", err)
+		}
+		if h.Data() != nil {
+			t.Error("Expected no data from a released handle")
 		}
 	})
 
 	t.Run("PinNonExistentPage", func(t *testing.T) {
-		_, _, err := bm.PinPage(btreeID, 999)
+		_, err := bm.PinPage(btreeID, 999, PinShared)
 		if err != ErrPageNotFound {
 			t.Fatalf("Expected ErrPageNotFound, got: %v", err)
 		}
@@ -167,7 +181,7 @@ func TestBufferManager_PageOperations(t *testing.T) {
 			t.Fatalf("FreePage failed: %v", err)
 		}
 
-		_, _, err = bm.PinPage(btreeID, pageID)
+		_, err = bm.PinPage(btreeID, pageID, PinShared)
 		if err != ErrPageNotFound {
 			t.Fatalf("Expected ErrPageNotFound after FreePage, got: %v", err)
 		}
@@ -200,7 +214,7 @@ func TestBufferManager_PageOperations(t *testing.T) {
 		pageID, _ := bm.AllocatePage(btreeID)
 		bm.DeleteBTree(btreeID)
 
-		_, _, err := bm.PinPage(btreeID, pageID)
+		_, err := bm.PinPage(btreeID, pageID, PinShared)
 		if err != ErrBTreeNotFound {
 			t.Fatalf("Expected ErrBTreeNotFound, got: %v", err)
 		}
@@ -225,3 +239,561 @@ func TestBufferManager_PageOperations(t *testing.T) {
 		}
 	})
 }
+
+func TestBufferManager_Eviction(t *testing.T) {
+	bm := NewMockBufferManager(WithBufferSize(2))
+	btreeID, _ := bm.CreateBTree()
+	pageA, _ := bm.AllocatePage(btreeID)
+	pageB, _ := bm.AllocatePage(btreeID)
+	pageC, _ := bm.AllocatePage(btreeID)
+
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		hA, _ := bm.PinPage(btreeID, pageA, PinShared)
+		hB, _ := bm.PinPage(btreeID, pageB, PinShared)
+		hB.Release()
+		hA.Release()
+
+		// Touch A again so B becomes the least recently used page.
+		hA, _ = bm.PinPage(btreeID, pageA, PinShared)
+		hA.Release()
+
+		hC, err := bm.PinPage(btreeID, pageC, PinShared)
+		if err != nil {
+			t.Fatalf("PinPage with unpinned pages in the buffer failed: %v", err)
+		}
+		for _, entry := range bm.buffer {
+			if entry.pageID == pageB {
+				t.Errorf("Expected page %d to be evicted", pageB)
+			}
+		}
+		hC.Release()
+	})
+
+	t.Run("DirtyPageWrittenBack", func(t *testing.T) {
+		h, _ := bm.PinPage(btreeID, pageB, PinExclusive)
+		h.Data()[0] = 42
+		h.MarkDirty()
+		h.Release()
+
+		// Cycle the other pages through the buffer to force B out.
+		for _, pageID := range []PageID{pageA, pageC, pageA} {
+			h, err := bm.PinPage(btreeID, pageID, PinShared)
+			if err != nil {
+				t.Fatalf("PinPage failed: %v", err)
+			}
+			h.Release()
+		}
+
+		h, _ = bm.PinPage(btreeID, pageB, PinShared)
+		if h.Data()[0] != 42 {
+			t.Errorf("Expected evicted dirty page to keep its changes, got %d", h.Data()[0])
+		}
+		h.Release()
+	})
+
+	t.Run("BufferFullWhenAllPinned", func(t *testing.T) {
+		h1, _ := bm.PinPage(btreeID, pageA, PinShared)
+		h2, _ := bm.PinPage(btreeID, pageB, PinShared)
+		if _, err := bm.PinPage(btreeID, pageC, PinShared); err != ErrBufferFull {
+			t.Errorf("Expected ErrBufferFull, got: %v", err)
+		}
+		h1.Release()
+		h2.Release()
+		if _, err := bm.PinPage(btreeID, pageC, PinShared); err != nil {
+			t.Errorf("Expected PinPage to succeed after unpinning, got: %v", err)
+		}
+	})
+}
+
+// TestBufferManager_WriteBack checks that the mock buffers copies of its
+// pages, which reach the backing store only when written back.
+func TestBufferManager_WriteBack(t *testing.T) {
+	bm := NewMockBufferManager(WithBufferSize(1))
+	btreeID, _ := bm.CreateBTree()
+	pageA, _ := bm.AllocatePage(btreeID)
+	pageB, _ := bm.AllocatePage(btreeID)
+	stored := func(pageID PageID) byte {
+		return bm.pages[btreeID][pageID][0]
+	}
+
+	t.Run("DirtyPageWrittenBackOnEviction", func(t *testing.T) {
+		h, _ := bm.PinPage(btreeID, pageA, PinExclusive)
+		h.Data()[0] = 42
+		if stored(pageA) != 0 {
+			t.Error("Expected a pinned page to leave the backing store unchanged")
+		}
+		h.MarkDirty()
+		h.Release()
+		if stored(pageA) != 0 {
+			t.Error("Expected a released dirty page to stay buffered until evicted")
+		}
+
+		h, _ = bm.PinPage(btreeID, pageB, PinShared) // Evicts A
+		h.Release()
+		if stored(pageA) != 42 {
+			t.Errorf("Expected the evicted page to be written back, got %d", stored(pageA))
+		}
+	})
+
+	t.Run("CleanPageDiscarded", func(t *testing.T) {
+		h, _ := bm.PinPage(btreeID, pageA, PinExclusive)
+		h.Data()[0] = 7
+		h.Release() // Not marked dirty
+
+		h, _ = bm.PinPage(btreeID, pageA, PinShared) // Still buffered
+		if h.Data()[0] != 42 {
+			t.Errorf("Expected a change not marked dirty to be discarded on release, got %d", h.Data()[0])
+		}
+		h.Release()
+
+		h, _ = bm.PinPage(btreeID, pageB, PinShared) // Evicts A
+		h.Release()
+		h, _ = bm.PinPage(btreeID, pageA, PinShared)
+		if h.Data()[0] != 42 {
+			t.Errorf("Expected a change not marked dirty to be discarded, got %d", h.Data()[0])
+		}
+		h.Release()
+	})
+
+	t.Run("CleanChangeNotWrittenBackByLaterDirtyRelease", func(t *testing.T) {
+		h, _ := bm.PinPage(btreeID, pageA, PinExclusive)
+		h.Data()[0] = 43
+		h.MarkDirty()
+		h.Release()
+		h, _ = bm.PinPage(btreeID, pageA, PinExclusive)
+		h.Data()[1] = 5
+		h.Release() // Not marked dirty
+		h, _ = bm.PinPage(btreeID, pageA, PinExclusive)
+		h.Data()[2] = 6
+		h.MarkDirty()
+		h.Release()
+
+		h, _ = bm.PinPage(btreeID, pageB, PinShared) // Evicts A
+		h.Release()
+		if page := bm.pages[btreeID][pageA]; page[0] != 43 || page[1] != 0 || page[2] != 6 {
+			t.Errorf("Expected only the changes marked dirty to be written back, got %v", page[:3])
+		}
+	})
+
+	t.Run("DirtyPageWrittenBackOnClose", func(t *testing.T) {
+		h, _ := bm.PinPage(btreeID, pageB, PinExclusive)
+		h.Data()[0] = 9
+		h.MarkDirty()
+		h.Release()
+		if err := bm.CloseBTree(btreeID); err != nil {
+			t.Fatalf("CloseBTree failed: %v", err)
+		}
+		if stored(pageB) != 9 {
+			t.Errorf("Expected CloseBTree to write back the dirty page, got %d", stored(pageB))
+		}
+	})
+}
+
+// bufferPos returns the buffer position of a page pinned by the mock.
+func bufferPos(h PageHandle) int {
+	return h.(*pageHandle).pos
+}
+
+// pinFromOtherGoroutine pins a page from a goroutine of its own, as a
+// goroutine must not pin a page it already holds.
+func pinFromOtherGoroutine(pin func() (PageHandle, error)) (PageHandle, error) {
+	type result struct {
+		h   PageHandle
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		h, err := pin()
+		done <- result{h, err}
+	}()
+	r := <-done
+	return r.h, r.err
+}
+
+func TestBufferManager_RepeatedPins(t *testing.T) {
+	bm := NewMockBufferManager(WithBufferSize(2))
+	btreeID, _ := bm.CreateBTree()
+	pageA, _ := bm.AllocatePage(btreeID)
+	pageB, _ := bm.AllocatePage(btreeID)
+	pageC, _ := bm.AllocatePage(btreeID)
+
+	hA1, _ := bm.PinPage(btreeID, pageA, PinExclusive)
+	hA1.Data()[0] = 7
+	hA1.MarkDirty()
+	if err := hA1.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+
+	// Test case 1: Pins of one page by two goroutines share its buffer
+	// position.
+	hA1, _ = bm.PinPage(btreeID, pageA, PinShared)
+	hA2, err := pinFromOtherGoroutine(func() (PageHandle, error) {
+		return bm.PinPage(btreeID, pageA, PinShared)
+	})
+	if err != nil {
+		t.Fatalf("PinPage failed: %v", err)
+	}
+	if bufferPos(hA1) != bufferPos(hA2) || len(bm.buffer) != 1 {
+		t.Fatalf("Expected both pins in one position, got %d and %d in %d positions", bufferPos(hA1), bufferPos(hA2), len(bm.buffer))
+	}
+	if entry := bm.buffer[bufferPos(hA1)]; entry.pinCount != 2 || !entry.dirty || hA2.Data()[0] != 7 {
+		t.Errorf("Expected 2 pins of a dirty page holding 7, got %d pins, dirty %v, holding %d", entry.pinCount, entry.dirty, hA2.Data()[0])
+	}
+
+	// Test case 2: The page stays pinned until both pins are released.
+	hB, _ := bm.PinPage(btreeID, pageB, PinShared)
+	hA1.Release()
+	if _, err := bm.PinPage(btreeID, pageC, PinShared); err != ErrBufferFull {
+		t.Errorf("Expected ErrBufferFull while page A keeps a pin, got: %v", err)
+	}
+	if err := hA1.Release(); !errors.Is(err, ErrStaleHandle) {
+		t.Errorf("Expected a second release of one pin to fail with ErrStaleHandle, got: %v", err)
+	}
+	hA2.Release()
+	hC, err := bm.PinPage(btreeID, pageC, PinShared)
+	if err != nil {
+		t.Fatalf("PinPage failed once page A was unpinned: %v", err)
+	}
+	if bufferPos(hC) != bufferPos(hA2) {
+		t.Errorf("Expected page C to take the position of page A, got %d", bufferPos(hC))
+	}
+
+	// Test case 3: A handle of the page that held the position before is
+	// stale, even if it was never released.
+	stale := *hA2.(*pageHandle)
+	stale.released = false
+	if err := stale.Release(); !errors.Is(err, ErrStaleHandle) {
+		t.Errorf("Expected ErrStaleHandle for a handle of an evicted page, got: %v", err)
+	}
+	if entry := bm.buffer[bufferPos(hC)]; entry.pinCount != 1 {
+		t.Errorf("Expected the stale release to leave page C pinned once, got %d pins", entry.pinCount)
+	}
+	hB.Release()
+	hC.Release()
+
+	// Test case 4: The evicted page was written back with the change.
+	h, _ := bm.PinPage(btreeID, pageA, PinShared)
+	if h.Data()[0] != 7 {
+		t.Errorf("Expected page A to hold 7 after eviction, got %d", h.Data()[0])
+	}
+	h.Release()
+}
+
+// forEachManager runs test against a mock and a file buffer manager.
+func forEachManager(t *testing.T, test func(t *testing.T, bm BufferManager), options ...Option) {
+	t.Run("Mock", func(t *testing.T) {
+		test(t, NewMockBufferManager(options...))
+	})
+	t.Run("File", func(t *testing.T) {
+		test(t, newTestFileBufferManager(t, t.TempDir(), options...))
+	})
+}
+
+// TestBufferManager_ConcurrentPins has goroutines increment counters stored
+// in a few shared pages under exclusive pins and read them under shared
+// ones, while others allocate pages. An increment lost to a concurrent one
+// shows up in the final sum. Run with -race.
+func TestBufferManager_ConcurrentPins(t *testing.T) {
+	const (
+		goroutines = 8
+		pages      = 16
+		iterations = 500
+	)
+	forEachManager(t, func(t *testing.T, bm BufferManager) {
+		btreeID, err := bm.CreateBTree()
+		if err != nil {
+			t.Fatalf("CreateBTree failed: %v", err)
+		}
+		pageIDs := make([]PageID, pages)
+		for i := range pageIDs {
+			if pageIDs[i], err = bm.AllocatePage(btreeID); err != nil {
+				t.Fatalf("AllocatePage failed: %v", err)
+			}
+		}
+
+		var wg sync.WaitGroup
+		increments := make([]int, goroutines)
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				rng := rand.New(rand.NewSource(int64(g)))
+				for i := 0; i < iterations; i++ {
+					pageID := pageIDs[rng.Intn(pages)]
+					switch op := rng.Intn(10); {
+					case op < 6:
+						h, err := bm.PinPage(btreeID, pageID, PinExclusive)
+						if err != nil {
+							t.Errorf("PinPage failed: %v", err)
+							return
+						}
+						count := binary.LittleEndian.Uint64(h.Data())
+						runtime.Gosched() // Invite a lost update
+						binary.LittleEndian.PutUint64(h.Data(), count+1)
+						h.MarkDirty()
+						if err := h.Release(); err != nil {
+							t.Errorf("Release failed: %v", err)
+							return
+						}
+						increments[g]++
+					case op < 9:
+						h, err := bm.PinPage(btreeID, pageID, PinShared)
+						if err != nil {
+							t.Errorf("PinPage failed: %v", err)
+							return
+						}
+						_ = binary.LittleEndian.Uint64(h.Data())
+						if err := h.Release(); err != nil {
+							t.Errorf("Release failed: %v", err)
+							return
+						}
+					default:
+						if _, err := bm.AllocatePage(btreeID); err != nil {
+							t.Errorf("AllocatePage failed: %v", err)
+							return
+						}
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		want := 0
+		for _, n := range increments {
+			want += n
+		}
+		got := 0
+		for _, pageID := range pageIDs {
+			h, err := bm.PinPage(btreeID, pageID, PinShared)
+			if err != nil {
+				t.Fatalf("PinPage failed: %v", err)
+			}
+			got += int(binary.LittleEndian.Uint64(h.Data()))
+			h.Release()
+		}
+		if got != want {
+			t.Errorf("Expected the counters to sum to %d, got %d", want, got)
+		}
+	}, WithBufferSize(8))
+}
+
+// TestBufferManager_PinModes checks that shared pins of a page coexist and
+// that an exclusive pin waits for them and keeps later pins waiting.
+func TestBufferManager_PinModes(t *testing.T) {
+	// pinAsync pins a page in another goroutine and delivers the handle once
+	// the pin succeeds.
+	pinAsync := func(t *testing.T, bm BufferManager, btreeID string, pageID PageID, mode PinMode) <-chan PageHandle {
+		pinned := make(chan PageHandle, 1)
+		go func() {
+			h, err := bm.PinPage(btreeID, pageID, mode)
+			if err != nil {
+				t.Errorf("PinPage failed: %v", err)
+			}
+			pinned <- h
+		}()
+		return pinned
+	}
+	expectBlocked := func(t *testing.T, pinned <-chan PageHandle) {
+		t.Helper()
+		select {
+		case <-pinned:
+			t.Fatal("Expected the pin to wait")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	forEachManager(t, func(t *testing.T, bm BufferManager) {
+		btreeID, _ := bm.CreateBTree()
+		pageID, _ := bm.AllocatePage(btreeID)
+
+		h1, err := bm.PinPage(btreeID, pageID, PinShared)
+		if err != nil {
+			t.Fatalf("PinPage failed: %v", err)
+		}
+		h2 := <-pinAsync(t, bm, btreeID, pageID, PinShared)
+
+		exclusive := pinAsync(t, bm, btreeID, pageID, PinExclusive)
+		expectBlocked(t, exclusive)
+		h2.MarkDirty()
+		if err := h2.Release(); err == nil {
+			t.Error("Expected Release to refuse a dirty page pinned shared")
+		}
+		expectBlocked(t, exclusive)
+		h1.Release()
+		h := <-exclusive
+
+		shared := pinAsync(t, bm, btreeID, pageID, PinShared)
+		expectBlocked(t, shared)
+		h.MarkDirty()
+		if err := h.Release(); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+		if err := (<-shared).Release(); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+	})
+}
+
+// queuedPinners returns the number of goroutines waiting in PinPageContext.
+func queuedPinners(bm BufferManager) int {
+	switch m := bm.(type) {
+	case *mockBufferManager:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.waiters.waiters)
+	case *fileBufferManager:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.waiters.waiters)
+	}
+	return 0
+}
+
+// TestBufferManager_PinPageContext checks that PinPageContext waits for a
+// full buffer to make room, gives up when its context is done and serves
+// waiting pinners in the order they arrived.
+func TestBufferManager_PinPageContext(t *testing.T) {
+	forEachManager(t, func(t *testing.T, bm BufferManager) {
+		btreeID, _ := bm.CreateBTree()
+		pageIDs := make([]PageID, 5)
+		for i := range pageIDs {
+			pageIDs[i], _ = bm.AllocatePage(btreeID)
+		}
+
+		h, err := bm.PinPage(btreeID, pageIDs[0], PinShared)
+		if err != nil {
+			t.Fatalf("PinPage failed: %v", err)
+		}
+		if _, err := bm.PinPage(btreeID, pageIDs[1], PinShared); !errors.Is(err, ErrBufferFull) {
+			t.Fatalf("Expected ErrBufferFull, got %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := bm.PinPageContext(ctx, btreeID, pageIDs[1], PinShared); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+
+		// A buffered page needs no room, so another goroutine pins it
+		// without waiting.
+		again, err := pinFromOtherGoroutine(func() (PageHandle, error) {
+			return bm.PinPageContext(context.Background(), btreeID, pageIDs[0], PinShared)
+		})
+		if err != nil {
+			t.Fatalf("PinPageContext failed: %v", err)
+		}
+		again.Release()
+
+		// Queue pinners of pages 1 to 4 in order; the pinner of page 2 gives
+		// up before any room is made.
+		var (
+			mu    sync.Mutex
+			order []PageID
+			wg    sync.WaitGroup
+		)
+		giveUp, cancelGiveUp := context.WithCancel(context.Background())
+		for i, pageID := range pageIDs[1:] {
+			ctx := context.Background()
+			if i == 1 {
+				ctx = giveUp
+			}
+			wg.Add(1)
+			go func(ctx context.Context, pageID PageID) {
+				defer wg.Done()
+				h, err := bm.PinPageContext(ctx, btreeID, pageID, PinShared)
+				if ctx == giveUp {
+					if !errors.Is(err, context.Canceled) {
+						t.Errorf("Expected context.Canceled, got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Errorf("PinPageContext failed: %v", err)
+					return
+				}
+				mu.Lock()
+				order = append(order, pageID)
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				h.Release()
+			}(ctx, pageID)
+			for queuedPinners(bm) != i+1 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		cancelGiveUp()
+		for queuedPinners(bm) != 3 {
+			time.Sleep(time.Millisecond)
+		}
+
+		h.Release()
+		wg.Wait()
+		want := []PageID{pageIDs[1], pageIDs[3], pageIDs[4]}
+		if !reflect.DeepEqual(order, want) {
+			t.Errorf("Expected pages pinned in order %v, got %v", want, order)
+		}
+	}, WithBufferSize(1))
+}
+
+// pinAndForget pins a page and never releases it.
+func pinAndForget(t *testing.T, bm BufferManager, btreeID string, pageID PageID, mode PinMode) PageHandle {
+	t.Helper()
+	h, err := bm.PinPage(btreeID, pageID, mode)
+	if err != nil {
+		t.Fatalf("PinPage failed: %v", err)
+	}
+	return h
+}
+
+// TestBufferManager_PinTracking checks that WithPinTracking reports who
+// holds the pins of a BTree that cannot be closed.
+func TestBufferManager_PinTracking(t *testing.T) {
+	if pins := NewMockBufferManager().PinnedPages(); pins != nil {
+		t.Errorf("Expected no pins to be tracked by default, got %v", pins)
+	}
+
+	forEachManager(t, func(t *testing.T, bm BufferManager) {
+		btreeID, _ := bm.CreateBTree()
+		page1, _ := bm.AllocatePage(btreeID)
+		page2, _ := bm.AllocatePage(btreeID)
+
+		h1 := pinAndForget(t, bm, btreeID, page1, PinShared)
+		h2 := pinAndForget(t, bm, btreeID, page2, PinExclusive)
+		pins := bm.(PinTracker).PinnedPages()
+		if len(pins) != 2 {
+			t.Fatalf("Expected 2 pins, got %d", len(pins))
+		}
+		for i, want := range []PinInfo{
+			{BTreeID: btreeID, PageID: page1, Mode: PinShared},
+			{BTreeID: btreeID, PageID: page2, Mode: PinExclusive},
+		} {
+			got := pins[i]
+			if got.BTreeID != want.BTreeID || got.PageID != want.PageID || got.Mode != want.Mode {
+				t.Errorf("Expected pin %d of page %d in mode %d, got page %d in mode %d", i, want.PageID, want.Mode, got.PageID, got.Mode)
+			}
+			if got.Time.IsZero() {
+				t.Errorf("Expected pin %d to record its time", i)
+			}
+			if !strings.Contains(got.Stack, "pinAndForget") {
+				t.Errorf("Expected the stack of pin %d to show pinAndForget, got:\n%s", i, got.Stack)
+			}
+		}
+
+		err := bm.CloseBTree(btreeID)
+		var leak *PinLeakError
+		if !errors.As(err, &leak) {
+			t.Fatalf("Expected a PinLeakError, got %v", err)
+		}
+		if len(leak.Pins) != 2 || !strings.Contains(err.Error(), "pinAndForget") {
+			t.Errorf("Expected the error to report both pins with their stacks, got:\n%v", err)
+		}
+
+		h1.Release()
+		if pins := bm.(PinTracker).PinnedPages(); len(pins) != 1 || pins[0].PageID != page2 {
+			t.Errorf("Expected only page %d to stay pinned, got %v", page2, pins)
+		}
+		h2.Release()
+		if err := bm.CloseBTree(btreeID); err != nil {
+			t.Errorf("CloseBTree failed: %v", err)
+		}
+	}, WithPinTracking())
+}
@@ -0,0 +1,53 @@
+// buffermanager/clock.go
+package buffermanager
+
+// clockPolicy approximates LRU with a reference bit per frame and a hand
+// sweeping over the frames, giving recently used frames a second chance.
+type clockPolicy struct {
+	tracked    []bool
+	referenced []bool
+	hand       int
+}
+
+// NewClockPolicy creates a clock (second chance) replacement policy.
+func NewClockPolicy(frames int) ReplacementPolicy {
+	return &clockPolicy{
+		tracked:    make([]bool, frames),
+		referenced: make([]bool, frames),
+	}
+}
+
+func (p *clockPolicy) Insert(frame int, _ PageKey) {
+	p.tracked[frame] = true
+	p.referenced[frame] = true
+}
+
+func (p *clockPolicy) Access(frame int) {
+	if p.tracked[frame] {
+		p.referenced[frame] = true
+	}
+}
+
+func (p *clockPolicy) Evict(evictable func(frame int) bool) (int, bool) {
+	// Two sweeps are enough to clear every reference bit once and then find
+	// a victim, if any frame is evictable at all.
+	for i := 0; i < 2*len(p.tracked); i++ {
+		frame := p.hand
+		p.hand = (p.hand + 1) % len(p.tracked)
+		if !p.tracked[frame] || !evictable(frame) {
+			continue
+		}
+		if p.referenced[frame] {
+			p.referenced[frame] = false
+			continue
+		}
+		p.Remove(frame)
+		return frame, true
+	}
+	return 0, false
+}
+
+func (p *clockPolicy) Remove(frame int) {
+	p.tracked[frame] = false
+	p.referenced[frame] = false
+}
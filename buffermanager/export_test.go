@@ -0,0 +1,20 @@
+// buffermanager/export_test.go
+package buffermanager
+
+// CrashAfter makes the write-ahead log of bm fail every write after n more
+// records, as a process killed at that point would. Records appended before
+// then stay in the file.
+func CrashAfter(bm BufferManager, n int) {
+	bm.(*fileBufferManager).wal.crashAfter = n
+}
+
+// RecordsLeft returns how many records the write-ahead log of bm may still
+// append before it crashes.
+func RecordsLeft(bm BufferManager) int {
+	return bm.(*fileBufferManager).wal.crashAfter
+}
+
+// Crash closes the files of bm without writing anything back.
+func Crash(bm BufferManager) {
+	crash(bm.(*fileBufferManager))
+}
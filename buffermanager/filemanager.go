@@ -0,0 +1,772 @@
+// buffermanager/filemanager.go
+package buffermanager
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// ErrNoTreeFactory is returned by OpenBTree when no TreeFactory was configured.
+var ErrNoTreeFactory = errors.New("no tree factory configured")
+
+// ErrOldFileFormat is returned when a BTree file was written in a format
+// this version no longer reads.
+var ErrOldFileFormat = errors.New("btree file was written in an older format")
+
+// TreeFactory builds the BTree that OpenBTree returns for btreeID, with its
+// pages served by bm. It lets page-based trees such as bplustree be plugged
+// into a buffer manager without this package importing them.
+type TreeFactory func(bm BufferManager, btreeID string) (btree.BTree, error)
+
+// WithTreeFactory specifies how OpenBTree builds trees over stored pages.
+func WithTreeFactory(factory TreeFactory) Option {
+	return func(config *bufferManagerConfig) {
+		config.treeFactory = factory
+	}
+}
+
+// On-disk layout of a BTree file. Page 0 holds the file header; page N is
+// stored at offset N*diskPageSize, preceded by the LSN of the last log record
+// applied to it. Freed pages form a singly linked list whose next pointers
+// occupy the first bytes of each free page.
+const (
+	fileExtension = ".btree"
+	btreeIDPrefix = "btree_"
+
+	pageHeaderSize = 8
+	diskPageSize   = pageHeaderSize + PageSize
+
+	fileMagic         uint64 = 0x6274726565666c32 // "btreefl2"
+	fileMagicV1       uint64 = 0x6274726565666c65 // "btreefle", pages without LSNs
+	freePageMagic     uint64 = 0x6672656570616765 // "freepage"
+	headerMagicOff           = 0
+	headerNextPageOff        = 8
+	headerFreeHeadOff        = 16
+	freePageNextOff          = 8
+)
+
+// btreeFile tracks the file and allocation state of a single BTree.
+type btreeFile struct {
+	path       string
+	file       *os.File // nil until the BTree is first used
+	loaded     bool     // Whether the allocation state below is current
+	nextPageID PageID
+	freeHead   PageID
+	free       map[PageID]bool
+}
+
+// frame is a slot in the buffer pool holding one page.
+type frame struct {
+	btreeID    string
+	pageID     PageID
+	data       []byte
+	pinCount   int
+	mode       PinMode // Mode of the pins taken through PinPage
+	dirty      bool
+	inUse      bool
+	generation uint64 // Incremented whenever the frame takes another page
+
+	// With a write-ahead log, logged holds the page as of its last log
+	// record, and pageLSN is the LSN of that record.
+	logged  []byte
+	pageLSN LSN
+}
+
+// fileBufferManager implements the BufferManager interface by storing each
+// BTree's pages in its own file under the configured directory.
+//
+// With a write-ahead log, every change to a page is logged when the page is
+// unpinned, and the page is never written to its BTree file before the log
+// records describing it are durable. Uncommitted changes may be written back
+// (the steal policy); recovery undoes them.
+//
+// The manager is safe for concurrent use, but Commit and Rollback cover every
+// change made since the last Commit, whichever BTree made it. A modification
+// running while another BTree commits or rolls back would be committed or
+// undone half done, so BTrees sharing the manager must not be modified
+// concurrently.
+type fileBufferManager struct {
+	pinLatches latchTable  // Latches held by pins
+	pins       *pinTracker // Pins recorded by WithPinTracking
+
+	mu          sync.Mutex // Guards the fields below
+	files       map[string]*btreeFile
+	btrees      map[string]btree.BTree // BTrees handed out by OpenBTree
+	frames      []frame
+	pageTable   map[PageKey]int
+	waiters     frameQueue // Pinners waiting for a frame
+	policy      ReplacementPolicy
+	nextBTreeID int
+	config      bufferManagerConfig
+
+	wal          *writeAheadLog          // nil unless WithWriteAheadLog is given
+	transactions map[uint64]*transaction // Active transaction table
+	activeTxn    *transaction            // Transaction of the changes since the last Commit
+	txn          *Txn                    // Open transaction started by Begin
+	nextTxnID    uint64
+	dirtyPages   map[PageKey]LSN // Dirty page table: recovery LSN of each dirty page
+}
+
+// NewFileBufferManager creates a buffer manager backed by files in the
+// configured directory. BTrees created by earlier runs are discovered from
+// the files already present.
+func NewFileBufferManager(options ...Option) (*fileBufferManager, error) {
+	config := bufferManagerConfig{
+		directory:  ".", // Default directory
+		bufferSize: 10,  // Default buffer size
+		policy:     NewLRUPolicy,
+	}
+
+	for _, option := range options {
+		option(&config)
+	}
+	if config.bufferSize <= 0 {
+		return nil, fmt.Errorf("invalid buffer size %d", config.bufferSize)
+	}
+
+	if err := os.MkdirAll(config.directory, 0o755); err != nil {
+		return nil, err
+	}
+
+	m := &fileBufferManager{
+		pins:        newPinTracker(config.trackPins),
+		files:       make(map[string]*btreeFile),
+		btrees:      make(map[string]btree.BTree),
+		frames:      make([]frame, config.bufferSize),
+		pageTable:   make(map[PageKey]int),
+		policy:      config.policy(config.bufferSize),
+		nextBTreeID: 1,
+		config:      config,
+
+		transactions: make(map[uint64]*transaction),
+		nextTxnID:    1,
+		dirtyPages:   make(map[PageKey]LSN),
+	}
+	for i := range m.frames {
+		m.frames[i].data = make([]byte, PageSize)
+		if config.writeAheadLog {
+			m.frames[i].logged = make([]byte, PageSize)
+		}
+	}
+
+	entries, err := os.ReadDir(config.directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, btreeIDPrefix) || !strings.HasSuffix(name, fileExtension) {
+			continue
+		}
+		btreeID := strings.TrimSuffix(name, fileExtension)
+		n, err := strconv.Atoi(strings.TrimPrefix(btreeID, btreeIDPrefix))
+		if err != nil {
+			continue
+		}
+		m.files[btreeID] = &btreeFile{path: filepath.Join(config.directory, name)}
+		if n >= m.nextBTreeID {
+			m.nextBTreeID = n + 1
+		}
+	}
+
+	if config.writeAheadLog {
+		wal, err := openWriteAheadLog(filepath.Join(config.directory, walFileName))
+		if err != nil {
+			return nil, err
+		}
+		m.wal = wal
+		if err := m.recover(); err != nil {
+			m.closeFiles()
+			wal.close()
+			return nil, fmt.Errorf("recovering from the write-ahead log: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// CreateBTree creates a new empty BTree file and returns its identifier.
+func (m *fileBufferManager) CreateBTree() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	btreeID := fmt.Sprintf("%s%d", btreeIDPrefix, m.nextBTreeID)
+	path := filepath.Join(m.config.directory, btreeID+fileExtension)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	f := &btreeFile{
+		path:       path,
+		file:       file,
+		loaded:     true,
+		nextPageID: 1,
+		free:       make(map[PageID]bool),
+	}
+	err = f.writePage(0, 0, f.header())
+	if err == nil && m.wal != nil {
+		// The header is not logged, so it must be durable before any
+		// record refers to the BTree.
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	m.nextBTreeID++
+	m.files[btreeID] = f
+	return btreeID, nil
+}
+
+// OpenBTree opens an existing BTree by its identifier using the configured
+// TreeFactory. Repeated calls return the same BTree until it is closed.
+func (m *fileBufferManager) OpenBTree(btreeID string) (btree.BTree, error) {
+	m.mu.Lock()
+	if b, exists := m.btrees[btreeID]; exists {
+		m.mu.Unlock()
+		return b, nil
+	}
+	_, err := m.openFile(btreeID)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if m.config.treeFactory == nil {
+		return nil, ErrNoTreeFactory
+	}
+
+	// The factory pins pages of the tree, so it runs unlocked. Of two
+	// goroutines opening the same BTree, the first to finish wins.
+	b, err := m.config.treeFactory(m, btreeID)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if opened, exists := m.btrees[btreeID]; exists {
+		return opened, nil
+	}
+	m.btrees[btreeID] = b
+	return b, nil
+}
+
+// DeleteBTree permanently removes a BTree and its file.
+func (m *fileBufferManager) DeleteBTree(btreeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, exists := m.files[btreeID]
+	if !exists {
+		return ErrBTreeNotFound
+	}
+	if m.pinnedIn(btreeID) {
+		return fmt.Errorf("cannot delete BTree %s: %w", btreeID, m.pins.leak(btreeID))
+	}
+
+	if err := m.commit(); err != nil {
+		return err
+	}
+
+	// Buffered pages are discarded without being written back. Log records
+	// of the BTree stay behind until the next checkpoint; recovery skips them
+	// because the file is gone, and empties the log before a new BTree can
+	// reuse the ID.
+	for pos := range m.frames {
+		if m.frames[pos].inUse && m.frames[pos].btreeID == btreeID {
+			m.dropFrame(pos)
+		}
+	}
+	if f.file != nil {
+		f.file.Close()
+	}
+	delete(m.files, btreeID)
+	delete(m.btrees, btreeID)
+	return os.Remove(f.path)
+}
+
+// CloseBTree writes back the BTree's dirty pages, releases its frames and
+// closes its file. The BTree can be opened again later.
+func (m *fileBufferManager) CloseBTree(btreeID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closeBTree(btreeID)
+}
+
+// closeBTree implements CloseBTree.
+func (m *fileBufferManager) closeBTree(btreeID string) error {
+	f, exists := m.files[btreeID]
+	if !exists {
+		return ErrBTreeNotFound
+	}
+
+	if m.pinnedIn(btreeID) {
+		return fmt.Errorf("cannot close BTree %s: %w", btreeID, m.pins.leak(btreeID))
+	}
+	if err := m.commit(); err != nil {
+		return err
+	}
+
+	for pos := range m.frames {
+		if m.frames[pos].inUse && m.frames[pos].btreeID == btreeID {
+			if err := m.flushFrame(pos); err != nil {
+				return err
+			}
+			m.dropFrame(pos)
+		}
+	}
+	delete(m.btrees, btreeID)
+
+	f.loaded = false
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Sync()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+	return err
+}
+
+// Close closes every open BTree, writing all dirty pages to disk, and closes
+// the write-ahead log. A Txn still open is rolled back.
+func (m *fileBufferManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.txn != nil {
+		if err := m.rollback(); err != nil {
+			return err
+		}
+	}
+	for btreeID := range m.files {
+		if err := m.closeBTree(btreeID); err != nil {
+			return err
+		}
+	}
+	if m.wal == nil {
+		return nil
+	}
+	// Every BTree file has been synced, so the log holds nothing needed.
+	err := m.wal.reset()
+	if closeErr := m.wal.close(); err == nil {
+		err = closeErr
+	}
+	m.wal = nil
+	return err
+}
+
+// pinnedIn reports whether any page of btreeID is pinned.
+func (m *fileBufferManager) pinnedIn(btreeID string) bool {
+	for _, fr := range m.frames {
+		if fr.inUse && fr.btreeID == btreeID && fr.pinCount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PinPage loads a page into the buffer pool and pins it. Pinning a page that
+// is already buffered returns the same frame and increments its pin count.
+// The latch of the pin is acquired before the manager is locked, so all the
+// pins of a frame share one mode.
+func (m *fileBufferManager) PinPage(btreeID string, pageID PageID, mode PinMode) (PageHandle, error) {
+	m.pinLatches.LatchPage(btreeID, pageID, mode == PinExclusive)
+	m.mu.Lock()
+	h, err := m.pin(btreeID, pageID, mode)
+	m.mu.Unlock()
+	if err != nil {
+		m.pinLatches.UnlatchPage(btreeID, pageID, mode == PinExclusive)
+		return nil, err
+	}
+	m.pins.track(h)
+	return h, nil
+}
+
+// PinPageContext is PinPage that waits for a frame to become free instead
+// of failing with ErrBufferFull.
+func (m *fileBufferManager) PinPageContext(ctx context.Context, btreeID string, pageID PageID, mode PinMode) (PageHandle, error) {
+	h, err := pinContext(ctx, m, &m.mu, &m.pinLatches, &m.waiters, btreeID, pageID, mode)
+	if err != nil {
+		return nil, err
+	}
+	m.pins.track(h)
+	return h, nil
+}
+
+// PinnedPages returns the pins that have not been released yet, oldest
+// first. It returns nil unless the manager was created with WithPinTracking.
+func (m *fileBufferManager) PinnedPages() []PinInfo {
+	return m.pins.pinned(func(*pageHandle) bool { return true })
+}
+
+// pin implements PinPage once the latch is held and the manager is locked.
+func (m *fileBufferManager) pin(btreeID string, pageID PageID, mode PinMode) (*pageHandle, error) {
+	f, err := m.openFile(btreeID)
+	if err != nil {
+		return nil, err
+	}
+	if !f.isAllocated(pageID) {
+		return nil, ErrPageNotFound
+	}
+	pos, err := m.fetch(btreeID, f, pageID)
+	if err != nil {
+		return nil, err
+	}
+	fr := &m.frames[pos]
+	fr.mode = mode
+	return &pageHandle{
+		manager:    m,
+		btreeID:    btreeID,
+		pageID:     pageID,
+		data:       fr.data,
+		pos:        pos,
+		generation: fr.generation,
+		mode:       mode,
+	}, nil
+}
+
+// buffered reports whether a page is in a frame.
+func (m *fileBufferManager) buffered(key PageKey) bool {
+	_, exists := m.pageTable[key]
+	return exists
+}
+
+// fetch pins a page of f, reading it into a frame if it is not buffered. It
+// is PinPage without the check that the page is allocated.
+func (m *fileBufferManager) fetch(btreeID string, f *btreeFile, pageID PageID) (int, error) {
+	key := PageKey{BTreeID: btreeID, PageID: pageID}
+	if pos, exists := m.pageTable[key]; exists {
+		m.frames[pos].pinCount++
+		m.policy.Access(pos)
+		return pos, nil
+	}
+
+	pos, err := m.findFrame()
+	if err != nil {
+		return 0, err
+	}
+	fr := &m.frames[pos]
+	lsn, err := f.readPage(pageID, fr.data)
+	if err != nil {
+		return 0, err
+	}
+	if fr.logged != nil {
+		copy(fr.logged, fr.data)
+	}
+	fr.btreeID = btreeID
+	fr.pageID = pageID
+	fr.pageLSN = lsn
+	fr.dirty = false
+	fr.pinCount = 1
+	fr.inUse = true
+	fr.generation++
+	m.pageTable[key] = pos
+	m.policy.Insert(pos, key)
+	return pos, nil
+}
+
+// release drops the pin of h and releases its latch.
+func (m *fileBufferManager) release(h *pageHandle) error {
+	m.mu.Lock()
+	fr := &m.frames[h.pos]
+	if !fr.inUse || fr.generation != h.generation || fr.pinCount == 0 {
+		m.mu.Unlock()
+		return ErrStaleHandle
+	}
+	err := m.unpin(h.pos, h.dirty)
+	m.mu.Unlock()
+	m.pins.forget(h)
+	m.pinLatches.UnlatchPage(h.btreeID, h.pageID, h.mode == PinExclusive)
+	return err
+}
+
+// unpin releases one pin on the frame at bufferPos, which must be pinned.
+func (m *fileBufferManager) unpin(bufferPos int, dirty bool) error {
+	fr := &m.frames[bufferPos]
+	fr.pinCount--
+	if fr.pinCount == 0 {
+		m.waiters.notify()
+	}
+	if !dirty {
+		return nil
+	}
+	if m.wal != nil {
+		return m.logUpdate(bufferPos)
+	}
+	fr.dirty = true
+	return nil
+}
+
+// AllocatePage creates a new zeroed page, reusing freed pages first.
+func (m *fileBufferManager) AllocatePage(btreeID string) (PageID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.openFile(btreeID)
+	if err != nil {
+		return 0, err
+	}
+
+	var pageID PageID
+	if f.freeHead != 0 {
+		pageID = f.freeHead
+		buf := make([]byte, PageSize)
+		if err := m.readPage(btreeID, f, pageID, buf); err != nil {
+			return 0, err
+		}
+		f.freeHead = PageID(binary.LittleEndian.Uint64(buf[freePageNextOff:]))
+		delete(f.free, pageID)
+	} else {
+		pageID = f.nextPageID
+		f.nextPageID++
+	}
+
+	if err := m.writePage(btreeID, f, pageID, make([]byte, PageSize)); err != nil {
+		return 0, err
+	}
+	return pageID, m.writePage(btreeID, f, 0, f.header())
+}
+
+// FreePage returns a page to the BTree's free list.
+func (m *fileBufferManager) FreePage(btreeID string, pageID PageID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.openFile(btreeID)
+	if err != nil {
+		return err
+	}
+	if !f.isAllocated(pageID) {
+		return ErrPageNotFound
+	}
+
+	if pos, exists := m.pageTable[PageKey{BTreeID: btreeID, PageID: pageID}]; exists {
+		if m.frames[pos].pinCount > 0 {
+			return fmt.Errorf("cannot free page %d of BTree %s: page is pinned", pageID, btreeID)
+		}
+		if m.wal == nil {
+			m.dropFrame(pos)
+		}
+	}
+
+	buf := make([]byte, PageSize)
+	binary.LittleEndian.PutUint64(buf, freePageMagic)
+	binary.LittleEndian.PutUint64(buf[freePageNextOff:], uint64(f.freeHead))
+	if err := m.writePage(btreeID, f, pageID, buf); err != nil {
+		return err
+	}
+	f.freeHead = pageID
+	f.free[pageID] = true
+	return m.writePage(btreeID, f, 0, f.header())
+}
+
+// readPage reads a page, preferring its buffered copy over the file.
+func (m *fileBufferManager) readPage(btreeID string, f *btreeFile, pageID PageID, buf []byte) error {
+	if pos, exists := m.pageTable[PageKey{BTreeID: btreeID, PageID: pageID}]; exists {
+		copy(buf, m.frames[pos].data)
+		return nil
+	}
+	_, err := f.readPage(pageID, buf)
+	return err
+}
+
+// writePage overwrites a page that is not pinned. Without a write-ahead log
+// it goes straight to the file; with one it is changed in the buffer pool
+// and logged like any other modification.
+func (m *fileBufferManager) writePage(btreeID string, f *btreeFile, pageID PageID, data []byte) error {
+	if m.wal == nil {
+		return f.writePage(pageID, 0, data)
+	}
+	pos, err := m.fetch(btreeID, f, pageID)
+	if err != nil {
+		return err
+	}
+	copy(m.frames[pos].data, data)
+	return m.unpin(pos, true)
+}
+
+// openFile returns the file state for btreeID, opening the file and loading
+// its header and free list on first use.
+func (m *fileBufferManager) openFile(btreeID string) (*btreeFile, error) {
+	f, err := m.attachFile(btreeID)
+	if err != nil || f.loaded {
+		return f, err
+	}
+	if err := m.load(btreeID, f); err != nil {
+		return nil, fmt.Errorf("loading BTree %s: %w", btreeID, err)
+	}
+	f.loaded = true
+	return f, nil
+}
+
+// attachFile returns the file state for btreeID with the file open, but
+// without loading the allocation state, which recovery may not have
+// restored yet.
+func (m *fileBufferManager) attachFile(btreeID string) (*btreeFile, error) {
+	f, exists := m.files[btreeID]
+	if !exists {
+		return nil, ErrBTreeNotFound
+	}
+	if f.file != nil {
+		return f, nil
+	}
+
+	file, err := os.OpenFile(f.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.file = file
+	return f, nil
+}
+
+// closeFiles closes every open BTree file without writing anything back.
+func (m *fileBufferManager) closeFiles() {
+	for _, f := range m.files {
+		if f.file != nil {
+			f.file.Close()
+			f.file = nil
+		}
+		f.loaded = false
+	}
+}
+
+// findFrame returns an empty frame, evicting an unpinned page chosen by the
+// replacement policy if needed.
+func (m *fileBufferManager) findFrame() (int, error) {
+	for pos := range m.frames {
+		if !m.frames[pos].inUse {
+			return pos, nil
+		}
+	}
+
+	pos, found := m.policy.Evict(func(pos int) bool {
+		return m.frames[pos].pinCount == 0
+	})
+	if !found {
+		return 0, ErrBufferFull
+	}
+	if err := m.flushFrame(pos); err != nil {
+		// Keep the page buffered and evictable so no change is lost.
+		fr := &m.frames[pos]
+		m.policy.Insert(pos, PageKey{BTreeID: fr.btreeID, PageID: fr.pageID})
+		return 0, err
+	}
+	m.dropFrame(pos)
+	return pos, nil
+}
+
+// flushFrame writes the frame back to its file if it is dirty. With a
+// write-ahead log, the log is first made durable up to the page's LSN.
+func (m *fileBufferManager) flushFrame(pos int) error {
+	fr := &m.frames[pos]
+	if !fr.dirty {
+		return nil
+	}
+	if m.wal != nil {
+		if err := m.wal.flush(fr.pageLSN); err != nil {
+			return err
+		}
+	}
+	if err := m.files[fr.btreeID].writePage(fr.pageID, fr.pageLSN, fr.data); err != nil {
+		return err
+	}
+	fr.dirty = false
+	delete(m.dirtyPages, PageKey{BTreeID: fr.btreeID, PageID: fr.pageID})
+	return nil
+}
+
+// dropFrame forgets the page held by the frame without writing it back.
+func (m *fileBufferManager) dropFrame(pos int) {
+	fr := &m.frames[pos]
+	key := PageKey{BTreeID: fr.btreeID, PageID: fr.pageID}
+	delete(m.pageTable, key)
+	delete(m.dirtyPages, key)
+	m.policy.Remove(pos)
+	fr.inUse = false
+	fr.pinCount = 0
+	fr.dirty = false
+	m.waiters.notify()
+}
+
+// load reads the header of f and rebuilds its set of free pages.
+func (m *fileBufferManager) load(btreeID string, f *btreeFile) error {
+	buf := make([]byte, PageSize)
+	err := m.readPage(btreeID, f, 0, buf)
+	if err != nil || binary.LittleEndian.Uint64(buf[headerMagicOff:]) != fileMagic {
+		if f.hasOldFormat() {
+			return fmt.Errorf("%w: %s has no page LSNs", ErrOldFileFormat, f.path)
+		}
+		if err != nil {
+			return err
+		}
+		return errors.New("not a btree file")
+	}
+	f.nextPageID = PageID(binary.LittleEndian.Uint64(buf[headerNextPageOff:]))
+	f.freeHead = PageID(binary.LittleEndian.Uint64(buf[headerFreeHeadOff:]))
+
+	f.free = make(map[PageID]bool)
+	for pageID := f.freeHead; pageID != 0; {
+		if pageID >= f.nextPageID || f.free[pageID] {
+			return fmt.Errorf("corrupt free list at page %d", pageID)
+		}
+		if err := m.readPage(btreeID, f, pageID, buf); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint64(buf) != freePageMagic {
+			return fmt.Errorf("corrupt free list at page %d", pageID)
+		}
+		f.free[pageID] = true
+		pageID = PageID(binary.LittleEndian.Uint64(buf[freePageNextOff:]))
+	}
+	return nil
+}
+
+// header returns the contents of page 0 for the current allocation state.
+func (f *btreeFile) header() []byte {
+	buf := make([]byte, PageSize)
+	binary.LittleEndian.PutUint64(buf[headerMagicOff:], fileMagic)
+	binary.LittleEndian.PutUint64(buf[headerNextPageOff:], uint64(f.nextPageID))
+	binary.LittleEndian.PutUint64(buf[headerFreeHeadOff:], uint64(f.freeHead))
+	return buf
+}
+
+// isAllocated reports whether pageID refers to a live page.
+func (f *btreeFile) isAllocated(pageID PageID) bool {
+	return pageID != 0 && pageID < f.nextPageID && !f.free[pageID]
+}
+
+// readPage reads a page and returns its LSN. A page past the end of the
+// file, allocated but never written back, reads as zeros.
+// hasOldFormat reports whether f starts with the header of the first file
+// format, which stored pages without LSNs.
+func (f *btreeFile) hasOldFormat() bool {
+	raw := make([]byte, 8)
+	_, err := f.file.ReadAt(raw, headerMagicOff)
+	return err == nil && binary.LittleEndian.Uint64(raw) == fileMagicV1
+}
+
+func (f *btreeFile) readPage(pageID PageID, buf []byte) (LSN, error) {
+	raw := make([]byte, diskPageSize)
+	n, err := f.file.ReadAt(raw, int64(pageID)*diskPageSize)
+	if err != nil && !(errors.Is(err, io.EOF) && n == 0) {
+		return 0, err
+	}
+	copy(buf[:PageSize], raw[pageHeaderSize:])
+	return LSN(binary.LittleEndian.Uint64(raw)), nil
+}
+
+func (f *btreeFile) writePage(pageID PageID, lsn LSN, data []byte) error {
+	raw := make([]byte, pageHeaderSize, diskPageSize)
+	binary.LittleEndian.PutUint64(raw, uint64(lsn))
+	raw = append(raw, data[:PageSize]...)
+	_, err := f.file.WriteAt(raw, int64(pageID)*diskPageSize)
+	return err
+}
@@ -0,0 +1,241 @@
+// buffermanager/filemanager_test.go
+package buffermanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+	"github.com/pillairaunak/btree-store-go/btree/inmemory"
+)
+
+func newTestFileBufferManager(t *testing.T, dir string, options ...Option) *fileBufferManager {
+	t.Helper()
+	options = append([]Option{WithDirectory(dir)}, options...)
+	bm, err := NewFileBufferManager(options...)
+	if err != nil {
+		t.Fatalf("NewFileBufferManager failed: %v", err)
+	}
+	return bm
+}
+
+// writePage pins a page, fills it with fill and unpins it dirty.
+func writePage(t *testing.T, bm BufferManager, btreeID string, pageID PageID, fill byte) {
+	t.Helper()
+	h, err := bm.PinPage(btreeID, pageID, PinExclusive)
+	if err != nil {
+		t.Fatalf("PinPage(%d) failed: %v", pageID, err)
+	}
+	data := h.Data()
+	for i := range data {
+		data[i] = fill
+	}
+	h.MarkDirty()
+	if err := h.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+}
+
+// expectPage pins a page and checks every byte equals fill.
+func expectPage(t *testing.T, bm BufferManager, btreeID string, pageID PageID, fill byte) {
+	t.Helper()
+	h, err := bm.PinPage(btreeID, pageID, PinShared)
+	if err != nil {
+		t.Fatalf("PinPage(%d) failed: %v", pageID, err)
+	}
+	defer h.Release()
+	if !bytes.Equal(h.Data(), bytes.Repeat([]byte{fill}, PageSize)) {
+		t.Errorf("Expected page %d to be filled with %d", pageID, fill)
+	}
+}
+
+func TestFileBufferManager_CreateAndDeleteBTree(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir)
+
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	path := filepath.Join(dir, btreeID+fileExtension)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected BTree file %s to exist: %v", path, err)
+	}
+
+	if err := bm.DeleteBTree(btreeID); err != nil {
+		t.Fatalf("DeleteBTree failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected BTree file to be removed, got: %v", err)
+	}
+	if err := bm.DeleteBTree(btreeID); err != ErrBTreeNotFound {
+		t.Errorf("Expected ErrBTreeNotFound, got: %v", err)
+	}
+	if _, err := bm.PinPage(btreeID, 1, PinShared); err != ErrBTreeNotFound {
+		t.Errorf("Expected ErrBTreeNotFound, got: %v", err)
+	}
+}
+
+func TestFileBufferManager_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir)
+	btreeID, _ := bm.CreateBTree()
+
+	var pageIDs []PageID
+	for i := 0; i < 5; i++ {
+		pageID, err := bm.AllocatePage(btreeID)
+		if err != nil {
+			t.Fatalf("AllocatePage failed: %v", err)
+		}
+		writePage(t, bm, btreeID, pageID, byte(i+1))
+		pageIDs = append(pageIDs, pageID)
+	}
+	if err := bm.FreePage(btreeID, pageIDs[2]); err != nil {
+		t.Fatalf("FreePage failed: %v", err)
+	}
+	if err := bm.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A new manager over the same directory sees the previous run's state.
+	bm = newTestFileBufferManager(t, dir)
+	for i, pageID := range pageIDs {
+		if i == 2 {
+			if _, err := bm.PinPage(btreeID, pageID, PinShared); err != ErrPageNotFound {
+				t.Errorf("Expected ErrPageNotFound for freed page, got: %v", err)
+			}
+			continue
+		}
+		expectPage(t, bm, btreeID, pageID, byte(i+1))
+	}
+
+	t.Run("FreedPageIsReused", func(t *testing.T) {
+		pageID, err := bm.AllocatePage(btreeID)
+		if err != nil {
+			t.Fatalf("AllocatePage failed: %v", err)
+		}
+		if pageID != pageIDs[2] {
+			t.Errorf("Expected freed page %d to be reused, got %d", pageIDs[2], pageID)
+		}
+		expectPage(t, bm, btreeID, pageID, 0)
+	})
+
+	t.Run("NewIDsDoNotCollide", func(t *testing.T) {
+		newID, err := bm.CreateBTree()
+		if err != nil {
+			t.Fatalf("CreateBTree failed: %v", err)
+		}
+		if newID == btreeID {
+			t.Errorf("Expected a fresh BTree ID, got existing %s", newID)
+		}
+	})
+}
+
+func TestFileBufferManager_Eviction(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir, WithBufferSize(2))
+	btreeID, _ := bm.CreateBTree()
+
+	var pageIDs []PageID
+	for i := 0; i < 6; i++ {
+		pageID, _ := bm.AllocatePage(btreeID)
+		writePage(t, bm, btreeID, pageID, byte(i+1))
+		pageIDs = append(pageIDs, pageID)
+	}
+
+	// Every page was evicted at least once, so these reads come from disk.
+	for i, pageID := range pageIDs {
+		expectPage(t, bm, btreeID, pageID, byte(i+1))
+	}
+
+	t.Run("BufferFullWhenAllPinned", func(t *testing.T) {
+		h1, _ := bm.PinPage(btreeID, pageIDs[0], PinShared)
+		h2, _ := bm.PinPage(btreeID, pageIDs[1], PinShared)
+		if _, err := bm.PinPage(btreeID, pageIDs[2], PinShared); err != ErrBufferFull {
+			t.Errorf("Expected ErrBufferFull, got: %v", err)
+		}
+		h1.Release()
+		h2.Release()
+	})
+
+	t.Run("RepeatedPinSharesFrame", func(t *testing.T) {
+		h1, _ := bm.PinPage(btreeID, pageIDs[3], PinShared)
+		h2, err := pinFromOtherGoroutine(func() (PageHandle, error) {
+			return bm.PinPage(btreeID, pageIDs[3], PinShared)
+		})
+		if err != nil {
+			t.Fatalf("PinPage failed: %v", err)
+		}
+		if &h1.Data()[0] != &h2.Data()[0] {
+			t.Error("Expected both pins to share a frame")
+		}
+		h1.Release()
+		if err := bm.CloseBTree(btreeID); err == nil {
+			t.Error("Expected an error when closing BTree with pinned pages")
+		}
+		h2.Release()
+		if err := bm.CloseBTree(btreeID); err != nil {
+			t.Errorf("CloseBTree failed: %v", err)
+		}
+	})
+}
+
+func TestFileBufferManager_OpenBTree(t *testing.T) {
+	t.Run("WithoutFactory", func(t *testing.T) {
+		bm := newTestFileBufferManager(t, t.TempDir())
+		btreeID, _ := bm.CreateBTree()
+		if _, err := bm.OpenBTree(btreeID); err != ErrNoTreeFactory {
+			t.Errorf("Expected ErrNoTreeFactory, got: %v", err)
+		}
+	})
+
+	t.Run("WithFactory", func(t *testing.T) {
+		calls := 0
+		factory := func(bm BufferManager, btreeID string) (btree.BTree, error) {
+			calls++
+			return inmemory.NewInMemoryBTree(), nil
+		}
+		dir := t.TempDir()
+		btreeID, _ := newTestFileBufferManager(t, dir).CreateBTree()
+		bm := newTestFileBufferManager(t, dir, WithTreeFactory(factory))
+
+		if _, err := bm.OpenBTree("nonexistent"); err != ErrBTreeNotFound {
+			t.Errorf("Expected ErrBTreeNotFound, got: %v", err)
+		}
+		// The tree was created by another manager over the same directory.
+		first, err := bm.OpenBTree(btreeID)
+		if err != nil {
+			t.Fatalf("OpenBTree failed: %v", err)
+		}
+		second, _ := bm.OpenBTree(btreeID)
+		if first != second || calls != 1 {
+			t.Errorf("Expected OpenBTree to reuse the open tree, factory called %d times", calls)
+		}
+
+		bm.CloseBTree(btreeID)
+		bm.OpenBTree(btreeID)
+		if calls != 2 {
+			t.Errorf("Expected OpenBTree after CloseBTree to rebuild the tree, factory called %d times", calls)
+		}
+	})
+}
+
+func TestFileBufferManager_OldFileFormat(t *testing.T) {
+	// A file of the first format: the header at offset 0 and no page LSNs.
+	dir := t.TempDir()
+	header := make([]byte, PageSize)
+	binary.LittleEndian.PutUint64(header[headerMagicOff:], fileMagicV1)
+	binary.LittleEndian.PutUint64(header[headerNextPageOff:], 1)
+	if err := os.WriteFile(filepath.Join(dir, "btree_1"+fileExtension), header, 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	bm := newTestFileBufferManager(t, dir)
+	if _, err := bm.AllocatePage("btree_1"); !errors.Is(err, ErrOldFileFormat) {
+		t.Errorf("Expected ErrOldFileFormat, got: %v", err)
+	}
+}
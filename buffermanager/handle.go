@@ -0,0 +1,78 @@
+// buffermanager/handle.go
+package buffermanager
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStaleHandle is returned when releasing a PageHandle that was already
+// released, or whose buffer slot has since been given to another page.
+var ErrStaleHandle = errors.New("page handle is stale or already released")
+
+// PageHandle is a pinned page. It stays valid until Release, after which Data
+// returns nil and a second Release fails with ErrStaleHandle. A handle is
+// meant to be used by one goroutine at a time.
+type PageHandle interface {
+	// BTreeID returns the identifier of the BTree the page belongs to.
+	BTreeID() string
+
+	// PageID returns the identifier of the page.
+	PageID() PageID
+
+	// Data returns the contents of the page.
+	Data() []byte
+
+	// MarkDirty records that the page was modified, which requires an
+	// exclusive pin. The change is kept when the handle is released.
+	MarkDirty()
+
+	// Release unpins the page, making it eligible for eviction once no
+	// other pin holds it, and releases the latch of the pin.
+	Release() error
+}
+
+// pageHandle implements PageHandle for the buffer managers of this package.
+// The generation of the buffer slot at pos when the page was pinned tells a
+// handle of the page apart from one of a page the slot held before or after.
+type pageHandle struct {
+	manager    handleReleaser
+	btreeID    string
+	pageID     PageID
+	data       []byte
+	pos        int
+	generation uint64
+	mode       PinMode
+	dirty      bool
+	released   bool
+}
+
+// handleReleaser is implemented by the buffer managers that hand out
+// pageHandles.
+type handleReleaser interface {
+	// release drops the pin of h, which has not been released before.
+	release(h *pageHandle) error
+}
+
+func (h *pageHandle) BTreeID() string { return h.btreeID }
+func (h *pageHandle) PageID() PageID  { return h.pageID }
+func (h *pageHandle) Data() []byte    { return h.data }
+func (h *pageHandle) MarkDirty()      { h.dirty = true }
+
+// Release unpins the page. A page marked dirty under a shared pin is
+// unpinned as if it were clean and reported as an error.
+func (h *pageHandle) Release() error {
+	if h.released {
+		return ErrStaleHandle
+	}
+	h.released = true
+	h.data = nil
+	if h.dirty && h.mode != PinExclusive {
+		h.dirty = false
+		if err := h.manager.release(h); err != nil {
+			return err
+		}
+		return fmt.Errorf("page %d of BTree %s was modified under a shared pin", h.pageID, h.btreeID)
+	}
+	return h.manager.release(h)
+}
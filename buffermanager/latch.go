@@ -0,0 +1,105 @@
+// buffermanager/latch.go
+package buffermanager
+
+import "sync"
+
+// Latcher is implemented by buffer managers that provide a read/write latch
+// for every page. Latches are separate from pins: a pin keeps a page in the
+// buffer pool, while a latch protects its contents from goroutines working on
+// the same page at the same time. A latch may be held while the page is not
+// pinned, and it outlives the eviction of the page.
+type Latcher interface {
+	// LatchPage blocks until it holds the latch of a page, shared if
+	// exclusive is false.
+	LatchPage(btreeID string, pageID PageID, exclusive bool)
+
+	// TryLatchPage acquires the latch of a page only if it can do so without
+	// waiting, and reports whether it did.
+	TryLatchPage(btreeID string, pageID PageID, exclusive bool) bool
+
+	// UnlatchPage releases a latch acquired in the same mode.
+	UnlatchPage(btreeID string, pageID PageID, exclusive bool)
+}
+
+// latchTable implements Latcher. Latches are created on first use and
+// dropped once no goroutine holds or waits for them, so the table only grows
+// with the number of pages in use. The zero value is ready to use.
+type latchTable struct {
+	mu      sync.Mutex
+	latches map[PageKey]*pageLatch
+}
+
+// pageLatch is the latch of one page.
+type pageLatch struct {
+	sync.RWMutex
+	users int // Goroutines holding or waiting for the latch
+}
+
+// acquire returns the latch of key, counting the caller as a user.
+func (lt *latchTable) acquire(key PageKey) *pageLatch {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.latches == nil {
+		lt.latches = make(map[PageKey]*pageLatch)
+	}
+	l, exists := lt.latches[key]
+	if !exists {
+		l = &pageLatch{}
+		lt.latches[key] = l
+	}
+	l.users++
+	return l
+}
+
+// release stops counting the caller as a user of the latch of key.
+func (lt *latchTable) release(key PageKey, l *pageLatch) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if l.users--; l.users == 0 {
+		delete(lt.latches, key)
+	}
+}
+
+// LatchPage blocks until it holds the latch of a page.
+func (lt *latchTable) LatchPage(btreeID string, pageID PageID, exclusive bool) {
+	l := lt.acquire(PageKey{BTreeID: btreeID, PageID: pageID})
+	if exclusive {
+		l.Lock()
+	} else {
+		l.RLock()
+	}
+}
+
+// TryLatchPage acquires the latch of a page if no waiting is needed.
+func (lt *latchTable) TryLatchPage(btreeID string, pageID PageID, exclusive bool) bool {
+	key := PageKey{BTreeID: btreeID, PageID: pageID}
+	l := lt.acquire(key)
+	var ok bool
+	if exclusive {
+		ok = l.TryLock()
+	} else {
+		ok = l.TryRLock()
+	}
+	if !ok {
+		lt.release(key, l)
+	}
+	return ok
+}
+
+// UnlatchPage releases the latch of a page. It panics if the latch is not
+// held, like unlocking an unlocked mutex.
+func (lt *latchTable) UnlatchPage(btreeID string, pageID PageID, exclusive bool) {
+	key := PageKey{BTreeID: btreeID, PageID: pageID}
+	lt.mu.Lock()
+	l, exists := lt.latches[key]
+	lt.mu.Unlock()
+	if !exists {
+		panic("buffermanager: unlatch of a page that is not latched")
+	}
+	if exclusive {
+		l.Unlock()
+	} else {
+		l.RUnlock()
+	}
+	lt.release(key, l)
+}
@@ -0,0 +1,63 @@
+// buffermanager/latch_test.go
+package buffermanager
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLatchTable(t *testing.T) {
+	var lt latchTable
+
+	// Test case 1: Shared latches coexist and keep out an exclusive one.
+	lt.LatchPage("b", 1, false)
+	if !lt.TryLatchPage("b", 1, false) {
+		t.Error("Expected a second shared latch to be granted")
+	}
+	if lt.TryLatchPage("b", 1, true) {
+		t.Error("Expected an exclusive latch to be refused while shared ones are held")
+	}
+	lt.UnlatchPage("b", 1, false)
+	lt.UnlatchPage("b", 1, false)
+
+	// Test case 2: An exclusive latch blocks others until released, and
+	// latches of other pages are independent.
+	lt.LatchPage("b", 1, true)
+	if !lt.TryLatchPage("b", 2, true) || !lt.TryLatchPage("c", 1, true) {
+		t.Error("Expected latches of other pages to be free")
+	}
+	lt.UnlatchPage("b", 2, true)
+	lt.UnlatchPage("c", 1, true)
+	acquired := make(chan struct{})
+	go func() {
+		lt.LatchPage("b", 1, false)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected the shared latch to wait for the exclusive one")
+	case <-time.After(20 * time.Millisecond):
+	}
+	lt.UnlatchPage("b", 1, true)
+	<-acquired
+	lt.UnlatchPage("b", 1, false)
+
+	// Test case 3: Unused latches are dropped.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				exclusive := (i+j)%3 == 0
+				lt.LatchPage("b", PageID(j%4), exclusive)
+				lt.UnlatchPage("b", PageID(j%4), exclusive)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(lt.latches) != 0 {
+		t.Errorf("Expected no latches left, got %d", len(lt.latches))
+	}
+}
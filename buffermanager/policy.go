@@ -0,0 +1,169 @@
+// buffermanager/policy.go
+package buffermanager
+
+import "container/list"
+
+// PageKey identifies a page across all BTrees of a buffer manager.
+type PageKey struct {
+	BTreeID string
+	PageID  PageID
+}
+
+// ReplacementPolicy decides which buffer frame to reuse when the pool is
+// full. Frames are identified by their buffer position; the page key given
+// on Insert lets a policy remember pages after they have been evicted.
+type ReplacementPolicy interface {
+	// Insert records that page was loaded into frame after a miss.
+	Insert(frame int, page PageKey)
+
+	// Access records a hit on a frame that is already tracked.
+	Access(frame int)
+
+	// Evict picks a frame accepted by evictable, stops tracking it and
+	// returns it. It returns false if no tracked frame is evictable.
+	Evict(evictable func(frame int) bool) (int, bool)
+
+	// Remove stops tracking frame without treating it as an eviction, for
+	// example when its page is freed or its BTree is closed.
+	Remove(frame int)
+}
+
+// PolicyFactory creates a ReplacementPolicy for a pool with the given number
+// of frames.
+type PolicyFactory func(frames int) ReplacementPolicy
+
+// WithReplacementPolicy specifies the eviction policy of the buffer pool.
+// The default is NewLRUPolicy.
+func WithReplacementPolicy(factory PolicyFactory) Option {
+	return func(config *bufferManagerConfig) {
+		config.policy = factory
+	}
+}
+
+// lruPolicy evicts the least recently used frame.
+type lruPolicy struct {
+	frames *frameList
+}
+
+// NewLRUPolicy creates a least recently used replacement policy.
+func NewLRUPolicy(frames int) ReplacementPolicy {
+	return &lruPolicy{frames: newFrameList()}
+}
+
+func (p *lruPolicy) Insert(frame int, _ PageKey) {
+	p.frames.pushBack(frame)
+}
+
+func (p *lruPolicy) Access(frame int) {
+	p.frames.moveToBack(frame)
+}
+
+func (p *lruPolicy) Evict(evictable func(frame int) bool) (int, bool) {
+	frame, found := p.frames.oldestEvictable(evictable)
+	if found {
+		p.frames.remove(frame)
+	}
+	return frame, found
+}
+
+func (p *lruPolicy) Remove(frame int) {
+	p.frames.remove(frame)
+}
+
+// frameList is an ordered set of frames, oldest first.
+type frameList struct {
+	order    *list.List
+	elements map[int]*list.Element
+}
+
+func newFrameList() *frameList {
+	return &frameList{
+		order:    list.New(),
+		elements: make(map[int]*list.Element),
+	}
+}
+
+func (l *frameList) len() int {
+	return l.order.Len()
+}
+
+func (l *frameList) contains(frame int) bool {
+	_, exists := l.elements[frame]
+	return exists
+}
+
+// pushBack adds frame as the newest entry, moving it if already present.
+func (l *frameList) pushBack(frame int) {
+	if !l.moveToBack(frame) {
+		l.elements[frame] = l.order.PushBack(frame)
+	}
+}
+
+// moveToBack makes frame the newest entry. It reports whether frame was present.
+func (l *frameList) moveToBack(frame int) bool {
+	e, exists := l.elements[frame]
+	if exists {
+		l.order.MoveToBack(e)
+	}
+	return exists
+}
+
+// remove deletes frame. It reports whether frame was present.
+func (l *frameList) remove(frame int) bool {
+	e, exists := l.elements[frame]
+	if exists {
+		l.order.Remove(e)
+		delete(l.elements, frame)
+	}
+	return exists
+}
+
+// oldestEvictable returns the oldest frame accepted by evictable.
+func (l *frameList) oldestEvictable(evictable func(frame int) bool) (int, bool) {
+	for e := l.order.Front(); e != nil; e = e.Next() {
+		if frame := e.Value.(int); evictable(frame) {
+			return frame, true
+		}
+	}
+	return 0, false
+}
+
+// ghostList remembers the keys of evicted pages, oldest first.
+type ghostList struct {
+	order    *list.List
+	elements map[PageKey]*list.Element
+}
+
+func newGhostList() *ghostList {
+	return &ghostList{
+		order:    list.New(),
+		elements: make(map[PageKey]*list.Element),
+	}
+}
+
+func (g *ghostList) len() int {
+	return g.order.Len()
+}
+
+func (g *ghostList) contains(key PageKey) bool {
+	_, exists := g.elements[key]
+	return exists
+}
+
+func (g *ghostList) pushBack(key PageKey) {
+	g.remove(key)
+	g.elements[key] = g.order.PushBack(key)
+}
+
+func (g *ghostList) remove(key PageKey) {
+	if e, exists := g.elements[key]; exists {
+		g.order.Remove(e)
+		delete(g.elements, key)
+	}
+}
+
+func (g *ghostList) removeOldest() {
+	if e := g.order.Front(); e != nil {
+		g.remove(e.Value.(PageKey))
+	}
+}
@@ -0,0 +1,217 @@
+// buffermanager/policy_test.go
+package buffermanager
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var testPolicies = []struct {
+	name    string
+	factory PolicyFactory
+}{
+	{"LRU", NewLRUPolicy},
+	{"Clock", NewClockPolicy},
+	{"2Q", NewTwoQueuePolicy},
+	{"ARC", NewARCPolicy},
+}
+
+func pageKey(pageID int) PageKey {
+	return PageKey{BTreeID: "btree_1", PageID: PageID(pageID)}
+}
+
+func evictAny(int) bool { return true }
+
+// simulatePool replays trace against a pool of the given number of frames
+// managed by policy and returns the fraction of requests that were hits.
+func simulatePool(policy ReplacementPolicy, frames int, trace []PageKey) float64 {
+	resident := make(map[PageKey]int)
+	owner := make([]PageKey, frames)
+	free := frames
+	hits := 0
+
+	for _, key := range trace {
+		if frame, exists := resident[key]; exists {
+			policy.Access(frame)
+			hits++
+			continue
+		}
+
+		var frame int
+		if free > 0 {
+			free--
+			frame = free
+		} else {
+			victim, found := policy.Evict(evictAny)
+			if !found {
+				panic("policy found no victim in a pool without pins")
+			}
+			delete(resident, owner[victim])
+			frame = victim
+		}
+		owner[frame] = key
+		resident[key] = frame
+		policy.Insert(frame, key)
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+// lookupTrace models point lookups with a skewed popularity distribution.
+func lookupTrace() []PageKey {
+	rng := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rng, 1.1, 1, 4999)
+	trace := make([]PageKey, 50000)
+	for i := range trace {
+		trace[i] = pageKey(int(zipf.Uint64()))
+	}
+	return trace
+}
+
+// scanTrace interleaves the point lookups of lookupTrace with long
+// sequential scans over pages that are rarely revisited.
+func scanTrace() []PageKey {
+	rng := rand.New(rand.NewSource(2))
+	zipf := rand.NewZipf(rng, 1.1, 1, 4999)
+	var trace []PageKey
+	for len(trace) < 50000 {
+		for i := 0; i < 500; i++ {
+			trace = append(trace, pageKey(int(zipf.Uint64())))
+		}
+		start := 10000 + rng.Intn(100000)
+		for i := 0; i < 1000; i++ {
+			trace = append(trace, pageKey(start+i))
+		}
+	}
+	return trace
+}
+
+func TestReplacementPolicies(t *testing.T) {
+	for _, p := range testPolicies {
+		p := p
+		t.Run(p.name, func(t *testing.T) {
+			t.Run("EvictsEveryFrameOnce", func(t *testing.T) {
+				policy := p.factory(4)
+				for frame := 0; frame < 4; frame++ {
+					policy.Insert(frame, pageKey(frame))
+				}
+				seen := make(map[int]bool)
+				for i := 0; i < 4; i++ {
+					frame, found := policy.Evict(evictAny)
+					if !found || seen[frame] {
+						t.Fatalf("Expected a new victim, got (%d, %v)", frame, found)
+					}
+					seen[frame] = true
+				}
+				if _, found := policy.Evict(evictAny); found {
+					t.Error("Expected no victim once every frame was evicted")
+				}
+			})
+
+			t.Run("RespectsEvictable", func(t *testing.T) {
+				policy := p.factory(4)
+				for frame := 0; frame < 4; frame++ {
+					policy.Insert(frame, pageKey(frame))
+				}
+				frame, found := policy.Evict(func(frame int) bool { return frame == 2 })
+				if !found || frame != 2 {
+					t.Errorf("Expected frame 2 to be the only candidate, got (%d, %v)", frame, found)
+				}
+				if _, found := policy.Evict(func(int) bool { return false }); found {
+					t.Error("Expected no victim when every frame is pinned")
+				}
+			})
+
+			t.Run("RemovedFrameNotEvicted", func(t *testing.T) {
+				policy := p.factory(2)
+				policy.Insert(0, pageKey(0))
+				policy.Insert(1, pageKey(1))
+				policy.Remove(0)
+				if frame, found := policy.Evict(evictAny); !found || frame != 1 {
+					t.Errorf("Expected frame 1 to be evicted, got (%d, %v)", frame, found)
+				}
+			})
+		})
+	}
+}
+
+func TestLRUPolicy_Order(t *testing.T) {
+	policy := NewLRUPolicy(3)
+	for frame := 0; frame < 3; frame++ {
+		policy.Insert(frame, pageKey(frame))
+	}
+	policy.Access(0)
+	for _, expected := range []int{1, 2, 0} {
+		if frame, _ := policy.Evict(evictAny); frame != expected {
+			t.Errorf("Expected frame %d to be evicted, got %d", expected, frame)
+		}
+	}
+}
+
+func TestClockPolicy_SecondChance(t *testing.T) {
+	policy := NewClockPolicy(3)
+	for frame := 0; frame < 3; frame++ {
+		policy.Insert(frame, pageKey(frame))
+	}
+	// The first sweep clears every reference bit, so frame 0 goes first.
+	if frame, _ := policy.Evict(evictAny); frame != 0 {
+		t.Errorf("Expected frame 0 to be evicted, got %d", frame)
+	}
+	// Frame 1 was referenced again and gets a second chance over frame 2.
+	policy.Access(1)
+	if frame, _ := policy.Evict(evictAny); frame != 2 {
+		t.Errorf("Expected frame 2 to be evicted, got %d", frame)
+	}
+}
+
+func TestReplacementPolicies_ScanResistance(t *testing.T) {
+	trace := scanTrace()
+	lru := simulatePool(NewLRUPolicy(200), 200, trace)
+	for _, p := range testPolicies[2:] {
+		if rate := simulatePool(p.factory(200), 200, trace); rate <= lru {
+			t.Errorf("Expected %s hit rate %.3f to beat LRU %.3f on a scan-heavy trace", p.name, rate, lru)
+		}
+	}
+}
+
+func TestBufferManager_ReplacementPolicyOption(t *testing.T) {
+	for _, p := range testPolicies {
+		bm := NewMockBufferManager(WithBufferSize(2), WithReplacementPolicy(p.factory))
+		btreeID, _ := bm.CreateBTree()
+		for i := 0; i < 5; i++ {
+			pageID, _ := bm.AllocatePage(btreeID)
+			h, err := bm.PinPage(btreeID, pageID, PinShared)
+			if err != nil {
+				t.Fatalf("%s: PinPage failed: %v", p.name, err)
+			}
+			h.Release()
+		}
+	}
+}
+
+// BenchmarkReplacementPolicies reports the hit rate of each policy on a
+// lookup-heavy and a scan-heavy trace, e.g.
+//
+//	go test -run=^$ -bench=ReplacementPolicies ./buffermanager
+func BenchmarkReplacementPolicies(b *testing.B) {
+	const frames = 200
+	traces := []struct {
+		name  string
+		trace []PageKey
+	}{
+		{"LookupHeavy", lookupTrace()},
+		{"ScanHeavy", scanTrace()},
+	}
+
+	for _, tr := range traces {
+		for _, p := range testPolicies {
+			tr, p := tr, p
+			b.Run(tr.name+"/"+p.name, func(b *testing.B) {
+				var rate float64
+				for i := 0; i < b.N; i++ {
+					rate = simulatePool(p.factory(frames), frames, tr.trace)
+				}
+				b.ReportMetric(100*rate, "hit%")
+			})
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// buffermanager/recovery.go
+package buffermanager
+
+import "math"
+
+// recover brings the BTree files back to a consistent state after the
+// manager was stopped without Close. It follows ARIES: analysis rebuilds the
+// transaction and dirty page tables as of the crash, redo repeats history
+// from the oldest change that may be missing from a file, and undo rolls back
+// every transaction that did not commit. Everything is then written back and
+// the log emptied.
+func (m *fileBufferManager) recover() error {
+	dirtyPages, err := m.analyze()
+	if err != nil {
+		return err
+	}
+	if err := m.redo(dirtyPages); err != nil {
+		return err
+	}
+	if err := m.undo(m.transactions); err != nil {
+		return err
+	}
+
+	for pos := range m.frames {
+		if m.frames[pos].inUse {
+			if err := m.flushFrame(pos); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range m.files {
+		if f.file != nil {
+			if err := f.file.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return m.wal.reset()
+}
+
+// analyze scans the log from the last complete checkpoint, filling the
+// active transaction table with the transactions that neither committed nor
+// finished rolling back, and returns the dirty page table as of the crash.
+func (m *fileBufferManager) analyze() (map[PageKey]LSN, error) {
+	start := m.wal.checkpointLSN
+	if start == 0 {
+		start = m.wal.startLSN
+	}
+
+	dirtyPages := make(map[PageKey]LSN)
+	err := m.wal.scan(start, func(r *logRecord) error {
+		if r.txn >= m.nextTxnID {
+			m.nextTxnID = r.txn + 1
+		}
+		switch r.kind {
+		case recordUpdate, recordCompensation:
+			txn, exists := m.transactions[r.txn]
+			if !exists {
+				txn = &transaction{id: r.txn, firstLSN: r.lsn}
+				m.transactions[r.txn] = txn
+			}
+			txn.lastLSN = r.lsn
+			txn.undoNext = r.lsn
+			if _, exists := dirtyPages[r.page]; !exists {
+				dirtyPages[r.page] = r.lsn
+			}
+		case recordCommit, recordEnd:
+			delete(m.transactions, r.txn)
+		case recordEndCheckpoint:
+			// Entries already seen after the begin record are newer.
+			for id, lastLSN := range r.transactions {
+				if _, exists := m.transactions[id]; !exists {
+					m.transactions[id] = &transaction{id: id, lastLSN: lastLSN, undoNext: lastLSN}
+				}
+			}
+			for key, recLSN := range r.dirtyPages {
+				if _, exists := dirtyPages[key]; !exists {
+					dirtyPages[key] = recLSN
+				}
+			}
+		}
+		return nil
+	})
+	return dirtyPages, err
+}
+
+// redo reapplies every logged change, committed or not, that may be missing
+// from the BTree files: changes to pages in dirtyPages no older than the
+// page's recovery LSN, and newer than the LSN stored with the page.
+func (m *fileBufferManager) redo(dirtyPages map[PageKey]LSN) error {
+	if len(dirtyPages) == 0 {
+		return nil
+	}
+	redoLSN := LSN(math.MaxUint64)
+	for _, recLSN := range dirtyPages {
+		if recLSN < redoLSN {
+			redoLSN = recLSN
+		}
+	}
+
+	return m.wal.scan(redoLSN, func(r *logRecord) error {
+		if r.kind != recordUpdate && r.kind != recordCompensation {
+			return nil
+		}
+		if recLSN, dirty := dirtyPages[r.page]; !dirty || r.lsn < recLSN {
+			return nil
+		}
+		return m.apply(r.page, r.lsn, r.offset, r.after)
+	})
+}
+
+// undo rolls back the transactions in losers, always undoing the newest
+// remaining change first. Each undone update is logged as a compensation
+// record, so a crash during undo never undoes a change twice. A transaction
+// is removed from losers and the active transaction table once an end record
+// marks it finished.
+func (m *fileBufferManager) undo(losers map[uint64]*transaction) error {
+	for len(losers) > 0 {
+		var txn *transaction
+		for _, t := range losers {
+			if txn == nil || t.undoNext > txn.undoNext {
+				txn = t
+			}
+		}
+
+		if txn.undoNext == 0 {
+			if _, err := m.wal.append(&logRecord{kind: recordEnd, txn: txn.id, prevLSN: txn.lastLSN}); err != nil {
+				return err
+			}
+			delete(losers, txn.id)
+			delete(m.transactions, txn.id)
+			if m.activeTxn == txn {
+				m.activeTxn = nil
+			}
+			continue
+		}
+
+		r, _, err := m.wal.read(txn.undoNext)
+		if err != nil {
+			return err
+		}
+		switch r.kind {
+		case recordUpdate:
+			lsn, err := m.wal.append(&logRecord{
+				kind:     recordCompensation,
+				txn:      txn.id,
+				prevLSN:  txn.lastLSN,
+				page:     r.page,
+				offset:   r.offset,
+				after:    r.before,
+				undoNext: r.prevLSN,
+			})
+			if err != nil {
+				return err
+			}
+			txn.lastLSN = lsn
+			if err := m.apply(r.page, lsn, r.offset, r.before); err != nil {
+				return err
+			}
+			txn.undoNext = r.prevLSN
+		case recordCompensation:
+			// Everything up to the compensated update is already undone.
+			txn.undoNext = r.undoNext
+		default:
+			txn.undoNext = r.prevLSN
+		}
+	}
+	return nil
+}
+
+// apply writes data at offset into the page as the change made by the log
+// record at lsn, unless the page's LSN shows it already has the change.
+// Changes to pages of deleted BTrees are ignored.
+func (m *fileBufferManager) apply(key PageKey, lsn LSN, offset int, data []byte) error {
+	f, err := m.attachFile(key.BTreeID)
+	if err == ErrBTreeNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pos, err := m.fetch(key.BTreeID, f, key.PageID)
+	if err != nil {
+		return err
+	}
+
+	fr := &m.frames[pos]
+	if fr.pageLSN < lsn {
+		copy(fr.data[offset:], data)
+		copy(fr.logged[offset:], data)
+		m.markDirty(pos, lsn)
+	}
+	fr.pinCount--
+	return nil
+}
@@ -0,0 +1,137 @@
+// buffermanager/recovery_test.go
+package buffermanager_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree/bplustree"
+	"github.com/pillairaunak/btree-store-go/btree/btreetest"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// crashOp is one operation of the crash test workload.
+type crashOp struct {
+	delete bool
+	key    uint64
+	value  uint64
+}
+
+// crashWorkload returns a fixed mix of inserts and deletes over a small key
+// range, enough to split and merge nodes of a tree with four keys per node.
+func crashWorkload() []crashOp {
+	rng := rand.New(rand.NewSource(1))
+	ops := make([]crashOp, 200)
+	for i := range ops {
+		ops[i] = crashOp{delete: i%3 == 2, key: uint64(rng.Intn(60)), value: uint64(i)}
+	}
+	return ops
+}
+
+// applyOps returns the contents of a tree after ops.
+func applyOps(ops []crashOp) map[uint64]uint64 {
+	model := make(map[uint64]uint64)
+	for _, op := range ops {
+		if op.delete {
+			delete(model, op.key)
+		} else {
+			model[op.key] = op.value
+		}
+	}
+	return model
+}
+
+func openLogged(t *testing.T, dir string) buffermanager.BufferManager {
+	t.Helper()
+	bm, err := buffermanager.NewFileBufferManager(
+		buffermanager.WithDirectory(dir),
+		buffermanager.WithBufferSize(5),
+		buffermanager.WithWriteAheadLog(),
+	)
+	if err != nil {
+		t.Fatalf("NewFileBufferManager failed: %v", err)
+	}
+	return bm
+}
+
+// runUntilCrash runs ops against a new tree in dir whose log crashes after
+// crashAfter records, or never if crashAfter is negative. It returns the
+// number of operations that completed, -1 if creating the tree failed, and
+// the number of records appended.
+func runUntilCrash(t *testing.T, dir string, ops []crashOp, crashAfter int) (done, records int) {
+	bm := openLogged(t, dir)
+	budget := crashAfter
+	if budget < 0 {
+		budget = math.MaxInt32
+	}
+	buffermanager.CrashAfter(bm, budget)
+	defer buffermanager.Crash(bm)
+
+	btreeID, err := bm.CreateBTree()
+	if err != nil {
+		t.Fatalf("CreateBTree failed: %v", err)
+	}
+	tree, err := bplustree.New(bm, btreeID, bplustree.WithMaxKeys(4))
+	if err != nil {
+		return -1, budget - buffermanager.RecordsLeft(bm)
+	}
+	for i, op := range ops {
+		if op.delete {
+			_, err = tree.Delete(op.key)
+		} else {
+			err = tree.Insert(op.key, op.value)
+		}
+		if err != nil {
+			return i, budget - buffermanager.RecordsLeft(bm)
+		}
+	}
+	return len(ops), budget - buffermanager.RecordsLeft(bm)
+}
+
+func TestRecovery_CrashAtEveryLogRecord(t *testing.T) {
+	ops := crashWorkload()
+	_, total := runUntilCrash(t, t.TempDir(), ops, -1)
+	if total == 0 {
+		t.Fatal("Expected the workload to write log records")
+	}
+
+	// Kill the store after every prefix of the log. The operation that was
+	// running may or may not survive, but every earlier one must, and the
+	// tree must be intact either way.
+	for crashAfter := 0; crashAfter <= total; crashAfter++ {
+		t.Run(fmt.Sprintf("After%d", crashAfter), func(t *testing.T) {
+			dir := t.TempDir()
+			done, _ := runUntilCrash(t, dir, ops, crashAfter)
+
+			bm := openLogged(t, dir)
+			tree, err := bplustree.New(bm, "btree_1", bplustree.WithMaxKeys(4))
+			if err != nil {
+				t.Fatalf("New after recovery failed: %v", err)
+			}
+			got := btreetest.Contents(t, tree)
+
+			// A tree whose creation failed comes back empty.
+			if done < 0 {
+				done = 0
+			}
+			before, after := applyOps(ops[:done]), applyOps(ops[:done])
+			if done < len(ops) {
+				after = applyOps(ops[:done+1])
+			}
+			if !reflect.DeepEqual(got, before) && !reflect.DeepEqual(got, after) {
+				t.Fatalf("Expected the tree after %d or %d operations, got %v", done, done+1, got)
+			}
+
+			// The recovered store keeps working and closes cleanly.
+			if err := tree.Insert(1000, 1); err != nil {
+				t.Fatalf("Insert after recovery failed: %v", err)
+			}
+			if err := bm.(interface{ Close() error }).Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// buffermanager/track.go
+package buffermanager
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PinInfo describes a pin that has not been released yet.
+type PinInfo struct {
+	BTreeID string
+	PageID  PageID
+	Mode    PinMode
+	Time    time.Time // When the page was pinned
+	Stack   string    // Call stack of the pin, innermost call first
+}
+
+// PinTracker is implemented by buffer managers that can tell who holds
+// their pins, to track down pins that are never released.
+type PinTracker interface {
+	// PinnedPages returns the pins that have not been released yet, oldest
+	// first. It returns nil unless the manager was created with
+	// WithPinTracking.
+	PinnedPages() []PinInfo
+}
+
+// WithPinTracking records the call stack and time of every pin, which
+// PinnedPages reports and CloseBTree and DeleteBTree include in the error
+// they return while pages of the BTree are pinned. Capturing a stack on
+// every pin is slow, so this is meant for debugging and tests.
+func WithPinTracking() Option {
+	return func(config *bufferManagerConfig) {
+		config.trackPins = true
+	}
+}
+
+// PinLeakError is returned, wrapped, by CloseBTree and DeleteBTree when
+// pages of the BTree are still pinned. With WithPinTracking, Pins describes
+// every pin of the BTree; otherwise it is empty.
+type PinLeakError struct {
+	Pins []PinInfo
+}
+
+func (e *PinLeakError) Error() string {
+	var b strings.Builder
+	b.WriteString("pages still pinned")
+	for _, pin := range e.Pins {
+		mode := "shared"
+		if pin.Mode == PinExclusive {
+			mode = "exclusive"
+		}
+		fmt.Fprintf(&b, "\npage %d pinned %s at %s by:\n%s", pin.PageID, mode, pin.Time.Format(time.RFC3339Nano), pin.Stack)
+	}
+	return b.String()
+}
+
+// pinTracker records the pins handed out by a buffer manager created with
+// WithPinTracking. The zero value tracks nothing.
+type pinTracker struct {
+	mu   sync.Mutex
+	pins map[*pageHandle]trackedPin // nil unless tracking
+	next uint64                     // Sequence number of the next pin
+}
+
+// trackedPin is a recorded pin. Its stack is only formatted when reported.
+type trackedPin struct {
+	seq   uint64 // Orders pins taken within the resolution of the clock
+	time  time.Time
+	stack []uintptr
+}
+
+// newPinTracker returns a tracker that records pins if enabled is set.
+func newPinTracker(enabled bool) *pinTracker {
+	if !enabled {
+		return &pinTracker{}
+	}
+	return &pinTracker{pins: make(map[*pageHandle]trackedPin)}
+}
+
+// track records the pin of h. It must be called directly from PinPage or
+// PinPageContext, so the recorded stack starts at their caller.
+func (t *pinTracker) track(h *pageHandle) {
+	if t.pins == nil {
+		return
+	}
+	stack := make([]uintptr, 32)
+	stack = stack[:runtime.Callers(3, stack)]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pins[h] = trackedPin{seq: t.next, time: time.Now(), stack: stack}
+	t.next++
+}
+
+// forget drops the record of the pin of h once it is released.
+func (t *pinTracker) forget(h *pageHandle) {
+	if t.pins == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.pins, h)
+}
+
+// pinned returns the pins that keep matches, oldest first.
+func (t *pinTracker) pinned(keep func(h *pageHandle) bool) []PinInfo {
+	if t.pins == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var kept []*pageHandle
+	for h := range t.pins {
+		if keep(h) {
+			kept = append(kept, h)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return t.pins[kept[i]].seq < t.pins[kept[j]].seq })
+	pins := make([]PinInfo, len(kept))
+	for i, h := range kept {
+		pins[i] = PinInfo{
+			BTreeID: h.btreeID,
+			PageID:  h.pageID,
+			Mode:    h.mode,
+			Time:    t.pins[h].time,
+			Stack:   formatStack(t.pins[h].stack),
+		}
+	}
+	return pins
+}
+
+// leak returns the error for pages of btreeID that are still pinned.
+func (t *pinTracker) leak(btreeID string) *PinLeakError {
+	return &PinLeakError{Pins: t.pinned(func(h *pageHandle) bool {
+		return h.btreeID == btreeID
+	})}
+}
+
+// formatStack formats program counters like a goroutine trace: each
+// function on one line and its file and line indented on the next.
+func formatStack(stack []uintptr) string {
+	if len(stack) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			return strings.TrimSuffix(b.String(), "\n")
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// buffermanager/twoqueue.go
+package buffermanager
+
+// twoQueuePolicy implements the full 2Q algorithm of Johnson and Shasha.
+// Pages seen once enter a FIFO (A1in) and are evicted from there first,
+// remembering their keys in A1out. Only a page that is requested again while
+// remembered is promoted to the LRU-managed hot queue (Am), so a long
+// sequential scan cannot flush the hot set.
+type twoQueuePolicy struct {
+	a1in  *frameList
+	am    *frameList
+	a1out *ghostList
+	keys  map[int]PageKey
+	kin   int // Target size of A1in
+	kout  int // Maximum size of A1out
+}
+
+// NewTwoQueuePolicy creates a scan-resistant 2Q replacement policy.
+func NewTwoQueuePolicy(frames int) ReplacementPolicy {
+	p := &twoQueuePolicy{
+		a1in:  newFrameList(),
+		am:    newFrameList(),
+		a1out: newGhostList(),
+		keys:  make(map[int]PageKey, frames),
+		kin:   frames / 4,
+		kout:  frames / 2,
+	}
+	if p.kin < 1 {
+		p.kin = 1
+	}
+	if p.kout < 1 {
+		p.kout = 1
+	}
+	return p
+}
+
+func (p *twoQueuePolicy) Insert(frame int, page PageKey) {
+	p.keys[frame] = page
+	if p.a1out.contains(page) {
+		p.a1out.remove(page)
+		p.am.pushBack(frame)
+		return
+	}
+	p.a1in.pushBack(frame)
+}
+
+func (p *twoQueuePolicy) Access(frame int) {
+	// Hits in A1in are deliberately ignored: correlated references shortly
+	// after the first one do not make a page hot.
+	p.am.moveToBack(frame)
+}
+
+func (p *twoQueuePolicy) Evict(evictable func(frame int) bool) (int, bool) {
+	if p.a1in.len() > p.kin {
+		if frame, found := p.evictA1in(evictable); found {
+			return frame, true
+		}
+		return p.evictAm(evictable)
+	}
+	if frame, found := p.evictAm(evictable); found {
+		return frame, true
+	}
+	return p.evictA1in(evictable)
+}
+
+func (p *twoQueuePolicy) evictA1in(evictable func(frame int) bool) (int, bool) {
+	frame, found := p.a1in.oldestEvictable(evictable)
+	if !found {
+		return 0, false
+	}
+	p.a1in.remove(frame)
+	p.a1out.pushBack(p.keys[frame])
+	if p.a1out.len() > p.kout {
+		p.a1out.removeOldest()
+	}
+	delete(p.keys, frame)
+	return frame, true
+}
+
+func (p *twoQueuePolicy) evictAm(evictable func(frame int) bool) (int, bool) {
+	frame, found := p.am.oldestEvictable(evictable)
+	if !found {
+		return 0, false
+	}
+	p.am.remove(frame)
+	delete(p.keys, frame)
+	return frame, true
+}
+
+func (p *twoQueuePolicy) Remove(frame int) {
+	p.a1in.remove(frame)
+	p.am.remove(frame)
+	delete(p.keys, frame)
+}
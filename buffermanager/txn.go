@@ -0,0 +1,139 @@
+// buffermanager/txn.go
+package buffermanager
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// Errors returned when starting or using a transaction.
+var (
+	ErrNoWriteAheadLog = errors.New("no write-ahead log configured")
+	ErrTxnInProgress   = errors.New("another transaction is in progress")
+	ErrTxnDone         = errors.New("transaction already committed or rolled back")
+)
+
+// Txn groups modifications of one or more BTrees into a unit that is
+// committed or rolled back as a whole. BTrees are named by their identifiers
+// and opened with the manager's TreeFactory. Changes are applied to the
+// pages as they are made, so reads through the Txn see its own writes.
+//
+// A Txn whose modification fails is rolled back, and every later call
+// returns ErrTxnDone.
+type Txn struct {
+	m    *fileBufferManager
+	done bool
+}
+
+// Begin starts a transaction. Rolling back relies on the write-ahead log, so
+// Begin returns ErrNoWriteAheadLog without one. Only one transaction can be
+// open at a time; while it is, modifications made directly through BTrees
+// of this manager become part of it.
+func (m *fileBufferManager) Begin() (*Txn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wal == nil {
+		return nil, ErrNoWriteAheadLog
+	}
+	if m.txn != nil {
+		return nil, ErrTxnInProgress
+	}
+	// Changes made before Begin are not part of the transaction.
+	if err := m.commit(); err != nil {
+		return nil, err
+	}
+	tx := &Txn{m: m}
+	m.txn = tx
+	return tx, nil
+}
+
+// tree returns the BTree btreeID, opening it if needed.
+func (tx *Txn) tree(btreeID string) (btree.BTree, error) {
+	if tx.done {
+		return nil, ErrTxnDone
+	}
+	return tx.m.OpenBTree(btreeID)
+}
+
+// fail rolls the transaction back after a modification failed with err,
+// unless the BTree already did.
+func (tx *Txn) fail(err error) error {
+	if err == nil || tx.done {
+		return err
+	}
+	if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+	}
+	return err
+}
+
+// Insert adds or updates a key-value pair in the BTree btreeID.
+func (tx *Txn) Insert(btreeID string, key uint64, value uint64) error {
+	b, err := tx.tree(btreeID)
+	if err != nil {
+		return err
+	}
+	return tx.fail(b.Insert(key, value))
+}
+
+// Delete removes key from the BTree btreeID and reports whether it was
+// present.
+func (tx *Txn) Delete(btreeID string, key uint64) (bool, error) {
+	b, err := tx.tree(btreeID)
+	if err != nil {
+		return false, err
+	}
+	found, err := b.Delete(key)
+	return found, tx.fail(err)
+}
+
+// Lookup finds the value associated with key in the BTree btreeID.
+func (tx *Txn) Lookup(btreeID string, key uint64) (uint64, bool, error) {
+	b, err := tx.tree(btreeID)
+	if err != nil {
+		return 0, false, err
+	}
+	value, found := b.Lookup(key)
+	return value, found, nil
+}
+
+// Scan retrieves the key-value pairs of the BTree btreeID with keys between
+// minKey and maxKey (inclusive), as BTree.Scan does. The scan must be drained
+// before the transaction commits or rolls back.
+func (tx *Txn) Scan(btreeID string, minKey uint64, maxKey uint64) (<-chan btree.KeyValuePair, btree.ScanErrFunc, error) {
+	b, err := tx.tree(btreeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.Scan(minKey, maxKey)
+}
+
+// Commit durably records every modification made in the transaction. If the
+// commit record cannot be written, the transaction is rolled back instead.
+func (tx *Txn) Commit() error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	tx.done = true
+	m := tx.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.txn = nil
+	if err := m.commit(); err != nil {
+		if rollbackErr := m.rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Rollback undoes every modification made in the transaction.
+func (tx *Txn) Rollback() error {
+	if tx.done {
+		return ErrTxnDone
+	}
+	return tx.m.Rollback()
+}
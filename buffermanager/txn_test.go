@@ -0,0 +1,236 @@
+// buffermanager/txn_test.go
+package buffermanager_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pillairaunak/btree-store-go/btree/bplustree"
+	"github.com/pillairaunak/btree-store-go/buffermanager"
+)
+
+// store is the part of the file buffer manager the transaction tests use.
+type store interface {
+	buffermanager.BufferManager
+	Begin() (*buffermanager.Txn, error)
+	Close() error
+}
+
+func openStore(t *testing.T, dir string, options ...buffermanager.Option) store {
+	t.Helper()
+	options = append([]buffermanager.Option{
+		buffermanager.WithDirectory(dir),
+		buffermanager.WithWriteAheadLog(),
+		buffermanager.WithTreeFactory(bplustree.Factory(bplustree.WithMaxKeys(4))),
+	}, options...)
+	bm, err := buffermanager.NewFileBufferManager(options...)
+	if err != nil {
+		t.Fatalf("NewFileBufferManager failed: %v", err)
+	}
+	return bm
+}
+
+// txnContents returns every pair of btreeID as seen by tx.
+func txnContents(t *testing.T, tx *buffermanager.Txn, btreeID string) map[uint64]uint64 {
+	t.Helper()
+	results, scanErr, err := tx.Scan(btreeID, 0, ^uint64(0))
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	contents := make(map[uint64]uint64)
+	for p := range results {
+		contents[p.Key] = p.Value
+	}
+	if err := scanErr(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	return contents
+}
+
+// storeContents returns every pair of btreeID, read in a transaction of its
+// own.
+func storeContents(t *testing.T, s store, btreeID string) map[uint64]uint64 {
+	t.Helper()
+	tx, err := s.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback()
+	return txnContents(t, tx, btreeID)
+}
+
+func TestTxn_CommitAndRollback(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	defer s.Close()
+	accounts, _ := s.CreateBTree()
+	audit, _ := s.CreateBTree()
+
+	// Test case 1: Writes to two trees are visible inside the transaction
+	// and after it commits.
+	tx, err := s.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	expected := make(map[uint64]uint64)
+	for k := uint64(0); k < 50; k++ {
+		if err := tx.Insert(accounts, k, k*10); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", k, err)
+		}
+		expected[k] = k * 10
+	}
+	if err := tx.Insert(audit, 1, 50); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if value, found, err := tx.Lookup(accounts, 7); err != nil || !found || value != 70 {
+		t.Errorf("Expected (70, true, nil) inside the transaction, got (%d, %v, %v)", value, found, err)
+	}
+	if got := txnContents(t, tx, accounts); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the transaction to see its own %d writes, got %d pairs", len(expected), len(got))
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Test case 2: A rolled back transaction leaves no trace in either tree,
+	// even after splitting and merging nodes.
+	tx, err = s.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	for k := uint64(0); k < 40; k++ {
+		if _, err := tx.Delete(accounts, k); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", k, err)
+		}
+	}
+	for k := uint64(100); k < 200; k++ {
+		if err := tx.Insert(accounts, k, k); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", k, err)
+		}
+	}
+	if err := tx.Insert(audit, 2, 100); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if found, err := tx.Delete(audit, 1); err != nil || !found {
+		t.Fatalf("Expected (true, nil) deleting audit entry, got (%v, %v)", found, err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if got := storeContents(t, s, accounts); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %d pairs after rollback, got %d", len(expected), len(got))
+	}
+	if got := storeContents(t, s, audit); !reflect.DeepEqual(got, map[uint64]uint64{1: 50}) {
+		t.Errorf("Expected only the committed audit entry after rollback, got %v", got)
+	}
+
+	// Test case 3: The trees keep working after a rollback.
+	tx, _ = s.Begin()
+	if err := tx.Insert(accounts, 500, 5); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	expected[500] = 5
+	if got := storeContents(t, s, accounts); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %d pairs, got %d", len(expected), len(got))
+	}
+}
+
+func TestTxn_Errors(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	defer s.Close()
+	btreeID, _ := s.CreateBTree()
+
+	// Test case 1: Only one transaction at a time
+	tx, err := s.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := s.Begin(); !errors.Is(err, buffermanager.ErrTxnInProgress) {
+		t.Errorf("Expected ErrTxnInProgress, got %v", err)
+	}
+
+	// Test case 2: A finished transaction cannot be used
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := tx.Insert(btreeID, 1, 1); !errors.Is(err, buffermanager.ErrTxnDone) {
+		t.Errorf("Expected ErrTxnDone from Insert, got %v", err)
+	}
+	if err := tx.Rollback(); !errors.Is(err, buffermanager.ErrTxnDone) {
+		t.Errorf("Expected ErrTxnDone from Rollback, got %v", err)
+	}
+
+	// Test case 3: Unknown trees
+	tx, _ = s.Begin()
+	if _, _, err := tx.Lookup("btree_99", 1); !errors.Is(err, buffermanager.ErrBTreeNotFound) {
+		t.Errorf("Expected ErrBTreeNotFound, got %v", err)
+	}
+	tx.Rollback()
+
+	// Test case 4: Transactions need a write-ahead log
+	bm, err := buffermanager.NewFileBufferManager(buffermanager.WithDirectory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewFileBufferManager failed: %v", err)
+	}
+	if _, err := bm.Begin(); !errors.Is(err, buffermanager.ErrNoWriteAheadLog) {
+		t.Errorf("Expected ErrNoWriteAheadLog, got %v", err)
+	}
+}
+
+func TestTxn_FailedOperationRollsBack(t *testing.T) {
+	// One frame is too few for a split, so some insert fails part way.
+	s := openStore(t, t.TempDir(), buffermanager.WithBufferSize(1))
+	defer s.Close()
+	btreeID, _ := s.CreateBTree()
+
+	tx, _ := s.Begin()
+	var err error
+	for k := uint64(0); k < 100 && err == nil; k++ {
+		err = tx.Insert(btreeID, k, k)
+	}
+	if !errors.Is(err, buffermanager.ErrBufferFull) {
+		t.Fatalf("Expected an insert to fail with ErrBufferFull, got %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, buffermanager.ErrTxnDone) {
+		t.Errorf("Expected the failed transaction to be rolled back, got %v", err)
+	}
+	if got := storeContents(t, s, btreeID); len(got) != 0 {
+		t.Errorf("Expected no pairs after the rollback, got %v", got)
+	}
+}
+
+func TestTxn_Durability(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	first, _ := s.CreateBTree()
+	second, _ := s.CreateBTree()
+
+	tx, _ := s.Begin()
+	for k := uint64(0); k < 30; k++ {
+		tx.Insert(first, k, k)
+		tx.Insert(second, k, k+1)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// The second transaction is still open when the process dies.
+	tx, _ = s.Begin()
+	for k := uint64(0); k < 30; k++ {
+		tx.Delete(first, k)
+		tx.Insert(second, k+100, k)
+	}
+	buffermanager.Crash(s)
+
+	s = openStore(t, dir)
+	defer s.Close()
+	if got := storeContents(t, s, first); len(got) != 30 {
+		t.Errorf("Expected 30 pairs in the first tree, got %d", len(got))
+	}
+	if got := storeContents(t, s, second); len(got) != 30 || got[0] != 1 {
+		t.Errorf("Expected the 30 committed pairs in the second tree, got %v", got)
+	}
+}
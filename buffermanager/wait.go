@@ -0,0 +1,105 @@
+// buffermanager/wait.go
+package buffermanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// frameQueue lines up the goroutines that wait in PinPageContext for a
+// buffer slot, so that slots freed by unpinning go to them in the order they
+// arrived. It is guarded by the mutex of its buffer manager.
+type frameQueue struct {
+	waiters []chan struct{}
+}
+
+// join appends a waiter and returns the channel on which it is woken once
+// it is first in line and a slot may have become free.
+func (q *frameQueue) join() chan struct{} {
+	wake := make(chan struct{}, 1)
+	q.waiters = append(q.waiters, wake)
+	return wake
+}
+
+// leave removes a waiter. If it was first in line, the next waiter is woken
+// in its place, so a slot it was woken for is not left unused.
+func (q *frameQueue) leave(wake chan struct{}) {
+	for i, w := range q.waiters {
+		if w == wake {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			if i == 0 {
+				q.notify()
+			}
+			return
+		}
+	}
+}
+
+// notify wakes the first waiter, if any, because a slot may have become
+// free. Waking a waiter that finds every slot taken again is harmless.
+func (q *frameQueue) notify() {
+	if len(q.waiters) == 0 {
+		return
+	}
+	select {
+	case q.waiters[0] <- struct{}{}:
+	default: // Already woken
+	}
+}
+
+// framePinner is implemented by the buffer managers whose PinPageContext
+// waits in a frameQueue.
+type framePinner interface {
+	// pin pins a page with the manager locked and the latch of the pin held.
+	pin(btreeID string, pageID PageID, mode PinMode) (*pageHandle, error)
+
+	// buffered reports whether a page is in the buffer, so that pinning it
+	// takes no slot. The manager is locked.
+	buffered(key PageKey) bool
+}
+
+// pinContext implements PinPageContext for p, which is guarded by mu, takes
+// the latches of its pins from latches and queues its waiters in q.
+//
+// A pin that needs a slot while others are waiting queues behind them. While
+// waiting, the latch of the pin is not held, so a waiter keeps no one from
+// the page it wants.
+func pinContext(ctx context.Context, p framePinner, mu *sync.Mutex, latches *latchTable, q *frameQueue, btreeID string, pageID PageID, mode PinMode) (*pageHandle, error) {
+	exclusive := mode == PinExclusive
+	key := PageKey{BTreeID: btreeID, PageID: pageID}
+	var wake chan struct{} // Non-nil once queued
+	for {
+		latches.LatchPage(btreeID, pageID, exclusive)
+		mu.Lock()
+		// A queued pinner only runs again once it is first in line.
+		if wake != nil || len(q.waiters) == 0 || p.buffered(key) {
+			h, err := p.pin(btreeID, pageID, mode)
+			if !errors.Is(err, ErrBufferFull) {
+				if wake != nil {
+					q.leave(wake)
+				}
+				mu.Unlock()
+				if err != nil {
+					latches.UnlatchPage(btreeID, pageID, exclusive)
+					return nil, err
+				}
+				return h, nil
+			}
+		}
+		if wake == nil {
+			wake = q.join()
+		}
+		mu.Unlock()
+		latches.UnlatchPage(btreeID, pageID, exclusive)
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			mu.Lock()
+			q.leave(wake)
+			mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,723 @@
+// buffermanager/wal.go
+package buffermanager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pillairaunak/btree-store-go/btree"
+)
+
+// WithWriteAheadLog makes the file buffer manager record page modifications
+// in a write-ahead log kept in the configured directory. A page reaches its
+// BTree file only after the log records describing its changes are durable,
+// and recovery on startup redoes committed changes and undoes uncommitted
+// ones, so a crash never leaves a BTree with part of a modification applied.
+func WithWriteAheadLog() Option {
+	return func(config *bufferManagerConfig) {
+		config.writeAheadLog = true
+	}
+}
+
+// Committer is implemented by buffer managers that group page modifications
+// into atomic units. A tree calls Commit whenever an operation leaves its
+// pages consistent, and Rollback when an operation fails part way.
+type Committer interface {
+	// Commit durably records every page modification made since the previous
+	// Commit, across all BTrees, as one unit. After a crash, recovery
+	// restores either all of them or none.
+	Commit() error
+
+	// Rollback undoes every page modification made since the previous
+	// Commit, across all BTrees.
+	Rollback() error
+}
+
+// LSN is a log sequence number: the position of a record in the write-ahead
+// log. LSNs keep increasing across restarts, and 0 means no record.
+type LSN uint64
+
+// Layout of the write-ahead log. The file starts with a header holding the
+// LSN of the first record in the file and the master record, the LSN of the
+// last complete checkpoint. The LSN of any other record is the LSN of the
+// first one plus the record's distance from it.
+//
+// Each record is framed by the length and CRC-32 of its body, which starts
+// with the record type, its LSN, its transaction and the previous LSN of that
+// transaction. A record that is incomplete or fails its checksum marks the
+// end of the log.
+const (
+	walFileName = "wal.log"
+
+	walMagic            uint64 = 0x6274726565776c67 // "btreewlg"
+	walHeaderSize              = 32
+	walRecordHeaderSize        = 8
+	walBodyHeaderSize          = 25
+	walMaxBodySize             = 1 << 24
+)
+
+// Log record types.
+const (
+	// recordUpdate holds the bytes of a page range before and after a change.
+	recordUpdate byte = iota + 1
+	// recordCompensation redoes the undo of an update. Its undoNext is the
+	// next record of the transaction left to undo.
+	recordCompensation
+	// recordCommit marks a transaction as committed.
+	recordCommit
+	// recordEnd marks a rolled back transaction as finished.
+	recordEnd
+	// recordBeginCheckpoint starts a fuzzy checkpoint.
+	recordBeginCheckpoint
+	// recordEndCheckpoint holds the transaction and dirty page tables as of
+	// the checkpoint.
+	recordEndCheckpoint
+)
+
+// walCheckpointSize is the amount of log written between checkpoints.
+const walCheckpointSize = 16 << 20
+
+// Errors reading the write-ahead log.
+var (
+	errEndOfLog   = errors.New("end of write-ahead log")
+	errCorruptLog = errors.New("corrupt write-ahead log")
+)
+
+// errCrashed is returned by a log that has simulated a crash.
+var errCrashed = errors.New("write-ahead log crashed")
+
+// logRecord is a decoded log record. Which fields are set depends on kind.
+type logRecord struct {
+	lsn     LSN
+	kind    byte
+	txn     uint64
+	prevLSN LSN
+
+	// Update and compensation records.
+	page     PageKey
+	offset   int
+	before   []byte
+	after    []byte
+	undoNext LSN
+
+	// End of checkpoint records.
+	transactions map[uint64]LSN  // Last LSN of each active transaction
+	dirtyPages   map[PageKey]LSN // Recovery LSN of each dirty page
+}
+
+// encodeBody returns the body of the record.
+func (r *logRecord) encodeBody() []byte {
+	body := make([]byte, walBodyHeaderSize, walBodyHeaderSize+len(r.before)+len(r.after)+32)
+	body[0] = r.kind
+	binary.LittleEndian.PutUint64(body[1:], uint64(r.lsn))
+	binary.LittleEndian.PutUint64(body[9:], r.txn)
+	binary.LittleEndian.PutUint64(body[17:], uint64(r.prevLSN))
+
+	switch r.kind {
+	case recordUpdate, recordCompensation:
+		body = appendPageKey(body, r.page)
+		body = appendUint16(body, uint16(r.offset))
+		body = appendUint16(body, uint16(len(r.after)))
+		if r.kind == recordUpdate {
+			body = append(body, r.before...)
+		} else {
+			body = appendUint64(body, uint64(r.undoNext))
+		}
+		body = append(body, r.after...)
+	case recordEndCheckpoint:
+		body = appendUint32(body, uint32(len(r.transactions)))
+		for txn, lastLSN := range r.transactions {
+			body = appendUint64(body, txn)
+			body = appendUint64(body, uint64(lastLSN))
+		}
+		body = appendUint32(body, uint32(len(r.dirtyPages)))
+		for key, recLSN := range r.dirtyPages {
+			body = appendPageKey(body, key)
+			body = appendUint64(body, uint64(recLSN))
+		}
+	}
+	return body
+}
+
+func appendPageKey(b []byte, key PageKey) []byte {
+	b = appendUint16(b, uint16(len(key.BTreeID)))
+	b = append(b, key.BTreeID...)
+	return appendUint64(b, uint64(key.PageID))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
+
+// decodeLogRecord decodes the body of a record.
+func decodeLogRecord(body []byte) (logRecord, error) {
+	d := logDecoder{buf: body}
+	r := logRecord{
+		kind:    d.byte(),
+		lsn:     LSN(d.uint64()),
+		txn:     d.uint64(),
+		prevLSN: LSN(d.uint64()),
+	}
+
+	switch r.kind {
+	case recordUpdate, recordCompensation:
+		r.page = d.pageKey()
+		r.offset = int(d.uint16())
+		length := int(d.uint16())
+		if r.kind == recordUpdate {
+			r.before = d.bytes(length)
+		} else {
+			r.undoNext = LSN(d.uint64())
+		}
+		r.after = d.bytes(length)
+		if r.offset+length > PageSize {
+			d.err = errCorruptLog
+		}
+	case recordEndCheckpoint:
+		r.transactions = make(map[uint64]LSN)
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			txn := d.uint64()
+			r.transactions[txn] = LSN(d.uint64())
+		}
+		r.dirtyPages = make(map[PageKey]LSN)
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			key := d.pageKey()
+			r.dirtyPages[key] = LSN(d.uint64())
+		}
+	case recordCommit, recordEnd, recordBeginCheckpoint:
+	default:
+		d.err = errCorruptLog
+	}
+
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = errCorruptLog
+	}
+	return r, d.err
+}
+
+// logDecoder reads fields from a record body, remembering the first error.
+type logDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *logDecoder) bytes(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = errCorruptLog
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *logDecoder) byte() byte {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *logDecoder) uint16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *logDecoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *logDecoder) uint64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *logDecoder) pageKey() PageKey {
+	id := d.bytes(int(d.uint16()))
+	return PageKey{BTreeID: string(id), PageID: PageID(d.uint64())}
+}
+
+// writeAheadLog appends records to the log file. Records are written as they
+// are appended but only become durable when flush syncs the file.
+type writeAheadLog struct {
+	path          string
+	file          *os.File
+	startLSN      LSN // LSN of the first record in the file
+	endLSN        LSN // LSN the next record will get
+	flushedLSN    LSN // Every record before this LSN is durable
+	checkpointLSN LSN // Begin record of the last complete checkpoint
+
+	// crashAfter is the number of records that may still be appended before
+	// the log simulates a crash by failing every later write. Negative
+	// disables it. Only tests set it.
+	crashAfter int
+	crashed    bool
+}
+
+// openWriteAheadLog opens the log at path, creating it if needed. A torn
+// record at the end of the log is cut off.
+func openWriteAheadLog(path string) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &writeAheadLog{path: path, file: file, startLSN: 1, crashAfter: -1}
+	if err := w.open(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// open reads the header and finds the end of the log.
+func (w *writeAheadLog) open() error {
+	header := make([]byte, walHeaderSize)
+	if _, err := w.file.ReadAt(header, 0); err != nil {
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+		// A new log, or one whose header never made it to disk.
+		w.endLSN = w.startLSN
+		w.flushedLSN = w.endLSN
+		return w.writeHeader()
+	}
+	if binary.LittleEndian.Uint64(header) != walMagic ||
+		crc32.ChecksumIEEE(header[:28]) != binary.LittleEndian.Uint32(header[28:]) {
+		return errors.New("not a write-ahead log")
+	}
+	w.startLSN = LSN(binary.LittleEndian.Uint64(header[8:]))
+	w.checkpointLSN = LSN(binary.LittleEndian.Uint64(header[16:]))
+
+	w.endLSN = w.startLSN
+	for {
+		_, size, err := w.read(w.endLSN)
+		if errors.Is(err, errEndOfLog) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		w.endLSN += LSN(size)
+	}
+	w.flushedLSN = w.endLSN
+	return w.file.Truncate(w.offset(w.endLSN))
+}
+
+// offset returns the file offset of the record at lsn.
+func (w *writeAheadLog) offset(lsn LSN) int64 {
+	return walHeaderSize + int64(lsn-w.startLSN)
+}
+
+// read returns the record at lsn and its size in the log, or errEndOfLog if
+// there is no intact record there.
+func (w *writeAheadLog) read(lsn LSN) (logRecord, int, error) {
+	if lsn < w.startLSN {
+		return logRecord{}, 0, fmt.Errorf("%w: LSN %d precedes the log", errCorruptLog, lsn)
+	}
+	header := make([]byte, walRecordHeaderSize)
+	if _, err := w.file.ReadAt(header, w.offset(lsn)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return logRecord{}, 0, errEndOfLog
+		}
+		return logRecord{}, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header)
+	if length < walBodyHeaderSize || length > walMaxBodySize {
+		return logRecord{}, 0, errEndOfLog
+	}
+	body := make([]byte, length)
+	if _, err := w.file.ReadAt(body, w.offset(lsn)+walRecordHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return logRecord{}, 0, errEndOfLog
+		}
+		return logRecord{}, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:]) {
+		return logRecord{}, 0, errEndOfLog
+	}
+	r, err := decodeLogRecord(body)
+	if err != nil || r.lsn != lsn {
+		return logRecord{}, 0, errEndOfLog
+	}
+	return r, walRecordHeaderSize + len(body), nil
+}
+
+// scan calls fn for every record from lsn to the end of the log.
+func (w *writeAheadLog) scan(lsn LSN, fn func(r *logRecord) error) error {
+	for lsn < w.endLSN {
+		r, size, err := w.read(lsn)
+		if err != nil {
+			return err
+		}
+		if err := fn(&r); err != nil {
+			return err
+		}
+		lsn += LSN(size)
+	}
+	return nil
+}
+
+// append writes r to the end of the log, setting and returning its LSN.
+func (w *writeAheadLog) append(r *logRecord) (LSN, error) {
+	if w.crashed || w.crashAfter == 0 {
+		w.crashed = true
+		return 0, errCrashed
+	}
+	if w.crashAfter > 0 {
+		w.crashAfter--
+	}
+
+	r.lsn = w.endLSN
+	body := r.encodeBody()
+	record := make([]byte, walRecordHeaderSize, walRecordHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(record, uint32(len(body)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.ChecksumIEEE(body))
+	record = append(record, body...)
+
+	if _, err := w.file.WriteAt(record, w.offset(r.lsn)); err != nil {
+		return 0, err
+	}
+	w.endLSN += LSN(len(record))
+	return r.lsn, nil
+}
+
+// flush makes every record up to and including lsn durable.
+func (w *writeAheadLog) flush(lsn LSN) error {
+	if w.crashed {
+		return errCrashed
+	}
+	if lsn < w.flushedLSN {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.flushedLSN = w.endLSN
+	return nil
+}
+
+// setCheckpoint durably records lsn as the begin record of the last complete
+// checkpoint.
+func (w *writeAheadLog) setCheckpoint(lsn LSN) error {
+	if w.crashed {
+		return errCrashed
+	}
+	w.checkpointLSN = lsn
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// truncate discards the records before lsn by copying the rest of the log to
+// a new file that replaces the old one.
+func (w *writeAheadLog) truncate(lsn LSN) error {
+	if err := w.flush(w.endLSN); err != nil {
+		return err
+	}
+	tail := make([]byte, w.endLSN-lsn)
+	if _, err := w.file.ReadAt(tail, w.offset(lsn)); err != nil {
+		return err
+	}
+
+	tmpPath := w.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	old := *w
+	w.file = tmp
+	w.startLSN = lsn
+	err = w.writeHeader()
+	if err == nil {
+		_, err = tmp.WriteAt(tail, walHeaderSize)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, w.path)
+	}
+	if err == nil {
+		err = syncDir(filepath.Dir(w.path))
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		*w = old
+		return err
+	}
+	return old.file.Close()
+}
+
+// reset empties the log once every change it holds has reached the BTree
+// files. LSNs continue from where the log left off.
+func (w *writeAheadLog) reset() error {
+	if w.crashed {
+		return errCrashed
+	}
+	w.startLSN = w.endLSN
+	w.checkpointLSN = 0
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.flushedLSN = w.endLSN
+	return nil
+}
+
+// writeHeader writes the log header.
+func (w *writeAheadLog) writeHeader() error {
+	header := make([]byte, walHeaderSize)
+	binary.LittleEndian.PutUint64(header, walMagic)
+	binary.LittleEndian.PutUint64(header[8:], uint64(w.startLSN))
+	binary.LittleEndian.PutUint64(header[16:], uint64(w.checkpointLSN))
+	binary.LittleEndian.PutUint32(header[28:], crc32.ChecksumIEEE(header[:28]))
+	_, err := w.file.WriteAt(header, 0)
+	return err
+}
+
+func (w *writeAheadLog) close() error {
+	return w.file.Close()
+}
+
+// syncDir makes renames within dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// transaction is an entry of the active transaction table.
+type transaction struct {
+	id       uint64
+	firstLSN LSN // Oldest record, which must stay in the log
+	lastLSN  LSN // Newest record, the previous LSN of the next one
+	undoNext LSN // Next record to undo while rolling back
+}
+
+// logUpdate logs the changes made to the frame at pos since they were last
+// logged, as part of the running transaction, and marks the frame dirty.
+// A transaction starts with its first update.
+func (m *fileBufferManager) logUpdate(pos int) error {
+	fr := &m.frames[pos]
+	start, end := diffRange(fr.logged, fr.data)
+	if start == end {
+		return nil
+	}
+
+	txn := m.activeTxn
+	if txn == nil {
+		txn = &transaction{id: m.nextTxnID}
+	}
+	lsn, err := m.wal.append(&logRecord{
+		kind:    recordUpdate,
+		txn:     txn.id,
+		prevLSN: txn.lastLSN,
+		page:    PageKey{BTreeID: fr.btreeID, PageID: fr.pageID},
+		offset:  start,
+		before:  fr.logged[start:end],
+		after:   fr.data[start:end],
+	})
+	if err != nil {
+		return err
+	}
+
+	if m.activeTxn == nil {
+		m.nextTxnID++
+		txn.firstLSN = lsn
+		m.activeTxn = txn
+		m.transactions[txn.id] = txn
+	}
+	txn.lastLSN = lsn
+	txn.undoNext = lsn
+	copy(fr.logged[start:end], fr.data[start:end])
+	m.markDirty(pos, lsn)
+	return nil
+}
+
+// markDirty records that the frame at pos was changed by the log record at
+// lsn, entering its page in the dirty page table if it is not there yet.
+func (m *fileBufferManager) markDirty(pos int, lsn LSN) {
+	fr := &m.frames[pos]
+	fr.dirty = true
+	fr.pageLSN = lsn
+	key := PageKey{BTreeID: fr.btreeID, PageID: fr.pageID}
+	if _, exists := m.dirtyPages[key]; !exists {
+		m.dirtyPages[key] = lsn
+	}
+}
+
+// diffRange returns the smallest range [start, end) outside which a and b
+// are equal.
+func diffRange(a, b []byte) (start, end int) {
+	end = len(a)
+	for start < end && a[start] == b[start] {
+		start++
+	}
+	for end > start && a[end-1] == b[end-1] {
+		end--
+	}
+	return start, end
+}
+
+// Commit writes a commit record for the transaction holding every change
+// logged since the previous Commit and syncs the log. Pages are written back
+// later, when they are evicted, checkpointed or their BTree is closed.
+// While a Txn is open, Commit does nothing and the changes become part of the
+// Txn. Without a write-ahead log Commit does nothing either.
+func (m *fileBufferManager) Commit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.commit()
+}
+
+// commit implements Commit.
+func (m *fileBufferManager) commit() error {
+	if m.wal == nil || m.activeTxn == nil || m.txn != nil {
+		return nil
+	}
+	txn := m.activeTxn
+	lsn, err := m.wal.append(&logRecord{kind: recordCommit, txn: txn.id, prevLSN: txn.lastLSN})
+	if err != nil {
+		return err
+	}
+	if err := m.wal.flush(lsn); err != nil {
+		return err
+	}
+	delete(m.transactions, txn.id)
+	m.activeTxn = nil
+
+	if m.wal.endLSN-m.wal.checkpointLSN >= walCheckpointSize {
+		return m.checkpoint()
+	}
+	return nil
+}
+
+// Rollback undoes every change logged since the previous Commit. While a Txn
+// is open, that is the whole Txn, which is then finished. Without a
+// write-ahead log Rollback does nothing.
+func (m *fileBufferManager) Rollback() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rollback()
+}
+
+// rollback implements Rollback.
+func (m *fileBufferManager) rollback() error {
+	if m.txn != nil {
+		m.txn.done = true
+		m.txn = nil
+	}
+	if m.wal == nil || m.activeTxn == nil {
+		return nil
+	}
+	if err := m.undo(map[uint64]*transaction{m.activeTxn.id: m.activeTxn}); err != nil {
+		return err
+	}
+	// Undo may have restored file headers and free lists, and even removed
+	// the pages a tree opened during the transaction initialized, so trees
+	// are built afresh by the next OpenBTree.
+	for _, f := range m.files {
+		f.loaded = false
+	}
+	m.btrees = make(map[string]btree.BTree)
+	return nil
+}
+
+// checkpoint takes a fuzzy checkpoint, logging the transaction and dirty
+// page tables without waiting for dirty pages to be written back. Unpinned
+// pages that have been dirty since before the previous checkpoint are
+// written back first so that the redo point keeps advancing, and the log is
+// then cut at the oldest record recovery could still need.
+func (m *fileBufferManager) checkpoint() error {
+	previous := m.wal.checkpointLSN
+	beginLSN, err := m.wal.append(&logRecord{kind: recordBeginCheckpoint})
+	if err != nil {
+		return err
+	}
+
+	for pos := range m.frames {
+		fr := &m.frames[pos]
+		if fr.inUse && fr.dirty && fr.pinCount == 0 &&
+			m.dirtyPages[PageKey{BTreeID: fr.btreeID, PageID: fr.pageID}] < previous {
+			if err := m.flushFrame(pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	end := &logRecord{
+		kind:         recordEndCheckpoint,
+		transactions: make(map[uint64]LSN),
+		dirtyPages:   make(map[PageKey]LSN),
+	}
+	keep := beginLSN
+	for id, txn := range m.transactions {
+		end.transactions[id] = txn.lastLSN
+		if txn.firstLSN < keep {
+			keep = txn.firstLSN
+		}
+	}
+	for key, recLSN := range m.dirtyPages {
+		end.dirtyPages[key] = recLSN
+		if recLSN < keep {
+			keep = recLSN
+		}
+	}
+	endLSN, err := m.wal.append(end)
+	if err != nil {
+		return err
+	}
+	if err := m.wal.flush(endLSN); err != nil {
+		return err
+	}
+
+	// Pages written back since the last sync left the dirty page table, so
+	// they must be durable before recovery starts from this checkpoint.
+	for _, f := range m.files {
+		if f.file != nil {
+			if err := f.file.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := m.wal.setCheckpoint(beginLSN); err != nil {
+		return err
+	}
+	if keep > m.wal.startLSN {
+		return m.wal.truncate(keep)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+// buffermanager/wal_test.go
+package buffermanager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// crash closes the files of bm without writing anything back, leaving the
+// directory as a process killed at this point would.
+func crash(bm *fileBufferManager) {
+	for _, f := range bm.files {
+		if f.file != nil {
+			f.file.Close()
+		}
+	}
+	bm.wal.close()
+}
+
+// copyDir copies the files of src into a new temporary directory.
+func copyDir(t *testing.T, src string) string {
+	t.Helper()
+	dst := t.TempDir()
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, entry.Name()), data, 0o644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	return dst
+}
+
+// filePage reads a page straight from the BTree file, bypassing the buffer.
+func filePage(t *testing.T, dir, btreeID string, pageID PageID) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, btreeID+fileExtension))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if int64(len(data)) < int64(pageID+1)*diskPageSize {
+		return make([]byte, PageSize)
+	}
+	return data[int64(pageID)*diskPageSize+pageHeaderSize : int64(pageID+1)*diskPageSize]
+}
+
+func TestWriteAheadLog_UncommittedChangesUndone(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir, WithWriteAheadLog(), WithBufferSize(2))
+	btreeID, _ := bm.CreateBTree()
+
+	var pageIDs []PageID
+	for i := 0; i < 4; i++ {
+		pageID, err := bm.AllocatePage(btreeID)
+		if err != nil {
+			t.Fatalf("AllocatePage failed: %v", err)
+		}
+		writePage(t, bm, btreeID, pageID, byte(i+1))
+		pageIDs = append(pageIDs, pageID)
+	}
+	if err := bm.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Uncommitted changes overflow the two frames, so evicting them writes
+	// them to the file.
+	for _, pageID := range pageIDs {
+		writePage(t, bm, btreeID, pageID, 9)
+	}
+	extra, err := bm.AllocatePage(btreeID)
+	if err != nil {
+		t.Fatalf("AllocatePage failed: %v", err)
+	}
+	writePage(t, bm, btreeID, extra, 9)
+	if !bytes.Equal(filePage(t, dir, btreeID, pageIDs[0]), bytes.Repeat([]byte{9}, PageSize)) {
+		t.Error("Expected the evicted uncommitted change to be written to the file")
+	}
+	// The uncommitted changes are still visible before the crash.
+	expectPage(t, bm, btreeID, pageIDs[0], 9)
+	crash(bm)
+
+	bm = newTestFileBufferManager(t, dir, WithWriteAheadLog())
+	for i, pageID := range pageIDs {
+		expectPage(t, bm, btreeID, pageID, byte(i+1))
+	}
+	if _, err := bm.PinPage(btreeID, extra, PinShared); err != ErrPageNotFound {
+		t.Errorf("Expected uncommitted allocation to be lost, got: %v", err)
+	}
+	if err := bm.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestWriteAheadLog_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir, WithWriteAheadLog())
+	btreeID, _ := bm.CreateBTree()
+	pageID, _ := bm.AllocatePage(btreeID)
+
+	writePage(t, bm, btreeID, pageID, 1)
+	if err := bm.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	firstEnd := bm.wal.offset(bm.wal.endLSN)
+	writePage(t, bm, btreeID, pageID, 2)
+	if err := bm.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	secondEnd := bm.wal.offset(bm.wal.endLSN)
+	crash(bm)
+
+	// Cutting the log anywhere inside the second unit loses that unit only.
+	cuts := []int64{firstEnd, firstEnd + 1, firstEnd + walRecordHeaderSize, firstEnd + PageSize, secondEnd - 1, secondEnd}
+	for _, cut := range cuts {
+		crashed := copyDir(t, dir)
+		if err := os.Truncate(filepath.Join(crashed, walFileName), cut); err != nil {
+			t.Fatalf("Truncate failed: %v", err)
+		}
+		expected := byte(1)
+		if cut == secondEnd {
+			expected = 2
+		}
+		recovered := newTestFileBufferManager(t, crashed, WithWriteAheadLog())
+		expectPage(t, recovered, btreeID, pageID, expected)
+		recovered.Close()
+	}
+
+	t.Run("GarbageAfterLastRecord", func(t *testing.T) {
+		crashed := copyDir(t, dir)
+		log, err := os.OpenFile(filepath.Join(crashed, walFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		log.Write(bytes.Repeat([]byte{0xab}, 100))
+		log.Close()
+
+		recovered := newTestFileBufferManager(t, crashed, WithWriteAheadLog())
+		expectPage(t, recovered, btreeID, pageID, 2)
+		recovered.Close()
+	})
+}
+
+func TestWriteAheadLog_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir, WithWriteAheadLog())
+	btreeID, _ := bm.CreateBTree()
+	pageID, _ := bm.AllocatePage(btreeID)
+	writePage(t, bm, btreeID, pageID, 7)
+	if err := bm.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Close commits, writes every page back and empties the log.
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size() != walHeaderSize {
+		t.Errorf("Expected an empty log after Close, got %d bytes", info.Size())
+	}
+	if !bytes.Equal(filePage(t, dir, btreeID, pageID), bytes.Repeat([]byte{7}, PageSize)) {
+		t.Error("Expected Close to write the page to the BTree file")
+	}
+
+	// LSNs keep increasing across restarts.
+	bm = newTestFileBufferManager(t, dir, WithWriteAheadLog())
+	if bm.wal.endLSN == 1 {
+		t.Error("Expected LSNs to continue after a restart")
+	}
+	expectPage(t, bm, btreeID, pageID, 7)
+	bm.Close()
+}
+
+func TestWriteAheadLog_FuzzyCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir, WithWriteAheadLog())
+	btreeID, _ := bm.CreateBTree()
+	first, _ := bm.AllocatePage(btreeID)
+	second, _ := bm.AllocatePage(btreeID)
+	writePage(t, bm, btreeID, first, 1)
+	writePage(t, bm, btreeID, second, 1)
+	if err := bm.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Test case 1: A checkpoint neither waits for dirty pages nor cuts off
+	// records that recovery still needs.
+	if err := bm.checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	if len(bm.dirtyPages) == 0 {
+		t.Error("Expected the first checkpoint to leave pages dirty")
+	}
+	if bm.wal.startLSN != 1 {
+		t.Errorf("Expected the log to start at LSN 1, got %d", bm.wal.startLSN)
+	}
+
+	// Test case 2: The next checkpoint writes back the pages dirty since
+	// before the first one and cuts the log at its begin record.
+	if err := bm.checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	if len(bm.dirtyPages) != 0 {
+		t.Errorf("Expected no dirty pages, got %d", len(bm.dirtyPages))
+	}
+	if bm.wal.startLSN != bm.wal.checkpointLSN {
+		t.Errorf("Expected the log to start at checkpoint LSN %d, got %d", bm.wal.checkpointLSN, bm.wal.startLSN)
+	}
+
+	// Test case 3: Records of a transaction active during a checkpoint are
+	// kept, and recovery starting from the checkpoint rolls it back.
+	writePage(t, bm, btreeID, first, 2)
+	if err := bm.checkpoint(); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	if bm.wal.startLSN > bm.activeTxn.firstLSN {
+		t.Errorf("Expected the log to keep LSN %d, got start %d", bm.activeTxn.firstLSN, bm.wal.startLSN)
+	}
+	writePage(t, bm, btreeID, second, 3)
+	if err := bm.flushFrame(bm.pageTable[PageKey{BTreeID: btreeID, PageID: second}]); err != nil {
+		t.Fatalf("flushFrame failed: %v", err)
+	}
+	crash(bm)
+
+	bm = newTestFileBufferManager(t, dir, WithWriteAheadLog())
+	expectPage(t, bm, btreeID, first, 1)
+	expectPage(t, bm, btreeID, second, 1)
+	bm.Close()
+}
+
+func TestWriteAheadLog_PageLSN(t *testing.T) {
+	dir := t.TempDir()
+	bm := newTestFileBufferManager(t, dir, WithWriteAheadLog())
+	btreeID, _ := bm.CreateBTree()
+	pageID, _ := bm.AllocatePage(btreeID)
+	writePage(t, bm, btreeID, pageID, 5)
+	if err := bm.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	pos := bm.pageTable[PageKey{BTreeID: btreeID, PageID: pageID}]
+	pageLSN := bm.frames[pos].pageLSN
+	if err := bm.flushFrame(pos); err != nil {
+		t.Fatalf("flushFrame failed: %v", err)
+	}
+
+	// The page is stored with the LSN of its last change, which redo uses to
+	// skip records the file already has.
+	buf := make([]byte, PageSize)
+	lsn, err := bm.files[btreeID].readPage(pageID, buf)
+	if err != nil {
+		t.Fatalf("readPage failed: %v", err)
+	}
+	if lsn == 0 || lsn != pageLSN {
+		t.Errorf("Expected page LSN %d in the file, got %d", pageLSN, lsn)
+	}
+	if bm.wal.flushedLSN <= lsn {
+		t.Errorf("Expected the log to be durable past LSN %d, got %d", lsn, bm.wal.flushedLSN)
+	}
+	bm.Close()
+}